@@ -22,6 +22,15 @@ func SetDefaults() {
 	viper.SetDefault(httpPort, "8080")
 	viper.SetDefault(sshInterface, "0.0.0.0")
 	viper.SetDefault(httpInterface, "0.0.0.0")
+	viper.SetDefault(httpBodyLimit, 512<<20)
+
+	viper.SetDefault(daemonEnabled, false)
+	viper.SetDefault(daemonPort, "9418")
+	viper.SetDefault(daemonInterface, "0.0.0.0")
+
+	viper.SetDefault(sumdbEnabled, false)
+	viper.SetDefault(sumdbName, "")
+	viper.SetDefault(sumdbKey, "")
 
 	viper.SetDefault(sshKeyPath, "/etc/grmpkg/grmpkg.rsa")
 	viper.SetDefault(sshUser, "git")
@@ -30,6 +39,10 @@ func SetDefaults() {
 	viper.SetDefault(logLevel, "INFO")
 	viper.SetDefault(logFile, true)
 
+	viper.SetDefault(packWindow, 10)
+	viper.SetDefault(packDepth, 50)
+	viper.SetDefault(packSpillThreshold, 1<<20)
+
 	viper.SetDefault(storageType, "memory")
 
 	viper.SetDefault(storageS3Endpoint, "")
@@ -49,6 +62,8 @@ func SetDefaults() {
 	viper.SetDefault(storageMySQL, "<user>:<pass>@tcp(<host>:<port>)/<database>?parseTime=True")
 	viper.SetDefault(storagePostgresql, "host=<host> port=<port> user=<user> dbname=<database> password=<pass>")
 
+	viper.SetDefault(policyDirectory, "")
+
 	viper.SetDefault(authenticationProvider, "github")
 	viper.SetDefault(authenticationGithubClientID, "")
 	viper.SetDefault(authenticationGithubClientSecret, "")
@@ -0,0 +1,33 @@
+package config
+
+import "github.com/spf13/viper"
+
+const (
+	daemonEnabled   = "daemon.enabled"
+	daemonInterface = "daemon.interface"
+	daemonPort      = "daemon.port"
+)
+
+func GetDaemonEnabled() bool {
+	return viper.GetBool(daemonEnabled)
+}
+
+func SetDaemonEnabled(e bool) {
+	viper.Set(daemonEnabled, e)
+}
+
+func GetDaemonInterface() string {
+	return viper.GetString(daemonInterface)
+}
+
+func SetDaemonInterface(i string) {
+	viper.Set(daemonInterface, i)
+}
+
+func GetDaemonPort() string {
+	return viper.GetString(daemonPort)
+}
+
+func SetDaemonPort(p string) {
+	viper.Set(daemonPort, p)
+}
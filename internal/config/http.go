@@ -5,6 +5,7 @@ import "github.com/spf13/viper"
 const (
 	httpInterface = "http.interface"
 	httpPort      = "http.port"
+	httpBodyLimit = "http.body_limit"
 )
 
 func GetHTTPInterface() string {
@@ -22,3 +23,13 @@ func GetHTTPPort() string {
 func SetHTTPPort(p string) {
 	viper.Set(httpPort, p)
 }
+
+// GetHTTPBodyLimit is the largest request body in bytes that the HTTP server
+// accepts, smart HTTP pushes send the whole packfile in one request.
+func GetHTTPBodyLimit() int {
+	return viper.GetInt(httpBodyLimit)
+}
+
+func SetHTTPBodyLimit(l int) {
+	viper.Set(httpBodyLimit, l)
+}
@@ -0,0 +1,38 @@
+package config
+
+import "github.com/spf13/viper"
+
+const (
+	packWindow         = "pack.window"
+	packDepth          = "pack.depth"
+	packSpillThreshold = "pack.spill_threshold"
+)
+
+// GetPackWindow is the number of preceding objects considered as a delta base
+// when generating a packfile.
+func GetPackWindow() int {
+	return viper.GetInt(packWindow)
+}
+
+func SetPackWindow(w int) {
+	viper.Set(packWindow, w)
+}
+
+// GetPackDepth is the maximum length of a delta chain in a generated packfile.
+func GetPackDepth() int {
+	return viper.GetInt(packDepth)
+}
+
+func SetPackDepth(d int) {
+	viper.Set(packDepth, d)
+}
+
+// GetPackSpillThreshold is the size in bytes above which objects received in
+// a push are held in a temporary file rather than in memory.
+func GetPackSpillThreshold() int64 {
+	return viper.GetInt64(packSpillThreshold)
+}
+
+func SetPackSpillThreshold(t int64) {
+	viper.Set(packSpillThreshold, t)
+}
@@ -0,0 +1,15 @@
+package config
+
+import "github.com/spf13/viper"
+
+const (
+	policyDirectory = "policy.directory"
+)
+
+func GetPolicyDirectory() string {
+	return viper.GetString(policyDirectory)
+}
+
+func SetPolicyDirectory(d string) {
+	viper.Set(policyDirectory, d)
+}
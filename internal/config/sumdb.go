@@ -0,0 +1,40 @@
+package config
+
+import "github.com/spf13/viper"
+
+const (
+	sumdbEnabled = "sumdb.enabled"
+	sumdbName    = "sumdb.name"
+	sumdbKey     = "sumdb.key"
+)
+
+func GetSumDBEnabled() bool {
+	return viper.GetBool(sumdbEnabled)
+}
+
+func SetSumDBEnabled(e bool) {
+	viper.Set(sumdbEnabled, e)
+}
+
+// GetSumDBName is the name of the checksum database in GOSUMDB, it defaults to
+// the domain.
+func GetSumDBName() string {
+	if n := viper.GetString(sumdbName); n != "" {
+		return n
+	}
+	return GetDomain()
+}
+
+func SetSumDBName(n string) {
+	viper.Set(sumdbName, n)
+}
+
+// GetSumDBKey is the note signer key of the checksum database, a key is
+// generated and kept in storage when it is empty.
+func GetSumDBKey() string {
+	return viper.GetString(sumdbKey)
+}
+
+func SetSumDBKey(k string) {
+	viper.Set(sumdbKey, k)
+}
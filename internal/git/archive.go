@@ -0,0 +1,348 @@
+package git
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/rs/zerolog/log"
+
+	"github.com/Jameslikestea/grm/internal/storage"
+)
+
+// ArchiveFormat is the container that WriteArchive produces.
+type ArchiveFormat int
+
+const (
+	ArchiveTar ArchiveFormat = iota
+	ArchiveTarGz
+	ArchiveZip
+)
+
+// ArchiveOptions are the arguments of git archive that GRM supports. Level is
+// the compression level, -1 selects the default.
+type ArchiveOptions struct {
+	Format  ArchiveFormat
+	Prefix  string
+	Paths   []string
+	Level   int
+	Treeish string
+}
+
+// ParseArchiveFormat accepts the format names of git archive.
+func ParseArchiveFormat(name string) (ArchiveFormat, error) {
+	switch name {
+	case "tar":
+		return ArchiveTar, nil
+	case "tgz", "tar.gz":
+		return ArchiveTarGz, nil
+	case "zip":
+		return ArchiveZip, nil
+	}
+	return ArchiveTar, fmt.Errorf("unknown archive format '%s'", name)
+}
+
+// ParseArchiveArguments parses the arguments that git archive --remote sends
+// to git-upload-archive.
+func ParseArchiveArguments(args []string) (ArchiveOptions, error) {
+	opts := ArchiveOptions{Format: ArchiveTar, Level: -1}
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--format="):
+			format, err := ParseArchiveFormat(strings.TrimPrefix(arg, "--format="))
+			if err != nil {
+				return opts, err
+			}
+			opts.Format = format
+		case strings.HasPrefix(arg, "--prefix="):
+			opts.Prefix = strings.TrimPrefix(arg, "--prefix=")
+		case len(arg) == 2 && arg[0] == '-' && arg[1] >= '0' && arg[1] <= '9':
+			opts.Level, _ = strconv.Atoi(arg[1:])
+		case strings.HasPrefix(arg, "-"):
+			return opts, fmt.Errorf("unsupported archive option '%s'", arg)
+		case opts.Treeish == "":
+			opts.Treeish = arg
+		default:
+			opts.Paths = append(opts.Paths, strings.Trim(arg, "/"))
+		}
+	}
+
+	if opts.Treeish == "" {
+		return opts, errors.New("missing tree-ish")
+	}
+	return opts, nil
+}
+
+// DecodeArchiveArguments reads the argument packets sent to
+// git-upload-archive up to the flush packet.
+func DecodeArchiveArguments(r io.Reader) ([]string, error) {
+	args := []string{}
+	for {
+		t, b, err := readPacket(r)
+		if err != nil {
+			return nil, err
+		}
+		if t == flushPacket {
+			return args, nil
+		}
+
+		line := strings.TrimSuffix(string(b), "\n")
+		if !strings.HasPrefix(line, "argument ") {
+			return nil, fmt.Errorf("unexpected line '%s'", line)
+		}
+		args = append(args, strings.TrimPrefix(line, "argument "))
+	}
+}
+
+// ResolveTreeish finds the reference that an archive request names, only
+// references can be archived so that unreachable objects are never exposed.
+// HEAD resolves to head when it names one of the references.
+func ResolveTreeish(refs []storage.Reference, head plumbing.ReferenceName, treeish string) (storage.Reference, bool) {
+	if treeish == plumbing.HEAD.String() {
+		treeish = head.String()
+	}
+	for _, ref := range refs {
+		if treeish != "" && (ref.Name.String() == treeish || ref.Name.Short() == treeish) {
+			return ref, true
+		}
+	}
+	return storage.Reference{}, false
+}
+
+// UploadArchive serves git-upload-archive. The arguments are read up to the
+// flush packet and acknowledged once the tree-ish has been resolved, after
+// which the archive is always multiplexed over the sideband. defaultTag is the
+// tag that the repository has configured for HEAD, if any.
+func UploadArchive(r io.Reader, w io.Writer, stor storage.Storage, repo string, defaultTag string) {
+	e := pktline.NewEncoder(w)
+
+	args, err := DecodeArchiveArguments(r)
+	if err != nil {
+		log.Warn().Err(err).Msg("Cannot decode upload-archive arguments")
+		e.Encodef("NACK %s\n", err)
+		return
+	}
+	log.Debug().Strs("args", args).Msg("upload-archive arguments")
+
+	opts, err := ParseArchiveArguments(args)
+	if err != nil {
+		e.Encodef("NACK %s\n", err)
+		return
+	}
+
+	refs, err := stor.ListReferences(repo)
+	if err != nil {
+		log.Error().Err(err).Msg("Cannot list references for upload-archive")
+	}
+	ref, ok := ResolveTreeish(refs, DefaultHead(refs, defaultTag), opts.Treeish)
+	if !ok {
+		e.Encodef("NACK not a valid object name: %s\n", opts.Treeish)
+		return
+	}
+
+	e.Encodef("ACK\n")
+	e.Flush()
+
+	sb := NewSideband(w, nil, map[string]string{"side-band-64k": ""})
+	if err := WriteArchive(sb, stor, repo, ref.Hash, opts); err != nil {
+		log.Error().Err(err).Str("ref", ref.Name.String()).Msg("Cannot write archive")
+		sb.Fatal("cannot archive %s: %s\n", ref.Name.Short(), err)
+	}
+	sb.Close()
+}
+
+// selected reports whether the path is covered by one of the paths, or is a
+// directory that leads to one of them.
+func (o ArchiveOptions) selected(f TreeFile) bool {
+	if len(o.Paths) == 0 {
+		return true
+	}
+	for _, p := range o.Paths {
+		if f.Path == p || strings.HasPrefix(f.Path, p+"/") {
+			return true
+		}
+		if f.IsDir() && strings.HasPrefix(p, f.Path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveFile is a single entry of an archive, Content is nil for directories.
+type archiveFile struct {
+	Name    string
+	Mode    os.FileMode
+	Content []byte
+}
+
+// WriteArchive writes the tree of the commit, or of the commit that an
+// annotated tag points at, as an archive. Entries use the commit time so that
+// an archive of the same commit is always identical.
+func WriteArchive(w io.Writer, stor storage.Storage, repo string, hash plumbing.Hash, opts ArchiveOptions) error {
+	c, err := ResolveCommit(stor, repo, hash)
+	if err != nil {
+		return err
+	}
+	modified := c.Committer.When
+
+	var add func(archiveFile) error
+	var finish func() error
+
+	switch opts.Format {
+	case ArchiveZip:
+		zw := zip.NewWriter(w)
+		zw.SetComment(c.Hash.String())
+		if opts.Level >= 0 {
+			zw.RegisterCompressor(
+				zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+					return flate.NewWriter(out, opts.Level)
+				},
+			)
+		}
+		add = func(f archiveFile) error {
+			return addZipFile(zw, f, modified)
+		}
+		finish = zw.Close
+	default:
+		out := w
+		var gw *gzip.Writer
+		if opts.Format == ArchiveTarGz {
+			level := opts.Level
+			if level < 0 {
+				level = gzip.DefaultCompression
+			}
+			gw, err = gzip.NewWriterLevel(w, level)
+			if err != nil {
+				return err
+			}
+			out = gw
+		}
+		tw := tar.NewWriter(out)
+		err = tw.WriteHeader(
+			&tar.Header{
+				Typeflag:   tar.TypeXGlobalHeader,
+				Name:       "pax_global_header",
+				PAXRecords: map[string]string{"comment": c.Hash.String()},
+				Format:     tar.FormatPAX,
+			},
+		)
+		if err != nil {
+			return err
+		}
+		add = func(f archiveFile) error {
+			return addTarFile(tw, f, modified)
+		}
+		finish = func() error {
+			if err := tw.Close(); err != nil {
+				return err
+			}
+			if gw != nil {
+				return gw.Close()
+			}
+			return nil
+		}
+	}
+
+	if strings.HasSuffix(opts.Prefix, "/") {
+		if err := add(archiveFile{Name: opts.Prefix, Mode: os.ModeDir | 0775}); err != nil {
+			return err
+		}
+	}
+
+	err = WalkTree(
+		stor, repo, c.TreeHash, func(f TreeFile) error {
+			if !opts.selected(f) {
+				if f.IsDir() {
+					return SkipDir
+				}
+				return nil
+			}
+
+			name := opts.Prefix + f.Path
+			switch f.Mode {
+			case filemode.Dir:
+				return add(archiveFile{Name: name + "/", Mode: os.ModeDir | 0775})
+			case filemode.Submodule:
+				// Submodules live in other repositories, git archives them as
+				// empty directories
+				return add(archiveFile{Name: name + "/", Mode: os.ModeDir | 0775})
+			}
+
+			content, err := ReadBlob(stor, repo, f.Hash)
+			if err != nil {
+				return err
+			}
+			mode := os.FileMode(0664)
+			switch f.Mode {
+			case filemode.Executable:
+				mode = 0775
+			case filemode.Symlink:
+				mode = os.ModeSymlink | 0777
+			}
+			return add(archiveFile{Name: name, Mode: mode, Content: content})
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	return finish()
+}
+
+func addTarFile(tw *tar.Writer, f archiveFile, modified time.Time) error {
+	h := &tar.Header{
+		Name:    f.Name,
+		Mode:    int64(f.Mode.Perm()),
+		ModTime: modified,
+		Format:  tar.FormatPAX,
+	}
+	switch {
+	case f.Mode.IsDir():
+		h.Typeflag = tar.TypeDir
+	case f.Mode&os.ModeSymlink != 0:
+		h.Typeflag = tar.TypeSymlink
+		h.Linkname = string(f.Content)
+	default:
+		h.Typeflag = tar.TypeReg
+		h.Size = int64(len(f.Content))
+	}
+
+	if err := tw.WriteHeader(h); err != nil {
+		return err
+	}
+	if h.Typeflag == tar.TypeReg {
+		_, err := tw.Write(f.Content)
+		return err
+	}
+	return nil
+}
+
+func addZipFile(zw *zip.Writer, f archiveFile, modified time.Time) error {
+	h := &zip.FileHeader{
+		Name:     f.Name,
+		Method:   zip.Deflate,
+		Modified: modified,
+	}
+	h.SetMode(f.Mode)
+	if f.Mode.IsDir() {
+		h.Method = zip.Store
+	}
+
+	fw, err := zw.CreateHeader(h)
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(f.Content)
+	return err
+}
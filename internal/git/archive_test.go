@@ -0,0 +1,241 @@
+package git
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/Jameslikestea/grm/internal/storage"
+	"github.com/Jameslikestea/grm/internal/storage/memory"
+)
+
+// archiveRepo stores a commit with a nested tree, an executable and a symlink
+// and returns the hash of the commit.
+func archiveRepo(stor storage.Storage, repo string) plumbing.Hash {
+	readme := blobObject("hello world\n")
+	script := blobObject("#!/bin/sh\n")
+	link := blobObject("README")
+	main := blobObject("package main\n")
+	sub := treeObject(object.TreeEntry{Name: "main.go", Mode: filemode.Regular, Hash: main.Hash})
+	root := treeObject(
+		object.TreeEntry{Name: "README", Mode: filemode.Regular, Hash: readme.Hash},
+		object.TreeEntry{Name: "build.sh", Mode: filemode.Executable, Hash: script.Hash},
+		object.TreeEntry{Name: "cmd", Mode: filemode.Dir, Hash: sub.Hash},
+		object.TreeEntry{Name: "link", Mode: filemode.Symlink, Hash: link.Hash},
+	)
+
+	sig := object.Signature{Name: "grm", Email: "grm@grmpkg.com", When: time.Unix(1600000000, 0).UTC()}
+	c := &object.Commit{Author: sig, Committer: sig, Message: "commit", TreeHash: root.Hash}
+	m := &plumbing.MemoryObject{}
+	c.Encode(m)
+	r, _ := m.Reader()
+	b, _ := ioutil.ReadAll(r)
+	commit := storage.Object{Hash: m.Hash(), Type: plumbing.CommitObject, Content: b}
+
+	stor.StoreObjects(repo, []storage.Object{readme, script, link, main, sub, root, commit})
+	return commit.Hash
+}
+
+func TestParseArchiveArguments(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    ArchiveOptions
+		wantErr bool
+	}{
+		{
+			name: "Defaults",
+			args: []string{"v1.0.0"},
+			want: ArchiveOptions{Format: ArchiveTar, Level: -1, Treeish: "v1.0.0"},
+		},
+		{
+			name: "All Options",
+			args: []string{"--format=zip", "--prefix=grm/", "-9", "v1.0.0", "cmd/"},
+			want: ArchiveOptions{
+				Format:  ArchiveZip,
+				Prefix:  "grm/",
+				Level:   9,
+				Treeish: "v1.0.0",
+				Paths:   []string{"cmd"},
+			},
+		},
+		{
+			name:    "Unknown Format",
+			args:    []string{"--format=rar", "v1.0.0"},
+			wantErr: true,
+		},
+		{
+			name:    "Unsupported Option",
+			args:    []string{"--remote=origin", "v1.0.0"},
+			wantErr: true,
+		},
+		{
+			name:    "Missing Treeish",
+			args:    []string{"--format=tar"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := ParseArchiveArguments(tt.args)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("ParseArchiveArguments() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
+				if err == nil && !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("ParseArchiveArguments() got = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func TestWriteArchive_Tar(t *testing.T) {
+	stor := memory.NewMemoryStorage()
+	commit := archiveRepo(stor, "ns/repo.git")
+
+	buf := &bytes.Buffer{}
+	err := WriteArchive(buf, stor, "ns/repo.git", commit, ArchiveOptions{Format: ArchiveTarGz, Prefix: "repo/", Level: -1})
+	if err != nil {
+		t.Fatalf("WriteArchive() error = %v", err)
+	}
+
+	gr, err := gzip.NewReader(buf)
+	if err != nil {
+		t.Fatalf("WriteArchive() did not write gzip: %v", err)
+	}
+	tr := tar.NewReader(gr)
+	got := map[string]*tar.Header{}
+	comment := ""
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("cannot read tar: %v", err)
+		}
+		if h.Typeflag == tar.TypeXGlobalHeader {
+			comment = h.PAXRecords["comment"]
+			continue
+		}
+		got[h.Name] = h
+	}
+	if comment != commit.String() {
+		t.Errorf("WriteArchive() comment = %s, want %s", comment, commit.String())
+	}
+
+	want := map[string]int64{
+		"repo/":            0775,
+		"repo/README":      0664,
+		"repo/build.sh":    0775,
+		"repo/cmd/":        0775,
+		"repo/cmd/main.go": 0664,
+		"repo/link":        0777,
+	}
+	if len(got) != len(want) {
+		t.Errorf("WriteArchive() wrote %d entries, want %d", len(got), len(want))
+	}
+	for name, mode := range want {
+		h, ok := got[name]
+		if !ok {
+			t.Errorf("WriteArchive() is missing %s", name)
+			continue
+		}
+		if h.Mode != mode {
+			t.Errorf("WriteArchive() %s mode = %o, want %o", name, h.Mode, mode)
+		}
+		if !h.ModTime.Equal(time.Unix(1600000000, 0)) {
+			t.Errorf("WriteArchive() %s modified = %v", name, h.ModTime)
+		}
+	}
+	if h := got["repo/link"]; h != nil && (h.Typeflag != tar.TypeSymlink || h.Linkname != "README") {
+		t.Errorf("WriteArchive() did not write the symlink")
+	}
+}
+
+func TestWriteArchive_Zip(t *testing.T) {
+	stor := memory.NewMemoryStorage()
+	commit := archiveRepo(stor, "ns/repo.git")
+
+	buf := &bytes.Buffer{}
+	err := WriteArchive(
+		buf, stor, "ns/repo.git", commit, ArchiveOptions{Format: ArchiveZip, Paths: []string{"cmd"}, Level: -1},
+	)
+	if err != nil {
+		t.Fatalf("WriteArchive() error = %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("WriteArchive() did not write a zip: %v", err)
+	}
+	if zr.Comment != commit.String() {
+		t.Errorf("WriteArchive() comment = %s, want %s", zr.Comment, commit.String())
+	}
+
+	names := []string{}
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if want := []string{"cmd/", "cmd/main.go"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("WriteArchive() wrote %v, want %v", names, want)
+	}
+
+	rc, _ := zr.File[1].Open()
+	b, _ := ioutil.ReadAll(rc)
+	if string(b) != "package main\n" {
+		t.Errorf("WriteArchive() content = %q", b)
+	}
+}
+
+func TestUploadArchive(t *testing.T) {
+	stor := memory.NewMemoryStorage()
+	commit := archiveRepo(stor, "ns/repo.git")
+	stor.StoreReferences("ns/repo.git", []storage.Reference{{Name: "refs/tags/v1.0.0", Hash: commit}})
+
+	tests := []struct {
+		name   string
+		reader string
+		want   string
+	}{
+		{
+			name:   "Unknown Tag",
+			reader: "0014argument v2.0.0\n0000",
+			want:   "0029NACK not a valid object name: v2.0.0\n",
+		},
+		{
+			name:   "Invalid Argument",
+			reader: "001aargument --format=rar\n0000",
+			want:   "0026NACK unknown archive format 'rar'\n",
+		},
+		{
+			name:   "Tag",
+			reader: "0014argument v1.0.0\n0000",
+			want:   "0008ACK\n0000",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				w := &bytes.Buffer{}
+				UploadArchive(strings.NewReader(tt.reader), w, stor, "ns/repo.git", "")
+				if !strings.HasPrefix(w.String(), tt.want) {
+					t.Errorf("UploadArchive() wrote = %q, want prefix %q", w.String(), tt.want)
+				}
+			},
+		)
+	}
+}
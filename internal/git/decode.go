@@ -0,0 +1,24 @@
+package git
+
+import (
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/Jameslikestea/grm/internal/storage"
+)
+
+// decodeObject decodes the content of a stored object into o, as long as the
+// object has the expected type.
+func decodeObject(obj storage.Object, t plumbing.ObjectType, o interface {
+	Decode(plumbing.EncodedObject) error
+}) bool {
+	if obj.Type != t {
+		return false
+	}
+
+	m := &plumbing.MemoryObject{}
+	m.SetType(obj.Type)
+	m.SetSize(int64(len(obj.Content)))
+	m.Write(obj.Content)
+
+	return o.Decode(m) == nil
+}
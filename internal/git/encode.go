@@ -2,24 +2,127 @@ package git
 
 import (
 	"io"
+	"sort"
 
 	"gg-scm.io/pkg/git/packfile"
+	gitpackfile "github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/rs/zerolog/log"
 
+	"github.com/Jameslikestea/grm/internal/config"
 	"github.com/Jameslikestea/grm/internal/storage"
 )
 
-func EncodePackfile(w io.Writer, objs []storage.Object) {
-	writer := packfile.NewWriter(w, uint32(len(objs)))
+// PackOptions controls how objects are compressed against each other when a
+// packfile is generated. A window of 0 disables deltas.
+type PackOptions struct {
+	Window int
+	Depth  int
+}
+
+// DefaultPackOptions returns the pack options from the server configuration.
+func DefaultPackOptions() PackOptions {
+	return PackOptions{
+		Window: config.GetPackWindow(),
+		Depth:  config.GetPackDepth(),
+	}
+}
+
+// packEntry is an object in the order that it will be written to the pack,
+// base is the index of the entry that the delta applies to or -1.
+type packEntry struct {
+	obj   storage.Object
+	base  int
+	delta []byte
+	depth int
+}
 
-	for _, obj := range objs {
+func EncodePackfile(w io.Writer, objs []storage.Object, opts PackOptions) {
+	entries := selectDeltas(objs, opts)
+	writer := packfile.NewWriter(w, uint32(len(entries)))
+	offsets := make([]int64, len(entries))
+
+	deltas := 0
+	for i, entry := range entries {
 		hdr := &packfile.Header{
-			Type: packfile.ObjectType(obj.Type),
-			Size: int64(len(obj.Content)),
+			Type: packfile.ObjectType(entry.obj.Type),
+			Size: int64(len(entry.obj.Content)),
+		}
+		content := entry.obj.Content
+
+		if entry.base >= 0 {
+			hdr = &packfile.Header{
+				Type:       packfile.OffsetDelta,
+				Size:       int64(len(entry.delta)),
+				BaseOffset: offsets[entry.base],
+			}
+			content = entry.delta
+			deltas++
+		}
+
+		offset, err := writer.WriteHeader(hdr)
+		if err != nil {
+			log.Error().Err(err).Msg("Cannot write object header")
+			return
 		}
+		offsets[i] = offset
 
-		writer.WriteHeader(hdr)
-		writer.Write(obj.Content)
+		if _, err := writer.Write(content); err != nil {
+			log.Error().Err(err).Msg("Cannot write object")
+			return
+		}
 	}
 
 	writer.Close()
+	log.Debug().Int("objects", len(entries)).Int("deltas", deltas).Msg("Encoded packfile")
+}
+
+// selectDeltas orders the objects so that similar objects are next to each
+// other and then picks, for each object, the base within the window that gives
+// the smallest delta. Bases always come before the objects that use them so
+// that they can be referenced by offset.
+func selectDeltas(objs []storage.Object, opts PackOptions) []packEntry {
+	entries := make([]packEntry, len(objs))
+	for i, obj := range objs {
+		entries[i] = packEntry{obj: obj, base: -1}
+	}
+
+	// Objects of the same type are the only valid bases, larger objects go
+	// first as removing data is cheaper to encode than adding it.
+	sort.SliceStable(
+		entries, func(i, j int) bool {
+			if entries[i].obj.Type != entries[j].obj.Type {
+				return entries[i].obj.Type < entries[j].obj.Type
+			}
+			return len(entries[i].obj.Content) > len(entries[j].obj.Content)
+		},
+	)
+
+	for i := range entries {
+		target := entries[i].obj.Content
+		// A delta is only worthwhile if it is well under the size of the object
+		limit := len(target)/2 - 20
+
+		for j := i - 1; j >= 0 && j >= i-opts.Window; j-- {
+			base := entries[j]
+			if base.obj.Type != entries[i].obj.Type || base.depth >= opts.Depth {
+				continue
+			}
+			// Skip bases that are so much larger that little can be shared
+			if len(target) < len(base.obj.Content)/32 {
+				continue
+			}
+
+			delta := gitpackfile.DiffDelta(base.obj.Content, target)
+			if len(delta) >= limit {
+				continue
+			}
+			if entries[i].base == -1 || len(delta) < len(entries[i].delta) {
+				entries[i].base = j
+				entries[i].delta = delta
+				entries[i].depth = base.depth + 1
+			}
+		}
+	}
+
+	return entries
 }
@@ -0,0 +1,122 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	gitpackfile "github.com/go-git/go-git/v5/plumbing/format/packfile"
+	gitmemory "github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/Jameslikestea/grm/internal/storage"
+)
+
+func blobObject(content string) storage.Object {
+	return storage.Object{
+		Hash:    plumbing.ComputeHash(plumbing.BlobObject, []byte(content)),
+		Type:    plumbing.BlobObject,
+		Content: []byte(content),
+	}
+}
+
+func TestSelectDeltas(t *testing.T) {
+	original := blobObject(strings.Repeat("package grm\n", 100))
+	changed := blobObject(strings.Repeat("package grm\n", 99) + "package foo\n")
+	other := blobObject("unrelated")
+
+	tests := []struct {
+		name       string
+		opts       PackOptions
+		wantDeltas int
+	}{
+		{
+			name:       "Deltas Disabled",
+			opts:       PackOptions{Window: 0, Depth: 50},
+			wantDeltas: 0,
+		},
+		{
+			name:       "Window",
+			opts:       PackOptions{Window: 10, Depth: 50},
+			wantDeltas: 1,
+		},
+		{
+			name:       "Depth Exhausted",
+			opts:       PackOptions{Window: 10, Depth: 0},
+			wantDeltas: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				entries := selectDeltas([]storage.Object{other, changed, original}, tt.opts)
+
+				deltas := 0
+				for i, entry := range entries {
+					if entry.base == -1 {
+						continue
+					}
+					deltas++
+					if entry.base >= i {
+						t.Errorf("selectDeltas() base %d is not before object %d", entry.base, i)
+					}
+					got, err := gitpackfile.PatchDelta(entries[entry.base].obj.Content, entry.delta)
+					if err != nil || !bytes.Equal(got, entry.obj.Content) {
+						t.Errorf("selectDeltas() delta does not reproduce the object")
+					}
+				}
+				if deltas != tt.wantDeltas {
+					t.Errorf("selectDeltas() deltas = %d, want %d", deltas, tt.wantDeltas)
+				}
+			},
+		)
+	}
+}
+
+func TestEncodePackfile(t *testing.T) {
+	objs := []storage.Object{blobObject("unrelated")}
+	for i := 0; i < 5; i++ {
+		objs = append(objs, blobObject(strings.Repeat("package grm\n", 100+i)))
+	}
+	objs = append(objs, commitObject("commit"))
+
+	b := &bytes.Buffer{}
+	EncodePackfile(b, objs, PackOptions{Window: 10, Depth: 50})
+
+	s := gitpackfile.NewScanner(bytes.NewReader(b.Bytes()))
+	_, n, err := s.Header()
+	if err != nil {
+		t.Fatalf("EncodePackfile() header error = %v", err)
+	}
+	deltas := 0
+	for i := uint32(0); i < n; i++ {
+		header, err := s.NextObjectHeader()
+		if err != nil {
+			t.Fatalf("EncodePackfile() object error = %v", err)
+		}
+		if header.Type == plumbing.OFSDeltaObject {
+			deltas++
+		}
+	}
+	if deltas == 0 {
+		t.Errorf("EncodePackfile() did not write any OFS_DELTA objects")
+	}
+
+	decoded := gitmemory.NewStorage()
+	if err := gitpackfile.UpdateObjectStorage(decoded, bytes.NewReader(b.Bytes())); err != nil {
+		t.Fatalf("EncodePackfile() cannot be decoded: %v", err)
+	}
+	for _, obj := range objs {
+		got, err := decoded.EncodedObject(plumbing.AnyObject, obj.Hash)
+		if err != nil {
+			t.Errorf("EncodePackfile() lost object %s", obj.Hash)
+			continue
+		}
+		r, _ := got.Reader()
+		content, _ := ioutil.ReadAll(r)
+		if got.Type() != obj.Type || !bytes.Equal(content, obj.Content) {
+			t.Errorf("EncodePackfile() object %s = %s %q, want %s %q", obj.Hash, got.Type(), content, obj.Type, obj.Content)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package git
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/Jameslikestea/grm/internal/storage"
+)
+
+// FilterType is the kind of partial clone filter requested by the client.
+type FilterType int
+
+const (
+	// FilterNone sends every reachable object.
+	FilterNone FilterType = iota
+	// FilterBlobNone omits every blob.
+	FilterBlobNone
+	// FilterBlobLimit omits blobs of at least Limit bytes.
+	FilterBlobLimit
+	// FilterTreeDepth omits trees and blobs at least Depth levels below the
+	// root tree of a commit.
+	FilterTreeDepth
+)
+
+// Filter describes the objects that a partial clone does not want to receive.
+// Omitted objects are left to be fetched on demand by later requests.
+type Filter struct {
+	Type  FilterType
+	Limit int64
+	Depth int
+}
+
+// ParseFilter parses a filter-spec such as blob:none, blob:limit=1m or tree:0.
+func ParseFilter(spec string) (Filter, error) {
+	switch {
+	case spec == "blob:none":
+		return Filter{Type: FilterBlobNone}, nil
+
+	case strings.HasPrefix(spec, "blob:limit="):
+		limit, err := parseFilterSize(strings.TrimPrefix(spec, "blob:limit="))
+		if err != nil {
+			return Filter{}, fmt.Errorf("invalid filter-spec '%s'", spec)
+		}
+		return Filter{Type: FilterBlobLimit, Limit: limit}, nil
+
+	case strings.HasPrefix(spec, "tree:"):
+		depth, err := strconv.Atoi(strings.TrimPrefix(spec, "tree:"))
+		if err != nil || depth < 0 {
+			return Filter{}, fmt.Errorf("invalid filter-spec '%s'", spec)
+		}
+		return Filter{Type: FilterTreeDepth, Depth: depth}, nil
+	}
+
+	return Filter{}, fmt.Errorf("unsupported filter-spec '%s'", spec)
+}
+
+// parseFilterSize parses a size with an optional k, m or g suffix.
+func parseFilterSize(s string) (int64, error) {
+	units := map[string]int64{"k": 1 << 10, "m": 1 << 20, "g": 1 << 30}
+
+	unit := int64(1)
+	if len(s) > 0 {
+		if u, ok := units[strings.ToLower(s[len(s)-1:])]; ok {
+			unit = u
+			s = s[:len(s)-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	return n * unit, nil
+}
+
+// omits reports whether the object, found depth levels below the root tree of
+// a commit, is left out of the pack. Commits and tags are always sent.
+func (f Filter) omits(obj storage.Object, depth int) bool {
+	switch f.Type {
+	case FilterBlobNone:
+		return obj.Type == plumbing.BlobObject
+	case FilterBlobLimit:
+		return obj.Type == plumbing.BlobObject && int64(len(obj.Content)) >= f.Limit
+	case FilterTreeDepth:
+		return (obj.Type == plumbing.TreeObject || obj.Type == plumbing.BlobObject) && depth >= f.Depth
+	}
+	return false
+}
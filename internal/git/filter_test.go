@@ -0,0 +1,157 @@
+package git
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/Jameslikestea/grm/internal/storage"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    Filter
+		wantErr bool
+	}{
+		{
+			name: "Blob None",
+			spec: "blob:none",
+			want: Filter{Type: FilterBlobNone},
+		},
+		{
+			name: "Blob Limit",
+			spec: "blob:limit=1024",
+			want: Filter{Type: FilterBlobLimit, Limit: 1024},
+		},
+		{
+			name: "Blob Limit With Unit",
+			spec: "blob:limit=2m",
+			want: Filter{Type: FilterBlobLimit, Limit: 2 << 20},
+		},
+		{
+			name: "Tree Depth",
+			spec: "tree:0",
+			want: Filter{Type: FilterTreeDepth, Depth: 0},
+		},
+		{
+			name:    "Invalid Limit",
+			spec:    "blob:limit=lots",
+			wantErr: true,
+		},
+		{
+			name:    "Unsupported",
+			spec:    "sparse:oid=1234",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := ParseFilter(tt.spec)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("ParseFilter() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("ParseFilter() got = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func treeObject(entries ...object.TreeEntry) storage.Object {
+	tree := &object.Tree{Entries: entries}
+	m := &plumbing.MemoryObject{}
+	tree.Encode(m)
+	r, _ := m.Reader()
+	b, _ := ioutil.ReadAll(r)
+	return storage.Object{Hash: m.Hash(), Type: plumbing.TreeObject, Content: b}
+}
+
+func TestFindNewObjects_Filter(t *testing.T) {
+	small := blobObject("small")
+	large := blobObject("a much larger blob that goes over the limit")
+	sub := treeObject(object.TreeEntry{Name: "large", Mode: filemode.Regular, Hash: large.Hash})
+	root := treeObject(
+		object.TreeEntry{Name: "small", Mode: filemode.Regular, Hash: small.Hash},
+		object.TreeEntry{Name: "sub", Mode: filemode.Dir, Hash: sub.Hash},
+	)
+
+	sig := object.Signature{Name: "grm", Email: "grm@grmpkg.com", When: time.Unix(0, 0).UTC()}
+	c := &object.Commit{Author: sig, Committer: sig, Message: "commit", TreeHash: root.Hash}
+	m := &plumbing.MemoryObject{}
+	c.Encode(m)
+	r, _ := m.Reader()
+	b, _ := ioutil.ReadAll(r)
+	commit := storage.Object{Hash: m.Hash(), Type: plumbing.CommitObject, Content: b}
+
+	cache := objectCache([]storage.Object{small, large, sub, root, commit})
+
+	tests := []struct {
+		name   string
+		wants  []plumbing.Hash
+		filter Filter
+		want   []plumbing.Hash
+	}{
+		{
+			name:  "No Filter",
+			wants: []plumbing.Hash{commit.Hash},
+			want:  []plumbing.Hash{commit.Hash, root.Hash, small.Hash, sub.Hash, large.Hash},
+		},
+		{
+			name:   "Blob None",
+			wants:  []plumbing.Hash{commit.Hash},
+			filter: Filter{Type: FilterBlobNone},
+			want:   []plumbing.Hash{commit.Hash, root.Hash, sub.Hash},
+		},
+		{
+			name:   "Blob Limit",
+			wants:  []plumbing.Hash{commit.Hash},
+			filter: Filter{Type: FilterBlobLimit, Limit: 10},
+			want:   []plumbing.Hash{commit.Hash, root.Hash, small.Hash, sub.Hash},
+		},
+		{
+			name:   "Tree 0",
+			wants:  []plumbing.Hash{commit.Hash},
+			filter: Filter{Type: FilterTreeDepth, Depth: 0},
+			want:   []plumbing.Hash{commit.Hash},
+		},
+		{
+			name:   "Tree 2",
+			wants:  []plumbing.Hash{commit.Hash},
+			filter: Filter{Type: FilterTreeDepth, Depth: 2},
+			want:   []plumbing.Hash{commit.Hash, root.Hash, small.Hash, sub.Hash},
+		},
+		{
+			name:   "Wanted Blob",
+			wants:  []plumbing.Hash{large.Hash},
+			filter: Filter{Type: FilterBlobNone},
+			want:   []plumbing.Hash{large.Hash},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got := map[plumbing.Hash]bool{}
+				for _, obj := range findNewObjects(cache, tt.wants, map[plumbing.Hash]bool{}, ShallowUpdate{}, tt.filter) {
+					got[obj.Hash] = true
+				}
+				want := map[plumbing.Hash]bool{}
+				for _, hash := range tt.want {
+					want[hash] = true
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("findNewObjects() got = %v, want %v", got, want)
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,45 @@
+package git
+
+import (
+	"github.com/go-git/go-git/v5/plumbing"
+	"golang.org/x/mod/semver"
+
+	"github.com/Jameslikestea/grm/internal/storage"
+)
+
+// DefaultHead picks the tag that the virtual HEAD points at. The preferred tag
+// is used when it exists, otherwise the highest semver release is chosen and
+// prereleases are only considered when there are no releases. An empty name
+// is returned when no tag qualifies.
+func DefaultHead(refs []storage.Reference, preferred string) plumbing.ReferenceName {
+	if preferred != "" {
+		for _, ref := range refs {
+			if ref.Name.IsTag() && (ref.Name.Short() == preferred || ref.Name.String() == preferred) {
+				return ref.Name
+			}
+		}
+	}
+
+	var release, prerelease plumbing.ReferenceName
+	for _, ref := range refs {
+		if !ref.Name.IsTag() {
+			continue
+		}
+		v := ref.Name.Short()
+		if !semver.IsValid(v) {
+			continue
+		}
+		if semver.Prerelease(v) == "" {
+			if release == "" || semver.Compare(v, release.Short()) > 0 {
+				release = ref.Name
+			}
+		} else if prerelease == "" || semver.Compare(v, prerelease.Short()) > 0 {
+			prerelease = ref.Name
+		}
+	}
+
+	if release != "" {
+		return release
+	}
+	return prerelease
+}
@@ -0,0 +1,63 @@
+package git
+
+import (
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/Jameslikestea/grm/internal/storage"
+)
+
+func TestDefaultHead(t *testing.T) {
+	refs := func(names ...string) []storage.Reference {
+		r := []storage.Reference{}
+		for _, name := range names {
+			r = append(r, storage.Reference{Name: plumbing.ReferenceName(name)})
+		}
+		return r
+	}
+
+	tests := []struct {
+		name      string
+		refs      []storage.Reference
+		preferred string
+		want      plumbing.ReferenceName
+	}{
+		{
+			name: "No Tags",
+			refs: nil,
+			want: "",
+		},
+		{
+			name: "Highest Release",
+			refs: refs("refs/tags/v1.10.0", "refs/tags/v1.9.0", "refs/tags/v2.0.0-rc.1", "refs/tags/latest"),
+			want: "refs/tags/v1.10.0",
+		},
+		{
+			name: "Only Prereleases",
+			refs: refs("refs/tags/v2.0.0-rc.1", "refs/tags/v2.0.0-rc.2"),
+			want: "refs/tags/v2.0.0-rc.2",
+		},
+		{
+			name:      "Preferred",
+			refs:      refs("refs/tags/v1.0.0", "refs/tags/v2.0.0"),
+			preferred: "v1.0.0",
+			want:      "refs/tags/v1.0.0",
+		},
+		{
+			name:      "Missing Preferred",
+			refs:      refs("refs/tags/v1.0.0", "refs/tags/v2.0.0"),
+			preferred: "v3.0.0",
+			want:      "refs/tags/v2.0.0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if got := DefaultHead(tt.refs, tt.preferred); got != tt.want {
+					t.Errorf("DefaultHead() = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,264 @@
+package git
+
+import (
+	"io"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/rs/zerolog/log"
+
+	"github.com/Jameslikestea/grm/internal/storage"
+)
+
+const (
+	ackSingle = iota
+	ackMulti
+	ackMultiDetailed
+)
+
+// Negotiator tracks the objects that the client and server have in common
+// while the client sends its haves.
+type Negotiator struct {
+	cache map[plumbing.Hash]storage.Object
+	wants []plumbing.Hash
+
+	multiAck int
+	noDone   bool
+
+	common    map[plumbing.Hash]bool
+	last      plumbing.Hash
+	ancestors map[plumbing.Hash]map[plumbing.Hash]bool
+	satisfied map[plumbing.Hash]bool
+}
+
+func NewNegotiator(cache map[plumbing.Hash]storage.Object, req UploadRequest) *Negotiator {
+	n := &Negotiator{
+		cache:     cache,
+		wants:     req.Wants,
+		multiAck:  ackSingle,
+		noDone:    req.Has("no-done"),
+		common:    map[plumbing.Hash]bool{},
+		ancestors: map[plumbing.Hash]map[plumbing.Hash]bool{},
+		satisfied: map[plumbing.Hash]bool{},
+	}
+
+	switch {
+	case req.Has("multi_ack_detailed"):
+		n.multiAck = ackMultiDetailed
+	case req.Has("multi_ack"):
+		n.multiAck = ackMulti
+	}
+
+	return n
+}
+
+// Common returns the objects that the client told us it has and that we also
+// have, these are the boundary of the pack we send.
+func (n *Negotiator) Common() map[plumbing.Hash]bool {
+	return n.common
+}
+
+// Negotiate reads the have lines sent by the client and acknowledges them. It
+// returns true once the client is ready to receive the pack. Stateless
+// transports end the negotiation at the first flush unless we are ready.
+func (n *Negotiator) Negotiate(r io.Reader, w io.Writer, stateless bool) bool {
+	s := pktline.NewScanner(r)
+	e := pktline.NewEncoder(w)
+
+	gotCommon, gotOther, sentReady := false, false, false
+
+	for s.Scan() {
+		line := strings.TrimSpace(string(s.Bytes()))
+
+		switch {
+		case line == "":
+			if n.multiAck == ackMultiDetailed && gotCommon && !gotOther && n.okToGiveUp() {
+				sentReady = true
+				e.Encodef("ACK %s ready\n", n.last.String())
+			}
+			if len(n.common) == 0 || n.multiAck != ackSingle {
+				e.Encodef("NAK\n")
+			}
+			if n.noDone && sentReady {
+				e.Encodef("ACK %s\n", n.last.String())
+				return true
+			}
+			if stateless {
+				return false
+			}
+			gotCommon, gotOther = false, false
+
+		case strings.HasPrefix(line, "have "):
+			hash := plumbing.NewHash(strings.TrimPrefix(line, "have "))
+			if _, ok := n.cache[hash]; !ok {
+				gotOther = true
+				if n.multiAck != ackSingle && n.okToGiveUp() {
+					if n.multiAck == ackMultiDetailed {
+						sentReady = true
+						e.Encodef("ACK %s ready\n", hash.String())
+					} else {
+						e.Encodef("ACK %s continue\n", hash.String())
+					}
+				}
+				continue
+			}
+
+			gotCommon = true
+			n.addCommon(hash)
+			switch {
+			case n.multiAck == ackMultiDetailed:
+				e.Encodef("ACK %s common\n", hash.String())
+			case n.multiAck == ackMulti:
+				e.Encodef("ACK %s continue\n", hash.String())
+			case len(n.common) == 1:
+				e.Encodef("ACK %s\n", hash.String())
+			}
+
+		case line == "done":
+			if len(n.common) == 0 {
+				e.Encodef("NAK\n")
+			} else if n.multiAck != ackSingle {
+				e.Encodef("ACK %s\n", n.last.String())
+			}
+			return true
+
+		default:
+			log.Warn().Str("line", line).Msg("Unexpected line during negotiation")
+		}
+	}
+
+	if s.Err() != nil {
+		log.Warn().Err(s.Err()).Msg("Cannot read haves")
+	}
+	return false
+}
+
+func (n *Negotiator) addCommon(hash plumbing.Hash) {
+	n.common[hash] = true
+	n.last = hash
+
+	for _, want := range n.wants {
+		if n.satisfied[want] {
+			continue
+		}
+		if n.ancestorsOf(want)[hash] {
+			n.satisfied[want] = true
+		}
+	}
+}
+
+// okToGiveUp reports whether every want has a common commit in its history,
+// at which point further haves will not make the pack any smaller.
+func (n *Negotiator) okToGiveUp() bool {
+	if len(n.common) == 0 {
+		return false
+	}
+	for _, want := range n.wants {
+		if !n.satisfied[want] {
+			return false
+		}
+	}
+	return true
+}
+
+// ancestorsOf lists every commit reachable from the hash, peeling tags on the
+// way, the result is cached as wants are checked after every have.
+func (n *Negotiator) ancestorsOf(hash plumbing.Hash) map[plumbing.Hash]bool {
+	if a, ok := n.ancestors[hash]; ok {
+		return a
+	}
+
+	a := map[plumbing.Hash]bool{}
+	queue := []plumbing.Hash{hash}
+	for len(queue) > 0 {
+		h := queue[0]
+		queue = queue[1:]
+		if a[h] {
+			continue
+		}
+		a[h] = true
+
+		obj, ok := n.cache[h]
+		if !ok {
+			continue
+		}
+
+		if c, ok := decodeCommit(obj); ok {
+			queue = append(queue, c.ParentHashes...)
+		}
+		if t, ok := decodeTag(obj); ok {
+			queue = append(queue, t.Target)
+		}
+	}
+
+	n.ancestors[hash] = a
+	return a
+}
+
+// UploadPack serves a protocol v0 upload-pack request once the references
+// have been advertised. It reads the wants, negotiates the haves and then
+// writes the packfile containing only the objects the client is missing.
+// Progress is written to stderr unless the client selected a sideband.
+func UploadPack(r io.Reader, w io.Writer, stderr io.Writer, stor storage.Storage, repo string, stateless bool) {
+	req, err := DecodeUploadRequest(r)
+	if err != nil {
+		log.Warn().Err(err).Msg("Cannot decode want list")
+		pktline.NewEncoder(w).Encodef("ERR %s\n", err)
+		return
+	}
+	if len(req.Wants) == 0 {
+		log.Debug().Msg("Client is up to date")
+		return
+	}
+
+	objs, err := stor.ListObjects(repo)
+	if err != nil {
+		log.Error().Err(err).Msg("Cannot get objects from store")
+		pktline.NewEncoder(w).Encodef("ERR cannot read repository %s\n", repo)
+		return
+	}
+	cache := objectCache(objs)
+
+	refs, err := stor.ListReferences(repo)
+	if err != nil {
+		log.Error().Err(err).Msg("Cannot get references from store")
+	}
+	if want, ok := unreachableWant(cache, refs, req.Wants); ok {
+		log.Warn().Str("want", want.String()).Msg("Client wants an object that is not reachable")
+		pktline.NewEncoder(w).Encodef("ERR upload-pack: not our ref %s\n", want.String())
+		return
+	}
+
+	shallow := ShallowUpdate{}
+	if req.Deepen() || len(req.Shallows) > 0 {
+		shallow = NewShallowUpdate(cache, refs, req)
+	}
+	if req.Deepen() {
+		e := pktline.NewEncoder(w)
+		shallow.Write(e)
+		e.Flush()
+	}
+
+	n := NewNegotiator(cache, req)
+	if !n.Negotiate(r, w, stateless) {
+		return
+	}
+
+	log.Info().Int("haves", len(n.Common())).Msg("Received Haves")
+	sb := NewSideband(w, stderr, req.Capabilities)
+	pack := findNewObjects(cache, req.Wants, n.Common(), shallow, req.Filter)
+	if req.Has("include-tag") {
+		pack = includeTags(cache, refs, pack, n.Common())
+	}
+	sb.Progress("Counting objects: %d, done.\n", len(pack))
+
+	opts := DefaultPackOptions()
+	if !req.Has("ofs-delta") {
+		opts.Window = 0
+	}
+	EncodePackfile(sb, pack, opts)
+	sb.Close()
+
+	log.Trace().Int("objs", len(pack)).Msg("Counted number of objects")
+}
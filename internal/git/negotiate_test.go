@@ -0,0 +1,226 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/Jameslikestea/grm/internal/storage"
+	"github.com/Jameslikestea/grm/internal/storage/memory"
+)
+
+// commitObject encodes a commit with the given parents so that negotiation can
+// walk a real history.
+func commitObject(msg string, parents ...plumbing.Hash) storage.Object {
+	sig := object.Signature{Name: "grm", Email: "grm@grmpkg.com", When: time.Unix(0, 0).UTC()}
+	c := &object.Commit{
+		Author:       sig,
+		Committer:    sig,
+		Message:      msg,
+		TreeHash:     plumbing.ZeroHash,
+		ParentHashes: parents,
+	}
+	m := &plumbing.MemoryObject{}
+	c.Encode(m)
+	r, _ := m.Reader()
+	b, _ := ioutil.ReadAll(r)
+	return storage.Object{Hash: m.Hash(), Type: plumbing.CommitObject, Content: b}
+}
+
+func TestNegotiator_Negotiate(t *testing.T) {
+	first := commitObject("first")
+	second := commitObject("second", first.Hash)
+	third := commitObject("third", second.Hash)
+	cache := objectCache([]storage.Object{first, second, third})
+
+	unknown := plumbing.NewHash("0000000000000000000000000000000043214321")
+
+	tests := []struct {
+		name      string
+		caps      map[string]string
+		stateless bool
+		reader    string
+		want      bool
+		wantW     string
+	}{
+		{
+			name:   "Clone",
+			caps:   map[string]string{"multi_ack_detailed": ""},
+			reader: "0009done\n",
+			want:   true,
+			wantW:  "0008NAK\n",
+		},
+		{
+			name:   "Single Ack",
+			caps:   map[string]string{},
+			reader: "0032have " + second.Hash.String() + "\n0009done\n",
+			want:   true,
+			wantW:  "0031ACK " + second.Hash.String() + "\n",
+		},
+		{
+			name:      "Stateless Without Common",
+			caps:      map[string]string{"multi_ack_detailed": ""},
+			stateless: true,
+			reader:    "0032have " + unknown.String() + "\n0000",
+			want:      false,
+			wantW:     "0008NAK\n",
+		},
+		{
+			name:      "Detailed Ready No Done",
+			caps:      map[string]string{"multi_ack_detailed": "", "no-done": ""},
+			stateless: true,
+			reader:    "0032have " + second.Hash.String() + "\n0000",
+			want:      true,
+			wantW: "0038ACK " + second.Hash.String() + " common\n" +
+				"0037ACK " + second.Hash.String() + " ready\n" +
+				"0008NAK\n" +
+				"0031ACK " + second.Hash.String() + "\n",
+		},
+		{
+			name:   "Detailed Done",
+			caps:   map[string]string{"multi_ack_detailed": ""},
+			reader: "0032have " + first.Hash.String() + "\n0000" + "0009done\n",
+			want:   true,
+			wantW: "0038ACK " + first.Hash.String() + " common\n" +
+				"0037ACK " + first.Hash.String() + " ready\n" +
+				"0008NAK\n" +
+				"0031ACK " + first.Hash.String() + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				n := NewNegotiator(cache, UploadRequest{Wants: []plumbing.Hash{third.Hash}, Capabilities: tt.caps})
+				w := &bytes.Buffer{}
+				if got := n.Negotiate(bytes.NewBufferString(tt.reader), w, tt.stateless); got != tt.want {
+					t.Errorf("Negotiate() = %v, want %v", got, tt.want)
+				}
+				if gotW := w.String(); gotW != tt.wantW {
+					t.Errorf("Negotiate() wrote = %q, want %q", gotW, tt.wantW)
+				}
+			},
+		)
+	}
+}
+
+func TestFindNewObjects(t *testing.T) {
+	first := commitObject("first")
+	second := commitObject("second", first.Hash)
+	third := commitObject("third", second.Hash)
+	cache := objectCache([]storage.Object{first, second, third})
+
+	got := findNewObjects(cache, []plumbing.Hash{third.Hash}, map[plumbing.Hash]bool{first.Hash: true}, ShallowUpdate{}, Filter{})
+	if len(got) != 2 {
+		t.Fatalf("findNewObjects() returned %d objects, want 2", len(got))
+	}
+	for _, obj := range got {
+		if obj.Hash == first.Hash {
+			t.Errorf("findNewObjects() returned an object the client has")
+		}
+	}
+}
+
+func TestIncludeTags(t *testing.T) {
+	first := commitObject("first")
+	second := commitObject("second", first.Hash)
+
+	tagObject := func(name string, target plumbing.Hash) storage.Object {
+		tag := &object.Tag{Name: name, Target: target, TargetType: plumbing.CommitObject, Message: name + "\n"}
+		m := &plumbing.MemoryObject{}
+		tag.Encode(m)
+		r, _ := m.Reader()
+		b, _ := ioutil.ReadAll(r)
+		return storage.Object{Hash: m.Hash(), Type: plumbing.TagObject, Content: b}
+	}
+	v1 := tagObject("v1.0.0", first.Hash)
+	v2 := tagObject("v2.0.0", second.Hash)
+	cache := objectCache([]storage.Object{first, second, v1, v2})
+
+	refs := []storage.Reference{
+		{Name: "refs/tags/v1.0.0", Hash: v1.Hash},
+		{Name: "refs/tags/v2.0.0", Hash: v2.Hash},
+	}
+
+	got := includeTags(cache, refs, []storage.Object{second}, map[plumbing.Hash]bool{})
+	if len(got) != 2 || got[1].Hash != v2.Hash {
+		t.Errorf("includeTags() did not add only the tag of the sent commit")
+	}
+
+	got = includeTags(cache, refs, []storage.Object{second}, map[plumbing.Hash]bool{v2.Hash: true})
+	if len(got) != 1 {
+		t.Errorf("includeTags() added a tag the client has")
+	}
+}
+
+func TestUploadPack_Wants(t *testing.T) {
+	stor := memory.NewMemoryStorage()
+	mod := blobObject("module grmpkg.com/ns/repo\n")
+	tree := treeObject(object.TreeEntry{Name: "go.mod", Mode: filemode.Regular, Hash: mod.Hash})
+
+	// Every commit has the same tree, so only the history differs
+	sig := object.Signature{Name: "grm", Email: "grm@grmpkg.com", When: time.Unix(0, 0).UTC()}
+	commit := func(msg string, parents ...plumbing.Hash) storage.Object {
+		c := &object.Commit{Author: sig, Committer: sig, Message: msg, TreeHash: tree.Hash, ParentHashes: parents}
+		m := &plumbing.MemoryObject{}
+		c.Encode(m)
+		r, _ := m.Reader()
+		b, _ := ioutil.ReadAll(r)
+		return storage.Object{Hash: m.Hash(), Type: plumbing.CommitObject, Content: b}
+	}
+	first := commit("first")
+	second := commit("second", first.Hash)
+	orphan := commit("orphan")
+	stor.StoreObjects("ns/repo.git", []storage.Object{mod, tree, first, second, orphan})
+	stor.CreateReferences("ns/repo.git", []storage.Reference{{Name: "refs/tags/v1.0.0", Hash: second.Hash}})
+
+	tests := []struct {
+		name    string
+		want    plumbing.Hash
+		wantErr bool
+	}{
+		{
+			name: "Tip",
+			want: second.Hash,
+		},
+		{
+			name: "Reachable",
+			want: first.Hash,
+		},
+		{
+			name:    "Unreachable",
+			want:    orphan.Hash,
+			wantErr: true,
+		},
+		{
+			name:    "Unknown",
+			want:    plumbing.NewHash("0000000000000000000000000000000043214321"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				wantErr := "ERR upload-pack: not our ref " + tt.want.String() + "\n"
+
+				w := &bytes.Buffer{}
+				r := strings.NewReader("0032want " + tt.want.String() + "\n00000009done\n")
+				UploadPack(r, w, nil, stor, "ns/repo.git", false)
+				if got := strings.Contains(w.String(), wantErr); got != tt.wantErr {
+					t.Errorf("UploadPack() = %q, wantErr %v", w.String(), tt.wantErr)
+				}
+
+				w.Reset()
+				Fetch(stor, "ns/repo.git", []string{"want " + tt.want.String(), "done"}, w)
+				if got := strings.Contains(w.String(), wantErr); got != tt.wantErr {
+					t.Errorf("Fetch() = %q, wantErr %v", w.String(), tt.wantErr)
+				}
+			},
+		)
+	}
+}
@@ -2,7 +2,6 @@ package git
 
 import (
 	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/rs/zerolog/log"
 
 	"github.com/Jameslikestea/grm/internal/storage"
@@ -19,37 +18,71 @@ func GetNewObjects(
 		log.Error().Err(err).Msg("Cannot get objects from store")
 		return nil
 	}
-	// cache := map[plumbing.Hash]storage.Object{}
-	// seen := map[plumbing.Hash]bool{}
-	//
-	// for _, obj := range objs {
-	// 	cache[obj.Hash] = obj
-	// }
-	//
-	// log.Info().Int("haves", len(haves)).Msg("Haves")
-	// for key := range haves {
-	// 	recurseFound(cache, seen, key)
-	// }
-	//
-	// log.Info().Int("seen", len(seen)).Msg("Found Seen")
-	//
-	// newObjs := map[plumbing.Hash]storage.Object{}
-	// for _, want := range wants {
-	// 	recurseFind(cache, newObjs, seen, want)
-	// }
-	//
-	// log.Info().Int("discovered", len(newObjs)).Msg("Found New")
-	// objList := make([]storage.Object, len(newObjs))
-	// i := 0
-	// for _, obj := range newObjs {
-	// 	objList[i] = obj
-	// 	i++
-	// }
-
-	return objs
+
+	return findNewObjects(objectCache(objs), wants, haves, ShallowUpdate{}, Filter{})
+}
+
+func objectCache(objs []storage.Object) map[plumbing.Hash]storage.Object {
+	cache := map[plumbing.Hash]storage.Object{}
+	for _, obj := range objs {
+		cache[obj.Hash] = obj
+	}
+	return cache
+}
+
+// findNewObjects returns every object that is reachable from the wants but not
+// from the haves, these are the objects that the client is missing. History is
+// not followed past the shallow boundaries of either side and objects excluded
+// by the filter are left out.
+func findNewObjects(
+	cache map[plumbing.Hash]storage.Object,
+	wants []plumbing.Hash,
+	haves map[plumbing.Hash]bool,
+	shallow ShallowUpdate,
+	filter Filter,
+) []storage.Object {
+	seen := map[plumbing.Hash]bool{}
+
+	log.Info().Int("haves", len(haves)).Msg("Haves")
+	for key := range haves {
+		recurseFound(cache, seen, shallow.client, key)
+	}
+	wants = append(wants, shallow.wants...)
+
+	log.Info().Int("seen", len(seen)).Msg("Found Seen")
+
+	newObjs := map[plumbing.Hash]storage.Object{}
+	walk := &packWalk{
+		cache:   cache,
+		objs:    newObjs,
+		seen:    seen,
+		shallow: shallow.boundary,
+		wanted:  map[plumbing.Hash]bool{},
+		filter:  filter,
+	}
+	for _, want := range wants {
+		walk.wanted[want] = true
+	}
+	for _, want := range wants {
+		walk.find(want, 0)
+	}
+
+	log.Info().Int("discovered", len(newObjs)).Msg("Found New")
+	objList := make([]storage.Object, len(newObjs))
+	i := 0
+	for _, obj := range newObjs {
+		objList[i] = obj
+		i++
+	}
+
+	return objList
 }
 
-func recurseFound(cache map[plumbing.Hash]storage.Object, seen map[plumbing.Hash]bool, hash plumbing.Hash) {
+func recurseFound(
+	cache map[plumbing.Hash]storage.Object,
+	seen, shallow map[plumbing.Hash]bool,
+	hash plumbing.Hash,
+) {
 	// Do nothing if we've already seen the hash, prevents circular searching
 	if _, ok := seen[hash]; ok {
 		log.Trace().Str("hash", hash.String()).Msg("Already seen hash")
@@ -61,78 +94,173 @@ func recurseFound(cache map[plumbing.Hash]storage.Object, seen map[plumbing.Hash
 		// We don't need to throw an error as this will be handled elsewhere
 		return
 	}
-	m := &plumbing.MemoryObject{}
 	switch obj.Type {
 	case plumbing.CommitObject:
 		log.Trace().Msg("Searching Commit")
-		m.SetType(obj.Type)
-		m.SetSize(int64(len(obj.Content)))
-		m.Write(obj.Content)
-
-		c := object.Commit{}
-		c.Decode(m)
-		recurseFound(cache, seen, c.TreeHash)
+		c, ok := decodeCommit(obj)
+		if !ok {
+			break
+		}
+		recurseFound(cache, seen, shallow, c.TreeHash)
+		if shallow[hash] {
+			break
+		}
 		for _, ph := range c.ParentHashes {
-			recurseFound(cache, seen, ph)
+			recurseFound(cache, seen, shallow, ph)
 		}
 
 	case plumbing.TreeObject:
 		log.Trace().Msg("Searching Tree")
-		m.SetType(obj.Type)
-		m.SetSize(int64(len(obj.Content)))
-		m.Write(obj.Content)
+		c, ok := decodeTree(obj)
+		if !ok {
+			break
+		}
+		for _, entry := range c.Entries {
+			recurseFound(cache, seen, shallow, entry.Hash)
+		}
+
+	case plumbing.TagObject:
+		log.Trace().Msg("Searching Tag")
+		t, ok := decodeTag(obj)
+		if !ok {
+			break
+		}
+		recurseFound(cache, seen, shallow, t.Target)
+	}
 
-		c := object.Tree{}
-		c.Decode(m)
+}
 
-		for _, entry := range c.Entries {
-			recurseFound(cache, seen, entry.Hash)
+// unreachableWant returns a want that is neither a reference nor reachable
+// from one. We advertise allow-reachable-sha1-in-want, so clients may ask for
+// any object in the history of a tag but nothing else.
+func unreachableWant(cache map[plumbing.Hash]storage.Object, refs []storage.Reference, wants []plumbing.Hash) (plumbing.Hash, bool) {
+	tips := map[plumbing.Hash]bool{}
+	for _, ref := range refs {
+		tips[ref.Hash] = true
+	}
+
+	var reachable map[plumbing.Hash]bool
+	for _, want := range wants {
+		if tips[want] {
+			continue
+		}
+		// Only walk the history once a want is not a tip
+		if reachable == nil {
+			reachable = map[plumbing.Hash]bool{}
+			for _, ref := range refs {
+				recurseFound(cache, reachable, nil, ref.Hash)
+			}
+		}
+		if _, ok := cache[want]; !ok || !reachable[want] {
+			return want, true
+		}
+	}
+	return plumbing.ZeroHash, false
+}
+
+// includeTags adds the annotated tags whose targets are being sent, so that
+// the client does not have to ask for them separately. Tags that the client
+// told us it has are skipped.
+func includeTags(
+	cache map[plumbing.Hash]storage.Object,
+	refs []storage.Reference,
+	pack []storage.Object,
+	haves map[plumbing.Hash]bool,
+) []storage.Object {
+	sent := map[plumbing.Hash]bool{}
+	for _, obj := range pack {
+		sent[obj.Hash] = true
+	}
+
+	for _, ref := range refs {
+		chain := []storage.Object{}
+		hash := ref.Hash
+		for !sent[hash] {
+			obj, ok := cache[hash]
+			if !ok {
+				break
+			}
+			t, ok := decodeTag(obj)
+			if !ok {
+				break
+			}
+			chain = append(chain, obj)
+			hash = t.Target
+		}
+		if !sent[hash] {
+			continue
+		}
+
+		for _, obj := range chain {
+			if sent[obj.Hash] || haves[obj.Hash] {
+				continue
+			}
+			log.Trace().Str("ref", ref.Name.String()).Str("hash", obj.Hash.String()).Msg("Including tag")
+			sent[obj.Hash] = true
+			pack = append(pack, obj)
 		}
 	}
 
+	return pack
 }
 
-func recurseFind(
-	cache, objs map[plumbing.Hash]storage.Object,
-	seen map[plumbing.Hash]bool,
-	hash plumbing.Hash,
-) {
-	if _, ok := seen[hash]; ok {
+// packWalk collects the objects reachable from the wants that the client does
+// not have. Objects named directly by a want are never filtered, this is how
+// a partial clone fetches the blobs and trees it was missing.
+type packWalk struct {
+	cache, objs           map[plumbing.Hash]storage.Object
+	seen, shallow, wanted map[plumbing.Hash]bool
+	filter                Filter
+}
+
+// find adds the object and everything it references, depth is the number of
+// levels below the root tree of a commit that the object was found at.
+func (p *packWalk) find(hash plumbing.Hash, depth int) {
+	if _, ok := p.seen[hash]; ok {
 		return
 	}
-	obj, ok := cache[hash]
+	obj, ok := p.cache[hash]
 	if !ok {
 		return
 	}
+	// Filtered objects are not marked as seen as they may be reachable at a
+	// shallower depth from another tree
+	if !p.wanted[hash] && p.filter.omits(obj, depth) {
+		return
+	}
 	// Setup the object and mark as now seen
-	objs[hash] = obj
-	seen[hash] = true
+	p.objs[hash] = obj
+	p.seen[hash] = true
 
-	m := &plumbing.MemoryObject{}
 	switch obj.Type {
 	case plumbing.CommitObject:
-		m.SetType(obj.Type)
-		m.SetSize(int64(len(obj.Content)))
-		m.Write(obj.Content)
-
-		c := object.Commit{}
-		c.Decode(m)
-		recurseFind(cache, objs, seen, c.TreeHash)
+		c, ok := decodeCommit(obj)
+		if !ok {
+			break
+		}
+		p.find(c.TreeHash, 0)
+		if p.shallow[hash] {
+			break
+		}
 		for _, ph := range c.ParentHashes {
-			recurseFind(cache, objs, seen, ph)
+			p.find(ph, 0)
 		}
 
 	case plumbing.TreeObject:
-		m.SetType(obj.Type)
-		m.SetSize(int64(len(obj.Content)))
-		m.Write(obj.Content)
-
-		c := object.Tree{}
-		c.Decode(m)
-
+		c, ok := decodeTree(obj)
+		if !ok {
+			break
+		}
 		for _, entry := range c.Entries {
-			recurseFind(cache, objs, seen, entry.Hash)
+			p.find(entry.Hash, depth+1)
+		}
+
+	case plumbing.TagObject:
+		t, ok := decodeTag(obj)
+		if !ok {
+			break
 		}
+		p.find(t.Target, 0)
 	}
 
 }
@@ -0,0 +1,59 @@
+package git
+
+import (
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/Jameslikestea/grm/internal/storage"
+)
+
+// PeelReference follows annotated tags until it reaches the object that they
+// point at. Hashes that are not annotated tags are returned unchanged.
+func PeelReference(stor storage.Storage, repo string, hash plumbing.Hash) plumbing.Hash {
+	return peel(
+		hash, func(hash plumbing.Hash) (storage.Object, bool) {
+			obj, err := stor.GetObject(repo, hash)
+			return obj, err == nil
+		},
+	)
+}
+
+// peel follows annotated tags, loading each object with get, until it reaches
+// an object that is not a tag or cannot be loaded.
+func peel(hash plumbing.Hash, get func(plumbing.Hash) (storage.Object, bool)) plumbing.Hash {
+	seen := map[plumbing.Hash]bool{}
+	for !seen[hash] {
+		seen[hash] = true
+
+		obj, ok := get(hash)
+		if !ok {
+			return hash
+		}
+		t, ok := decodeTag(obj)
+		if !ok {
+			return hash
+		}
+		hash = t.Target
+	}
+	return hash
+}
+
+// PeelReferences returns the peeled object of every reference that points at
+// an annotated tag, keyed by the reference name.
+func PeelReferences(stor storage.Storage, repo string, refs []storage.Reference) map[plumbing.ReferenceName]plumbing.Hash {
+	peeled := map[plumbing.ReferenceName]plumbing.Hash{}
+	for _, ref := range refs {
+		if hash := PeelReference(stor, repo, ref.Hash); hash != ref.Hash {
+			peeled[ref.Name] = hash
+		}
+	}
+	return peeled
+}
+
+func decodeTag(obj storage.Object) (*object.Tag, bool) {
+	t := &object.Tag{}
+	if !decodeObject(obj, plumbing.TagObject, t) {
+		return nil, false
+	}
+	return t, true
+}
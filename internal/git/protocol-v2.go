@@ -0,0 +1,334 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/rs/zerolog/log"
+
+	"github.com/Jameslikestea/grm/internal/storage"
+)
+
+const (
+	// ProtocolV0 is the original wire protocol, version 1 only adds a version
+	// line to it so both are served the same way.
+	ProtocolV0 = 0
+	// ProtocolV2 is the command based wire protocol.
+	ProtocolV2 = 2
+)
+
+// Agent is the implementation name advertised to clients.
+const Agent = "grm"
+
+// delimPkt separates the sections of a protocol v2 request or response.
+var delimPkt = []byte("0001")
+
+type packetType int
+
+const (
+	dataPacket packetType = iota
+	flushPacket
+	delimPacket
+	responseEndPacket
+)
+
+// Command is a single protocol v2 request sent by the client.
+type Command struct {
+	Name         string
+	Capabilities []string
+	Args         []string
+}
+
+// ProtocolVersion parses the value of GIT_PROTOCOL or the Git-Protocol header
+// and returns the protocol version that should be spoken to the client.
+func ProtocolVersion(s string) int {
+	version := ProtocolV0
+	for _, param := range strings.Split(s, ":") {
+		if strings.TrimSpace(param) == "version=2" {
+			version = ProtocolV2
+		}
+	}
+	return version
+}
+
+// readPacket reads a single pkt-line. Unlike pktline.Scanner it understands
+// the delimiter and response end packets that were introduced with v2.
+func readPacket(r io.Reader) (packetType, []byte, error) {
+	var l [4]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return dataPacket, nil, err
+	}
+
+	n, err := strconv.ParseUint(string(l[:]), 16, 16)
+	if err != nil {
+		return dataPacket, nil, pktline.ErrInvalidPktLen
+	}
+
+	switch n {
+	case 0:
+		return flushPacket, nil, nil
+	case 1:
+		return delimPacket, nil, nil
+	case 2:
+		return responseEndPacket, nil, nil
+	case 3:
+		return dataPacket, nil, pktline.ErrInvalidPktLen
+	}
+
+	b := make([]byte, n-4)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return dataPacket, nil, err
+	}
+
+	return dataPacket, b, nil
+}
+
+// GenerateCapabilityAdvertisement writes the protocol v2 capability list, this
+// replaces the reference advertisement when the client asks for version 2.
+func GenerateCapabilityAdvertisement(writer io.Writer) {
+	e := pktline.NewEncoder(writer)
+	e.Encodef("version 2\n")
+	e.Encodef("agent=%s\n", Agent)
+	e.Encodef("ls-refs\n")
+	e.Encodef("fetch=shallow filter\n")
+	e.Encodef("object-format=sha1\n")
+	e.Flush()
+}
+
+// DecodeCommand reads a protocol v2 command request. io.EOF is returned once
+// the client has no further commands to send.
+func DecodeCommand(r io.Reader) (Command, error) {
+	c := Command{}
+	args := false
+
+	for {
+		t, b, err := readPacket(r)
+		if err != nil {
+			return c, err
+		}
+
+		switch t {
+		case flushPacket:
+			if c.Name == "" {
+				return c, io.EOF
+			}
+			return c, nil
+		case delimPacket:
+			args = true
+			continue
+		case responseEndPacket:
+			return c, errors.New("unexpected response end packet")
+		}
+
+		line := strings.TrimSuffix(string(b), "\n")
+		switch {
+		case args:
+			c.Args = append(c.Args, line)
+		case strings.HasPrefix(line, "command="):
+			c.Name = strings.TrimPrefix(line, "command=")
+		default:
+			c.Capabilities = append(c.Capabilities, line)
+		}
+	}
+}
+
+// ServeCommand answers a single protocol v2 command. defaultTag is the tag that
+// the repository has configured for HEAD, if any.
+func ServeCommand(cmd Command, stor storage.Storage, repo string, defaultTag string, writer io.Writer) {
+	log.Debug().Str("command", cmd.Name).Strs("args", cmd.Args).Msg("Serving protocol v2 command")
+
+	switch cmd.Name {
+	case "ls-refs":
+		refs, err := stor.ListReferences(repo)
+		if err != nil {
+			log.Error().Err(err).Msg("Cannot list references for ls-refs")
+		}
+		LsRefs(stor, repo, refs, DefaultHead(refs, defaultTag), cmd.Args, writer)
+	case "fetch":
+		Fetch(stor, repo, cmd.Args, writer)
+	default:
+		e := pktline.NewEncoder(writer)
+		e.Encodef("ERR unknown command %s\n", cmd.Name)
+		e.Flush()
+	}
+}
+
+// LsRefs answers the ls-refs command, only references matching one of the
+// requested prefixes are listed. The only symbolic reference is the virtual
+// HEAD, which is listed first when head names one of the references.
+func LsRefs(
+	stor storage.Storage,
+	repo string,
+	refs []storage.Reference,
+	head plumbing.ReferenceName,
+	args []string,
+	writer io.Writer,
+) {
+	peel := false
+	symrefs := false
+	prefixes := []string{}
+	for _, arg := range args {
+		switch {
+		case arg == "peel":
+			peel = true
+		case arg == "symrefs":
+			symrefs = true
+		case strings.HasPrefix(arg, "ref-prefix "):
+			prefixes = append(prefixes, strings.TrimPrefix(arg, "ref-prefix "))
+		}
+	}
+
+	sort.Slice(
+		refs, func(i, j int) bool {
+			return refs[i].Name < refs[j].Name
+		},
+	)
+
+	for _, ref := range refs {
+		if head != "" && ref.Name == head {
+			refs = append([]storage.Reference{{Name: plumbing.HEAD, Hash: ref.Hash}}, refs...)
+			break
+		}
+	}
+
+	e := pktline.NewEncoder(writer)
+	for _, ref := range refs {
+		if !matchesPrefix(ref.Name.String(), prefixes) {
+			continue
+		}
+
+		line := fmt.Sprintf("%s %s", ref.Hash.String(), ref.Name)
+		if symrefs && ref.Name == plumbing.HEAD {
+			line += fmt.Sprintf(" symref-target:%s", head)
+		}
+		if peel {
+			if peeled := PeelReference(stor, repo, ref.Hash); peeled != ref.Hash {
+				line += fmt.Sprintf(" peeled:%s", peeled.String())
+			}
+		}
+		e.Encodef("%s\n", line)
+	}
+	e.Flush()
+}
+
+func matchesPrefix(name string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fetch answers the fetch command. Haves are acknowledged until the client
+// sends done or we have found enough common commits to send the pack. The
+// packfile section is always multiplexed over the sideband in protocol v2.
+func Fetch(stor storage.Storage, repo string, args []string, writer io.Writer) {
+	req := UploadRequest{Capabilities: map[string]string{}}
+	haves := []plumbing.Hash{}
+	done := false
+	e := pktline.NewEncoder(writer)
+
+	for _, arg := range args {
+		fields := strings.Fields(arg)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "have":
+			if len(fields) > 1 {
+				haves = append(haves, plumbing.NewHash(fields[1]))
+			}
+		case "done":
+			done = true
+		case "want", "shallow", "deepen", "deepen-since", "deepen-not", "filter":
+			if err := parseUploadLine(&req, fields); err != nil {
+				log.Warn().Err(err).Str("arg", arg).Msg("Cannot parse fetch argument")
+				e.Encodef("ERR %s\n", err)
+				return
+			}
+		default:
+			req.Capabilities[fields[0]] = strings.Join(fields[1:], " ")
+		}
+	}
+
+	opts := DefaultPackOptions()
+	if !req.Has("ofs-delta") {
+		opts.Window = 0
+	}
+
+	objs, err := stor.ListObjects(repo)
+	if err != nil {
+		log.Error().Err(err).Msg("Cannot get objects from store")
+		e.Encodef("ERR cannot read repository %s\n", repo)
+		return
+	}
+	cache := objectCache(objs)
+
+	refs, err := stor.ListReferences(repo)
+	if err != nil {
+		log.Error().Err(err).Msg("Cannot get references from store")
+	}
+	if want, ok := unreachableWant(cache, refs, req.Wants); ok {
+		log.Warn().Str("want", want.String()).Msg("Client wants an object that is not reachable")
+		e.Encodef("ERR upload-pack: not our ref %s\n", want.String())
+		return
+	}
+
+	n := NewNegotiator(cache, req)
+	acks := []plumbing.Hash{}
+	for _, have := range haves {
+		if _, ok := cache[have]; ok {
+			n.addCommon(have)
+			acks = append(acks, have)
+		}
+	}
+
+	if !done {
+		e.Encodef("acknowledgments\n")
+		if len(acks) == 0 {
+			e.Encodef("NAK\n")
+		}
+		for _, ack := range acks {
+			e.Encodef("ACK %s\n", ack.String())
+		}
+		if !n.okToGiveUp() {
+			e.Flush()
+			return
+		}
+		e.Encodef("ready\n")
+		writer.Write(delimPkt)
+	}
+
+	shallow := ShallowUpdate{}
+	if req.Deepen() || len(req.Shallows) > 0 {
+		shallow = NewShallowUpdate(cache, refs, req)
+
+		e.Encodef("shallow-info\n")
+		shallow.Write(e)
+		writer.Write(delimPkt)
+	}
+
+	pack := findNewObjects(cache, req.Wants, n.Common(), shallow, req.Filter)
+	if req.Has("include-tag") {
+		pack = includeTags(cache, refs, pack, n.Common())
+	}
+
+	// The packfile section is always multiplexed in protocol v2
+	req.Capabilities["side-band-64k"] = ""
+	sb := NewSideband(writer, nil, req.Capabilities)
+
+	e.Encodef("packfile\n")
+	sb.Progress("Counting objects: %d, done.\n", len(pack))
+	EncodePackfile(sb, pack, opts)
+	sb.Close()
+}
@@ -0,0 +1,162 @@
+package git
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/Jameslikestea/grm/internal/storage"
+	"github.com/Jameslikestea/grm/internal/storage/memory"
+)
+
+func TestProtocolVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want int
+	}{
+		{
+			name: "Empty",
+			s:    "",
+			want: ProtocolV0,
+		},
+		{
+			name: "Version 1",
+			s:    "version=1",
+			want: ProtocolV0,
+		},
+		{
+			name: "Version 2",
+			s:    "version=2",
+			want: ProtocolV2,
+		},
+		{
+			name: "Multiple Parameters",
+			s:    "object-format=sha1:version=2",
+			want: ProtocolV2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if got := ProtocolVersion(tt.s); got != tt.want {
+					t.Errorf("ProtocolVersion() = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func TestDecodeCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		reader  io.Reader
+		want    Command
+		wantErr error
+	}{
+		{
+			name: "ls-refs",
+			reader: bytes.NewBufferString(
+				"0014command=ls-refs\n0015agent=git/2.36.0\n00010009peel\n000csymrefs\n001aref-prefix refs/tags/\n0000",
+			),
+			want: Command{
+				Name:         "ls-refs",
+				Capabilities: []string{"agent=git/2.36.0"},
+				Args:         []string{"peel", "symrefs", "ref-prefix refs/tags/"},
+			},
+		},
+		{
+			name:    "End of session",
+			reader:  bytes.NewBufferString("0000"),
+			want:    Command{},
+			wantErr: io.EOF,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := DecodeCommand(tt.reader)
+				if err != tt.wantErr {
+					t.Errorf("DecodeCommand() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("DecodeCommand() got = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func TestLsRefs(t *testing.T) {
+	stor := memory.NewMemoryStorage()
+
+	commit := plumbing.NewHash("0000000000000000000000000000000043214321")
+	tag := &object.Tag{
+		Name:       "v2.0.0",
+		Target:     commit,
+		TargetType: plumbing.CommitObject,
+		Message:    "v2.0.0\n",
+	}
+	m := &plumbing.MemoryObject{}
+	tag.Encode(m)
+	r, _ := m.Reader()
+	b, _ := ioutil.ReadAll(r)
+	stor.StoreObject("ns/repo.git", storage.Object{Hash: m.Hash(), Type: plumbing.TagObject, Content: b}, 0)
+
+	refs := []storage.Reference{
+		{
+			Name: "refs/tags/v2.0.0",
+			Hash: m.Hash(),
+		},
+		{
+			Name: "refs/tags/v1.0.0",
+			Hash: commit,
+		},
+	}
+
+	tests := []struct {
+		name       string
+		head       plumbing.ReferenceName
+		args       []string
+		wantWriter string
+	}{
+		{
+			name:       "All References",
+			args:       nil,
+			wantWriter: "003e0000000000000000000000000000000043214321 refs/tags/v1.0.0\n003e" + m.Hash().String() + " refs/tags/v2.0.0\n0000",
+		},
+		{
+			name:       "Prefix",
+			args:       []string{"ref-prefix refs/tags/v1"},
+			wantWriter: "003e0000000000000000000000000000000043214321 refs/tags/v1.0.0\n0000",
+		},
+		{
+			name:       "Peeled",
+			args:       []string{"peel", "ref-prefix refs/tags/v2"},
+			wantWriter: "006e" + m.Hash().String() + " refs/tags/v2.0.0 peeled:0000000000000000000000000000000043214321\n0000",
+		},
+		{
+			name:       "Symbolic HEAD",
+			head:       "refs/tags/v1.0.0",
+			args:       []string{"symrefs", "ref-prefix HEAD"},
+			wantWriter: "00510000000000000000000000000000000043214321 HEAD symref-target:refs/tags/v1.0.0\n0000",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				writer := &bytes.Buffer{}
+				LsRefs(stor, "ns/repo.git", refs, tt.head, tt.args, writer)
+				if gotWriter := writer.String(); gotWriter != tt.wantWriter {
+					t.Errorf("LsRefs() = %v, want %v", gotWriter, tt.wantWriter)
+				}
+			},
+		)
+	}
+}
@@ -14,23 +14,103 @@ import (
 	"github.com/Jameslikestea/grm/internal/storage"
 )
 
-func DecodeRefs(reader io.Reader) ([]storage.Reference, error) {
-	var pl []storage.Reference
+// ReferenceUpdate is a single command of a push. Old is the zero hash when the
+// client creates the reference and New is the zero hash when it deletes it.
+type ReferenceUpdate struct {
+	Name plumbing.ReferenceName
+	Old  plumbing.Hash
+	New  plumbing.Hash
+}
+
+// Create reports whether the client expects the reference not to exist yet.
+func (u ReferenceUpdate) Create() bool {
+	return u.Old.IsZero()
+}
+
+// Delete reports whether the client asked for the reference to be removed.
+func (u ReferenceUpdate) Delete() bool {
+	return u.New.IsZero()
+}
+
+// ReceiveRequest is the list of reference updates sent by the client at the
+// start of a push, along with the capabilities it selected.
+type ReceiveRequest struct {
+	Updates      []ReferenceUpdate
+	Capabilities map[string]string
+
+	// Options are the push options sent with git push -o, they are only read
+	// when the client selected push-options.
+	Options []string
+}
+
+// Refs returns the references as they will be stored once the push succeeds.
+func (r ReceiveRequest) Refs() []storage.Reference {
+	refs := make([]storage.Reference, 0, len(r.Updates))
+	for _, u := range r.Updates {
+		refs = append(refs, storage.Reference{Name: u.Name, Hash: u.New})
+	}
+	return refs
+}
+
+// DeleteOnly reports whether every update deletes a reference, in which case
+// the client does not send a packfile.
+func (r ReceiveRequest) DeleteOnly() bool {
+	for _, u := range r.Updates {
+		if !u.Delete() {
+			return false
+		}
+	}
+	return true
+}
+
+// Has reports whether the client selected the capability.
+func (r ReceiveRequest) Has(capability string) bool {
+	_, ok := r.Capabilities[capability]
+	return ok
+}
+
+// DecodeReceiveRequest reads the reference update requests up to and including
+// the flush packet that terminates them. Capabilities follow a NUL byte on the
+// first update. When the client selected push-options the options that follow
+// the updates are read as well.
+func DecodeReceiveRequest(reader io.Reader) (ReceiveRequest, error) {
+	req := ReceiveRequest{Capabilities: map[string]string{}}
 
 	e := pktline.NewScanner(reader)
 	if ok := e.Scan(); !ok {
-		return nil, errors.New("cannot read pktline")
+		return req, errors.New("cannot read pktline")
 	}
-	for {
+	for first := true; ; first = false {
 		b := e.Bytes()
 		if bytes.Equal(b, pktline.Flush) {
 			log.Info().Msg("Received end packet")
-			return pl, nil
+			if req.Has("push-options") {
+				options, err := decodePushOptions(e)
+				req.Options = options
+				return req, err
+			}
+			return req, nil
+		}
+
+		line := strings.TrimRightFunc(string(b), unicode.IsSpace)
+		caps := ""
+		if i := strings.IndexByte(line, 0); i >= 0 {
+			line, caps = line[:i], line[i+1:]
 		}
 
-		components := strings.Split(string(b), " ")
+		components := strings.Split(line, " ")
 		if len(components) < 3 {
-			return nil, errors.New("cannot read pktline")
+			return req, errors.New("cannot read pktline")
+		}
+		if first {
+			for _, capability := range append(components[3:], strings.Fields(caps)...) {
+				kv := strings.SplitN(capability, "=", 2)
+				if len(kv) == 2 {
+					req.Capabilities[kv[0]] = kv[1]
+				} else {
+					req.Capabilities[kv[0]] = ""
+				}
+			}
 		}
 
 		src := plumbing.NewHash(components[0])
@@ -46,7 +126,7 @@ func DecodeRefs(reader io.Reader) ([]storage.Reference, error) {
 			),
 		)
 
-		pl = append(pl, storage.Reference{Name: ref, Hash: dst})
+		req.Updates = append(req.Updates, ReferenceUpdate{Name: ref, Old: src, New: dst})
 
 		log.Debug().Str("src", src.String()).Str("dst", dst.String()).Str(
 			"ref",
@@ -55,6 +135,32 @@ func DecodeRefs(reader io.Reader) ([]storage.Reference, error) {
 
 		if ok := e.Scan(); !ok {
 			log.Error().Err(e.Err()).Msg("done")
+			return req, io.ErrUnexpectedEOF
+		}
+	}
+}
+
+// decodePushOptions reads one push option per packet up to the flush packet.
+func decodePushOptions(e *pktline.Scanner) ([]string, error) {
+	options := []string{}
+	for e.Scan() {
+		b := e.Bytes()
+		if bytes.Equal(b, pktline.Flush) {
+			log.Debug().Strs("options", options).Msg("Received push options")
+			return options, nil
 		}
+		options = append(options, strings.TrimSuffix(string(b), "\n"))
+	}
+	if e.Err() != nil {
+		return options, e.Err()
+	}
+	return options, io.ErrUnexpectedEOF
+}
+
+func DecodeRefs(reader io.Reader) ([]storage.Reference, error) {
+	req, err := DecodeReceiveRequest(reader)
+	if err != nil {
+		return nil, err
 	}
+	return req.Refs(), nil
 }
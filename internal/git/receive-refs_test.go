@@ -58,3 +58,85 @@ func TestDecodeRefs(t *testing.T) {
 		)
 	}
 }
+
+func TestDecodeReceiveRequest(t *testing.T) {
+	reader := bytes.NewBufferString(
+		"00940000000000000000000000000000000000000000 cdfdb42577e2506715f8cfeacdbabc092bf63e8d refs/tags/v1.0.0\x00report-status side-band-64k agent=git/2.36.0\n0000",
+	)
+	want := ReceiveRequest{
+		Updates: []ReferenceUpdate{
+			{
+				Name: "refs/tags/v1.0.0",
+				Old:  plumbing.ZeroHash,
+				New:  plumbing.NewHash("cdfdb42577e2506715f8cfeacdbabc092bf63e8d"),
+			},
+		},
+		Capabilities: map[string]string{
+			"report-status": "",
+			"side-band-64k": "",
+			"agent":         "git/2.36.0",
+		},
+	}
+
+	got, err := DecodeReceiveRequest(reader)
+	if err != nil {
+		t.Fatalf("DecodeReceiveRequest() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeReceiveRequest() got = %v, want %v", got, want)
+	}
+	if !got.Updates[0].Create() || got.Updates[0].Delete() {
+		t.Errorf("DecodeReceiveRequest() update is not a creation")
+	}
+	if got.DeleteOnly() {
+		t.Errorf("DecodeReceiveRequest() request is delete only")
+	}
+}
+
+func TestReceiveRequest_DeleteOnly(t *testing.T) {
+	hash := plumbing.NewHash("cdfdb42577e2506715f8cfeacdbabc092bf63e8d")
+	tests := []struct {
+		name    string
+		updates []ReferenceUpdate
+		want    bool
+	}{
+		{
+			name:    "deletes",
+			updates: []ReferenceUpdate{{Name: "refs/tags/v1.0.0", Old: hash}, {Name: "refs/tags/v1.0.1", Old: hash}},
+			want:    true,
+		},
+		{
+			name:    "delete and create",
+			updates: []ReferenceUpdate{{Name: "refs/tags/v1.0.0", Old: hash}, {Name: "refs/tags/v1.0.1", New: hash}},
+			want:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if got := (ReceiveRequest{Updates: tt.updates}).DeleteOnly(); got != tt.want {
+					t.Errorf("DeleteOnly() = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func TestDecodeReceiveRequest_PushOptions(t *testing.T) {
+	reader := bytes.NewBufferString(
+		"00820000000000000000000000000000000000000000 cdfdb42577e2506715f8cfeacdbabc092bf63e8d refs/tags/v1.0.0\x00report-status push-options\n0000" +
+			"0011ci-run=12345\n002dnotes=https://grmpkg.com/releases/v1.0.0\n0000PACK",
+	)
+
+	got, err := DecodeReceiveRequest(reader)
+	if err != nil {
+		t.Fatalf("DecodeReceiveRequest() error = %v", err)
+	}
+	want := []string{"ci-run=12345", "notes=https://grmpkg.com/releases/v1.0.0"}
+	if !reflect.DeepEqual(got.Options, want) {
+		t.Errorf("DecodeReceiveRequest() options = %v, want %v", got.Options, want)
+	}
+	if rest := reader.String(); rest != "PACK" {
+		t.Errorf("DecodeReceiveRequest() left %q unread, want %q", rest, "PACK")
+	}
+}
@@ -1,7 +1,9 @@
 package git
 
 import (
+	"fmt"
 	"io"
+	"strings"
 
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/format/pktline"
@@ -9,15 +11,81 @@ import (
 	"github.com/Jameslikestea/grm/internal/storage"
 )
 
-func GenerateReferencePack(refs []storage.Reference, http bool, service string, writer io.Writer) {
-	capabilities := "ofs-delta multi_ack report-status"
+// capabilities lists the capabilities advertised with the first reference,
+// these depend on both the service and whether the transport is stateless.
+// atomic is only offered by receive-pack when the storage can create several
+// references at once.
+func capabilities(http bool, service string, atomic bool) string {
+	caps := []string{"ofs-delta"}
+
+	if service == "git-upload-pack" {
+		caps = append(caps, "side-band", "side-band-64k", "no-progress", "multi_ack", "multi_ack_detailed", "shallow", "filter", "include-tag", "allow-reachable-sha1-in-want")
+		if http {
+			caps = append(caps, "no-done")
+		}
+		return strings.Join(caps, " ")
+	}
+
+	caps = append(caps, "side-band-64k", "quiet")
+	if !http {
+		caps = append(caps, "multi_ack")
+	}
+	caps = append(caps, "report-status", "report-status-v2")
+	if atomic {
+		caps = append(caps, "atomic")
+	}
+	caps = append(caps, "push-options")
+
+	return strings.Join(caps, " ")
+}
+
+// GenerateReferencePack writes the reference advertisement. Annotated tags
+// that appear in peeled are followed by a ^{} line naming the peeled object.
+// When head names one of the references a virtual HEAD pointing at it is
+// advertised first, along with a symref capability so that clients check it
+// out.
+func GenerateReferencePack(
+	refs []storage.Reference,
+	peeled map[plumbing.ReferenceName]plumbing.Hash,
+	head plumbing.ReferenceName,
+	http bool,
+	service string,
+	writer io.Writer,
+) {
+	writeReferencePack(refs, peeled, head, http, service, capabilities(http, service, true), writer)
+}
+
+// GenerateReceiveReferencePack writes the reference advertisement of
+// receive-pack, atomic pushes are only offered when atomic is set.
+func GenerateReceiveReferencePack(refs []storage.Reference, http bool, atomic bool, writer io.Writer) {
+	service := "git-receive-pack"
+	writeReferencePack(refs, nil, "", http, service, capabilities(http, service, atomic), writer)
+}
+
+func writeReferencePack(
+	refs []storage.Reference,
+	peeled map[plumbing.ReferenceName]plumbing.Hash,
+	head plumbing.ReferenceName,
+	http bool,
+	service string,
+	capabilities string,
+	writer io.Writer,
+) {
 	e := pktline.NewEncoder(writer)
 	if http {
 		e.Encodef("# service=%s\n", service)
 		e.Flush()
 		e = pktline.NewEncoder(writer)
-		capabilities = "ofs-delta report-status"
 	}
+
+	for _, ref := range refs {
+		if head != "" && ref.Name == head {
+			capabilities = fmt.Sprintf("%s symref=HEAD:%s", capabilities, head)
+			refs = append([]storage.Reference{{Name: plumbing.HEAD, Hash: ref.Hash}}, refs...)
+			break
+		}
+	}
+
 	if len(refs) == 0 {
 		e.Encodef(
 			"%s %s\x00%s\n",
@@ -29,9 +97,16 @@ func GenerateReferencePack(refs []storage.Reference, http bool, service string,
 	for i, ref := range refs {
 		if i == 0 {
 			e.Encodef("%s %s\x00%s\n", ref.Hash.String(), ref.Name, capabilities)
-			continue
+		} else {
+			e.Encodef("%s %s\n", ref.Hash.String(), ref.Name)
+		}
+		name := ref.Name
+		if name == plumbing.HEAD {
+			name = head
+		}
+		if hash, ok := peeled[name]; ok {
+			e.Encodef("%s %s^{}\n", hash.String(), ref.Name)
 		}
-		e.Encodef("%s %s\n", ref.Hash.String(), ref.Name)
 	}
 
 	e.Flush()
@@ -12,6 +12,8 @@ import (
 func TestGenerateReferencePack(t *testing.T) {
 	type args struct {
 		refs    []storage.Reference
+		peeled  map[plumbing.ReferenceName]plumbing.Hash
+		head    plumbing.ReferenceName
 		http    bool
 		service string
 	}
@@ -27,7 +29,7 @@ func TestGenerateReferencePack(t *testing.T) {
 				http:    false,
 				service: "git-receive-pack",
 			},
-			wantWriter: "005f0000000000000000000000000000000000000000 capabilities^{}\x00ofs-delta multi_ack report-status\n0000",
+			wantWriter: "00980000000000000000000000000000000000000000 capabilities^{}\x00ofs-delta side-band-64k quiet multi_ack report-status report-status-v2 atomic push-options\n0000",
 		},
 		{
 			name: "HTTP No References Receive Pack",
@@ -36,7 +38,7 @@ func TestGenerateReferencePack(t *testing.T) {
 				http:    true,
 				service: "git-receive-pack",
 			},
-			wantWriter: "001f# service=git-receive-pack\n000000550000000000000000000000000000000000000000 capabilities^{}\x00ofs-delta report-status\n0000",
+			wantWriter: "001f# service=git-receive-pack\n0000008e0000000000000000000000000000000000000000 capabilities^{}\x00ofs-delta side-band-64k quiet report-status report-status-v2 atomic push-options\n0000",
 		},
 		{
 			name: "SSH Single Reference",
@@ -50,7 +52,7 @@ func TestGenerateReferencePack(t *testing.T) {
 				http:    false,
 				service: "git-receive-pack",
 			},
-			wantWriter: "00600000000000000000000000000000000043214321 refs/tags/v1.0.0\x00ofs-delta multi_ack report-status\n0000",
+			wantWriter: "00990000000000000000000000000000000043214321 refs/tags/v1.0.0\x00ofs-delta side-band-64k quiet multi_ack report-status report-status-v2 atomic push-options\n0000",
 		},
 		{
 			name: "HTTP Single Reference",
@@ -64,7 +66,7 @@ func TestGenerateReferencePack(t *testing.T) {
 				http:    true,
 				service: "git-receive-pack",
 			},
-			wantWriter: "001f# service=git-receive-pack\n000000560000000000000000000000000000000043214321 refs/tags/v1.0.0\x00ofs-delta report-status\n0000",
+			wantWriter: "001f# service=git-receive-pack\n0000008f0000000000000000000000000000000043214321 refs/tags/v1.0.0\x00ofs-delta side-band-64k quiet report-status report-status-v2 atomic push-options\n0000",
 		},
 		{
 			name: "SSH Multi Reference",
@@ -82,7 +84,7 @@ func TestGenerateReferencePack(t *testing.T) {
 				http:    false,
 				service: "git-receive-pack",
 			},
-			wantWriter: "00600000000000000000000000000000000043214321 refs/tags/v1.0.0\x00ofs-delta multi_ack report-status\n003e0000000000000000000000000000000012341234 refs/tags/v2.0.0\n0000",
+			wantWriter: "00990000000000000000000000000000000043214321 refs/tags/v1.0.0\x00ofs-delta side-band-64k quiet multi_ack report-status report-status-v2 atomic push-options\n003e0000000000000000000000000000000012341234 refs/tags/v2.0.0\n0000",
 		},
 		{
 			name: "HTTP Multi Reference",
@@ -100,14 +102,81 @@ func TestGenerateReferencePack(t *testing.T) {
 				http:    true,
 				service: "git-receive-pack",
 			},
-			wantWriter: "001f# service=git-receive-pack\n000000560000000000000000000000000000000043214321 refs/tags/v1.0.0\x00ofs-delta report-status\n003e0000000000000000000000000000000012341234 refs/tags/v2.0.0\n0000",
+			wantWriter: "001f# service=git-receive-pack\n0000008f0000000000000000000000000000000043214321 refs/tags/v1.0.0\x00ofs-delta side-band-64k quiet report-status report-status-v2 atomic push-options\n003e0000000000000000000000000000000012341234 refs/tags/v2.0.0\n0000",
+		},
+		{
+			name: "SSH Single Reference Upload Pack",
+			args: args{
+				refs: []storage.Reference{
+					{
+						Name: "refs/tags/v1.0.0",
+						Hash: plumbing.NewHash("0000000000000000000000000000000043214321"),
+					},
+				},
+				http:    false,
+				service: "git-upload-pack",
+			},
+			wantWriter: "00c10000000000000000000000000000000043214321 refs/tags/v1.0.0\x00ofs-delta side-band side-band-64k no-progress multi_ack multi_ack_detailed shallow filter include-tag allow-reachable-sha1-in-want\n0000",
+		},
+		{
+			name: "HTTP Single Reference Upload Pack",
+			args: args{
+				refs: []storage.Reference{
+					{
+						Name: "refs/tags/v1.0.0",
+						Hash: plumbing.NewHash("0000000000000000000000000000000043214321"),
+					},
+				},
+				http:    true,
+				service: "git-upload-pack",
+			},
+			wantWriter: "001e# service=git-upload-pack\n000000c90000000000000000000000000000000043214321 refs/tags/v1.0.0\x00ofs-delta side-band side-band-64k no-progress multi_ack multi_ack_detailed shallow filter include-tag allow-reachable-sha1-in-want no-done\n0000",
+		},
+		{
+			name: "SSH Peeled Reference Upload Pack",
+			args: args{
+				refs: []storage.Reference{
+					{
+						Name: "refs/tags/v1.0.0",
+						Hash: plumbing.NewHash("0000000000000000000000000000000043214321"),
+					},
+				},
+				peeled: map[plumbing.ReferenceName]plumbing.Hash{
+					"refs/tags/v1.0.0": plumbing.NewHash("0000000000000000000000000000000012341234"),
+				},
+				http:    false,
+				service: "git-upload-pack",
+			},
+			wantWriter: "00c10000000000000000000000000000000043214321 refs/tags/v1.0.0\x00ofs-delta side-band side-band-64k no-progress multi_ack multi_ack_detailed shallow filter include-tag allow-reachable-sha1-in-want\n00410000000000000000000000000000000012341234 refs/tags/v1.0.0^{}\n0000",
+		},
+		{
+			name: "SSH Head Upload Pack",
+			args: args{
+				refs: []storage.Reference{
+					{
+						Name: "refs/tags/v1.0.0",
+						Hash: plumbing.NewHash("0000000000000000000000000000000043214321"),
+					},
+					{
+						Name: "refs/tags/v2.0.0",
+						Hash: plumbing.NewHash("0000000000000000000000000000000012341234"),
+					},
+				},
+				peeled: map[plumbing.ReferenceName]plumbing.Hash{
+					"refs/tags/v2.0.0": plumbing.NewHash("0000000000000000000000000000000056785678"),
+				},
+				head:    "refs/tags/v2.0.0",
+				http:    false,
+				service: "git-upload-pack",
+			},
+			wantWriter: "00d20000000000000000000000000000000012341234 HEAD\x00ofs-delta side-band side-band-64k no-progress multi_ack multi_ack_detailed shallow filter include-tag allow-reachable-sha1-in-want symref=HEAD:refs/tags/v2.0.0\n00350000000000000000000000000000000056785678 HEAD^{}\n003e0000000000000000000000000000000043214321 refs/tags/v1.0.0\n003e0000000000000000000000000000000012341234 refs/tags/v2.0.0\n00410000000000000000000000000000000056785678 refs/tags/v2.0.0^{}\n0000",
 		},
 	}
 	for _, tt := range tests {
 		t.Run(
 			tt.name, func(t *testing.T) {
 				writer := &bytes.Buffer{}
-				GenerateReferencePack(tt.args.refs, tt.args.http, tt.args.service, writer)
+				GenerateReferencePack(tt.args.refs, tt.args.peeled, tt.args.head, tt.args.http, tt.args.service, writer)
 				if gotWriter := writer.String(); gotWriter != tt.wantWriter {
 					t.Errorf("GenerateReferencePack() = %v, want %v", gotWriter, tt.wantWriter)
 				}
@@ -115,3 +184,13 @@ func TestGenerateReferencePack(t *testing.T) {
 		)
 	}
 }
+
+func TestGenerateReceiveReferencePack_NoAtomic(t *testing.T) {
+	writer := &bytes.Buffer{}
+	GenerateReceiveReferencePack(nil, false, false, writer)
+
+	want := "00910000000000000000000000000000000000000000 capabilities^{}\x00ofs-delta side-band-64k quiet multi_ack report-status report-status-v2 push-options\n0000"
+	if got := writer.String(); got != want {
+		t.Errorf("GenerateReceiveReferencePack() = %q, want %q", got, want)
+	}
+}
@@ -8,16 +8,40 @@ import (
 	"github.com/go-git/go-git/v5/plumbing/format/pktline"
 )
 
+// ReportItem is the result of a single reference update. Old and New are only
+// reported to clients that selected report-status-v2.
 type ReportItem struct {
 	Ok     bool
 	Reason string
+	Old    plumbing.Hash
+	New    plumbing.Hash
 }
 
 type Report map[plumbing.ReferenceName]ReportItem
 
 func (r Report) Write(w io.Writer) {
+	r.WriteStatus(w, nil)
+}
+
+// WriteStatus writes the report, unpackErr is reported to the client when the
+// packfile could not be unpacked.
+func (r Report) WriteStatus(w io.Writer, unpackErr error) {
+	r.write(w, unpackErr, false)
+}
+
+// WriteStatusV2 writes the report in the report-status-v2 format, which
+// follows every accepted update with the old and new object IDs.
+func (r Report) WriteStatusV2(w io.Writer, unpackErr error) {
+	r.write(w, unpackErr, true)
+}
+
+func (r Report) write(w io.Writer, unpackErr error, v2 bool) {
 	e := pktline.NewEncoder(w)
-	e.Encodef("unpack ok\n")
+	if unpackErr != nil {
+		e.Encodef("unpack %s\n", unpackErr)
+	} else {
+		e.Encodef("unpack ok\n")
+	}
 	keys := make([]string, len(r))
 	i := 0
 	for key, _ := range r {
@@ -29,6 +53,10 @@ func (r Report) Write(w io.Writer) {
 		item := r[plumbing.ReferenceName(ref)]
 		if item.Ok {
 			e.Encodef("ok %s\n", ref)
+			if v2 {
+				e.Encodef("option old-oid %s\n", item.Old.String())
+				e.Encodef("option new-oid %s\n", item.New.String())
+			}
 		} else {
 			e.Encodef("ng %s %s\n", ref, item.Reason)
 		}
@@ -58,3 +58,26 @@ func TestReport_Write(t *testing.T) {
 		)
 	}
 }
+
+func TestReport_WriteStatusV2(t *testing.T) {
+	r := Report{
+		plumbing.ReferenceName("refs/heads/master"): ReportItem{
+			Ok:     false,
+			Reason: "grm only accepts tags",
+		},
+		plumbing.ReferenceName("refs/tags/v1.0.0"): ReportItem{
+			Ok:  true,
+			Old: plumbing.ZeroHash,
+			New: plumbing.NewHash("cdfdb42577e2506715f8cfeacdbabc092bf63e8d"),
+		},
+	}
+	wantW := "000eunpack ok\n002fng refs/heads/master grm only accepts tags\n0018ok refs/tags/v1.0.0\n" +
+		"003coption old-oid 0000000000000000000000000000000000000000\n" +
+		"003coption new-oid cdfdb42577e2506715f8cfeacdbabc092bf63e8d\n0000"
+
+	w := &bytes.Buffer{}
+	r.WriteStatusV2(w, nil)
+	if gotW := w.String(); gotW != wantW {
+		t.Errorf("WriteStatusV2() = %v, want %v", gotW, wantW)
+	}
+}
@@ -0,0 +1,171 @@
+package git
+
+import (
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/Jameslikestea/grm/internal/storage"
+)
+
+// ShallowUpdate is the set of commits that become shallow, or stop being
+// shallow, on the client as a result of a deepen request.
+type ShallowUpdate struct {
+	Shallow   []plumbing.Hash
+	Unshallow []plumbing.Hash
+
+	// client holds the commits that the client has without their parents,
+	// boundary holds the commits whose parents will not be sent and wants
+	// holds the parents of unshallowed commits which the client now needs.
+	client   map[plumbing.Hash]bool
+	boundary map[plumbing.Hash]bool
+	wants    []plumbing.Hash
+}
+
+// Write encodes the shallow and unshallow lines, the caller is responsible for
+// terminating the section.
+func (s ShallowUpdate) Write(e *pktline.Encoder) {
+	for _, hash := range s.Shallow {
+		e.Encodef("shallow %s\n", hash.String())
+	}
+	for _, hash := range s.Unshallow {
+		e.Encodef("unshallow %s\n", hash.String())
+	}
+}
+
+func decodeCommit(obj storage.Object) (*object.Commit, bool) {
+	c := &object.Commit{}
+	if !decodeObject(obj, plumbing.CommitObject, c) {
+		return nil, false
+	}
+	return c, true
+}
+
+// NewShallowUpdate walks the history from the wants and finds the commits at
+// which it has to be cut to satisfy the depth, time and reference limits of
+// the request.
+func NewShallowUpdate(
+	cache map[plumbing.Hash]storage.Object,
+	refs []storage.Reference,
+	req UploadRequest,
+) ShallowUpdate {
+	s := ShallowUpdate{
+		client:   map[plumbing.Hash]bool{},
+		boundary: map[plumbing.Hash]bool{},
+	}
+	for _, hash := range req.Shallows {
+		s.client[hash] = true
+	}
+
+	if !req.Deepen() {
+		for hash := range s.client {
+			s.boundary[hash] = true
+		}
+		return s
+	}
+
+	excluded := map[plumbing.Hash]bool{}
+	for _, not := range req.DeepenNot {
+		for _, ref := range refs {
+			if ref.Name.String() == not || ref.Name.Short() == not {
+				markAncestors(cache, peelCached(cache, ref.Hash), excluded)
+			}
+		}
+	}
+
+	allowed := func(hash plumbing.Hash) bool {
+		if excluded[hash] {
+			return false
+		}
+		if req.DeepenSince.IsZero() {
+			return true
+		}
+		c, ok := decodeCommit(cache[hash])
+		return ok && !c.Committer.When.Before(req.DeepenSince)
+	}
+
+	type queued struct {
+		hash  plumbing.Hash
+		depth int
+	}
+
+	queue := []queued{}
+	for _, want := range req.Wants {
+		queue = append(queue, queued{hash: peelCached(cache, want), depth: 1})
+	}
+
+	visited := map[plumbing.Hash]bool{}
+	notShallow := map[plumbing.Hash]bool{}
+	for len(queue) > 0 {
+		q := queue[0]
+		queue = queue[1:]
+		if visited[q.hash] {
+			continue
+		}
+		visited[q.hash] = true
+
+		c, ok := decodeCommit(cache[q.hash])
+		if !ok || len(c.ParentHashes) == 0 {
+			continue
+		}
+
+		cut := req.Depth > 0 && q.depth >= req.Depth
+		for _, parent := range c.ParentHashes {
+			if !allowed(parent) {
+				cut = true
+			}
+		}
+
+		if cut {
+			s.boundary[q.hash] = true
+			if !s.client[q.hash] {
+				s.Shallow = append(s.Shallow, q.hash)
+			}
+			continue
+		}
+
+		notShallow[q.hash] = true
+		for _, parent := range c.ParentHashes {
+			queue = append(queue, queued{hash: parent, depth: q.depth + 1})
+		}
+	}
+
+	for _, hash := range req.Shallows {
+		if !notShallow[hash] {
+			s.boundary[hash] = true
+			continue
+		}
+		s.Unshallow = append(s.Unshallow, hash)
+		if c, ok := decodeCommit(cache[hash]); ok {
+			s.wants = append(s.wants, c.ParentHashes...)
+		}
+	}
+
+	return s
+}
+
+// peelCached follows annotated tags using objects that are already loaded.
+func peelCached(cache map[plumbing.Hash]storage.Object, hash plumbing.Hash) plumbing.Hash {
+	return peel(
+		hash, func(hash plumbing.Hash) (storage.Object, bool) {
+			obj, ok := cache[hash]
+			return obj, ok
+		},
+	)
+}
+
+func markAncestors(cache map[plumbing.Hash]storage.Object, hash plumbing.Hash, marked map[plumbing.Hash]bool) {
+	queue := []plumbing.Hash{hash}
+	for len(queue) > 0 {
+		h := queue[0]
+		queue = queue[1:]
+		if marked[h] {
+			continue
+		}
+		marked[h] = true
+
+		if c, ok := decodeCommit(cache[h]); ok {
+			queue = append(queue, c.ParentHashes...)
+		}
+	}
+}
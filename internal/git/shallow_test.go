@@ -0,0 +1,84 @@
+package git
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/Jameslikestea/grm/internal/storage"
+)
+
+func TestNewShallowUpdate(t *testing.T) {
+	first := commitObject("first")
+	second := commitObject("second", first.Hash)
+	third := commitObject("third", second.Hash)
+	fourth := commitObject("fourth", third.Hash)
+	cache := objectCache([]storage.Object{first, second, third, fourth})
+
+	refs := []storage.Reference{
+		{
+			Name: "refs/tags/v1.0.0",
+			Hash: second.Hash,
+		},
+	}
+
+	tests := []struct {
+		name          string
+		req           UploadRequest
+		wantShallow   []plumbing.Hash
+		wantUnshallow []plumbing.Hash
+		wantObjects   int
+	}{
+		{
+			name:        "Depth 1",
+			req:         UploadRequest{Wants: []plumbing.Hash{fourth.Hash}, Depth: 1},
+			wantShallow: []plumbing.Hash{fourth.Hash},
+			wantObjects: 1,
+		},
+		{
+			name:        "Depth 2",
+			req:         UploadRequest{Wants: []plumbing.Hash{fourth.Hash}, Depth: 2},
+			wantShallow: []plumbing.Hash{third.Hash},
+			wantObjects: 2,
+		},
+		{
+			name:        "Deeper than history",
+			req:         UploadRequest{Wants: []plumbing.Hash{fourth.Hash}, Depth: 10},
+			wantObjects: 4,
+		},
+		{
+			name:        "Deepen not",
+			req:         UploadRequest{Wants: []plumbing.Hash{fourth.Hash}, DeepenNot: []string{"v1.0.0"}},
+			wantShallow: []plumbing.Hash{third.Hash},
+			wantObjects: 2,
+		},
+		{
+			name: "Unshallow",
+			req: UploadRequest{
+				Wants:    []plumbing.Hash{fourth.Hash},
+				Shallows: []plumbing.Hash{fourth.Hash},
+				Depth:    2,
+			},
+			wantShallow:   []plumbing.Hash{third.Hash},
+			wantUnshallow: []plumbing.Hash{fourth.Hash},
+			wantObjects:   2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got := NewShallowUpdate(cache, refs, tt.req)
+				if !reflect.DeepEqual(got.Shallow, tt.wantShallow) {
+					t.Errorf("NewShallowUpdate() Shallow = %v, want %v", got.Shallow, tt.wantShallow)
+				}
+				if !reflect.DeepEqual(got.Unshallow, tt.wantUnshallow) {
+					t.Errorf("NewShallowUpdate() Unshallow = %v, want %v", got.Unshallow, tt.wantUnshallow)
+				}
+				if pack := findNewObjects(cache, tt.req.Wants, map[plumbing.Hash]bool{}, got, Filter{}); len(pack) != tt.wantObjects {
+					t.Errorf("findNewObjects() returned %d objects, want %d", len(pack), tt.wantObjects)
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,83 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+)
+
+// Sideband writes the response of a fetch or push to the client. When the
+// client selected side-band or side-band-64k the data is multiplexed with the
+// progress messages and fatal errors, otherwise the data is written directly
+// and messages are written to stderr, which is discarded when nil.
+type Sideband struct {
+	w      io.Writer
+	stderr io.Writer
+	muxer  *sideband.Muxer
+	quiet  bool
+}
+
+func NewSideband(w io.Writer, stderr io.Writer, capabilities map[string]string) *Sideband {
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+
+	s := &Sideband{w: w, stderr: stderr}
+	if _, ok := capabilities["side-band-64k"]; ok {
+		s.muxer = sideband.NewMuxer(sideband.Sideband64k, w)
+	} else if _, ok := capabilities["side-band"]; ok {
+		s.muxer = sideband.NewMuxer(sideband.Sideband, w)
+	}
+	_, noProgress := capabilities["no-progress"]
+	_, quiet := capabilities["quiet"]
+	s.quiet = noProgress || quiet
+
+	return s
+}
+
+// Multiplexed reports whether the client selected a sideband.
+func (s *Sideband) Multiplexed() bool {
+	return s.muxer != nil
+}
+
+// Write sends data on the pack data band.
+func (s *Sideband) Write(p []byte) (int, error) {
+	if s.muxer == nil {
+		return s.w.Write(p)
+	}
+	return s.muxer.Write(p)
+}
+
+// Progress sends a human readable message on the progress band, messages are
+// dropped when the client asked for no progress.
+func (s *Sideband) Progress(format string, a ...interface{}) {
+	if s.quiet {
+		return
+	}
+	s.message(sideband.ProgressMessage, fmt.Sprintf(format, a...))
+}
+
+// Fatal sends a message on the error band, the client aborts the operation
+// once it is received.
+func (s *Sideband) Fatal(format string, a ...interface{}) {
+	s.message(sideband.ErrorMessage, fmt.Sprintf(format, a...))
+}
+
+func (s *Sideband) message(ch sideband.Channel, msg string) {
+	if s.muxer == nil {
+		s.stderr.Write([]byte(msg))
+		return
+	}
+	s.muxer.WriteChannel(ch, []byte(msg))
+}
+
+// Close terminates a multiplexed response with a flush packet.
+func (s *Sideband) Close() {
+	if s.muxer == nil {
+		return
+	}
+	pktline.NewEncoder(s.w).Flush()
+}
@@ -0,0 +1,51 @@
+package git
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSideband(t *testing.T) {
+	tests := []struct {
+		name         string
+		capabilities map[string]string
+		wantW        string
+		wantStderr   string
+	}{
+		{
+			name:         "No Sideband",
+			capabilities: map[string]string{},
+			wantW:        "PACK",
+			wantStderr:   "Counting objects: 1, done.\nfatal\n",
+		},
+		{
+			name:         "Sideband 64k",
+			capabilities: map[string]string{"side-band-64k": ""},
+			wantW:        "0009\x01PACK0020\x02Counting objects: 1, done.\n000b\x03fatal\n0000",
+		},
+		{
+			name:         "No Progress",
+			capabilities: map[string]string{"side-band-64k": "", "no-progress": ""},
+			wantW:        "0009\x01PACK000b\x03fatal\n0000",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				w, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+				sb := NewSideband(w, stderr, tt.capabilities)
+				sb.Write([]byte("PACK"))
+				sb.Progress("Counting objects: %d, done.\n", 1)
+				sb.Fatal("fatal\n")
+				sb.Close()
+
+				if got := w.String(); got != tt.wantW {
+					t.Errorf("Sideband wrote %q, want %q", got, tt.wantW)
+				}
+				if got := stderr.String(); got != tt.wantStderr {
+					t.Errorf("Sideband wrote %q to stderr, want %q", got, tt.wantStderr)
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,124 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/Jameslikestea/grm/internal/storage"
+)
+
+// SkipDir can be returned by the function passed to WalkTree to skip the
+// contents of the directory that it was called for.
+var SkipDir = errors.New("skip this directory")
+
+// TreeFile is a single entry found while walking a tree, Path is relative to
+// the root of the walk.
+type TreeFile struct {
+	Path string
+	Mode filemode.FileMode
+	Hash plumbing.Hash
+}
+
+// IsDir reports whether the entry is a directory.
+func (f TreeFile) IsDir() bool {
+	return f.Mode == filemode.Dir
+}
+
+func decodeTree(obj storage.Object) (*object.Tree, bool) {
+	t := &object.Tree{}
+	if !decodeObject(obj, plumbing.TreeObject, t) {
+		return nil, false
+	}
+	return t, true
+}
+
+// ResolveCommit follows annotated tags from hash until it reaches a commit.
+func ResolveCommit(stor storage.Storage, repo string, hash plumbing.Hash) (*object.Commit, error) {
+	peeled := PeelReference(stor, repo, hash)
+	obj, err := stor.GetObject(repo, peeled)
+	if err != nil {
+		return nil, fmt.Errorf("missing object %s", peeled.String())
+	}
+	c, ok := decodeCommit(obj)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a commit", peeled.String())
+	}
+	return c, nil
+}
+
+// WalkTree calls fn for every entry reachable from the tree. Directories are
+// visited before their contents and entries are visited in tree order, which
+// is sorted by name. Objects are loaded from storage as they are needed.
+func WalkTree(stor storage.Storage, repo string, tree plumbing.Hash, fn func(TreeFile) error) error {
+	return walkTree(stor, repo, tree, "", fn)
+}
+
+func walkTree(stor storage.Storage, repo string, tree plumbing.Hash, dir string, fn func(TreeFile) error) error {
+	obj, err := stor.GetObject(repo, tree)
+	if err != nil {
+		return fmt.Errorf("missing tree %s", tree.String())
+	}
+	t, ok := decodeTree(obj)
+	if !ok {
+		return fmt.Errorf("invalid tree %s", tree.String())
+	}
+
+	for _, entry := range t.Entries {
+		f := TreeFile{Path: path.Join(dir, entry.Name), Mode: entry.Mode, Hash: entry.Hash}
+		err := fn(f)
+		if f.IsDir() && err == SkipDir {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if f.IsDir() {
+			if err := walkTree(stor, repo, f.Hash, f.Path, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ReadBlob returns the contents of a blob.
+func ReadBlob(stor storage.Storage, repo string, hash plumbing.Hash) ([]byte, error) {
+	obj, err := stor.GetObject(repo, hash)
+	if err != nil {
+		return nil, fmt.Errorf("missing blob %s", hash.String())
+	}
+	if obj.Type != plumbing.BlobObject {
+		return nil, fmt.Errorf("%s is not a blob", hash.String())
+	}
+	return obj.Content, nil
+}
+
+// FindFile looks up the entry at the slash separated path below the tree.
+func FindFile(stor storage.Storage, repo string, tree plumbing.Hash, name string) (TreeFile, error) {
+	f := TreeFile{Mode: filemode.Dir, Hash: tree}
+	for _, part := range strings.Split(strings.Trim(name, "/"), "/") {
+		if !f.IsDir() {
+			return TreeFile{}, fmt.Errorf("%s is not a directory", f.Path)
+		}
+		obj, err := stor.GetObject(repo, f.Hash)
+		if err != nil {
+			return TreeFile{}, fmt.Errorf("missing tree %s", f.Hash.String())
+		}
+		t, ok := decodeTree(obj)
+		if !ok {
+			return TreeFile{}, fmt.Errorf("invalid tree %s", f.Hash.String())
+		}
+		entry, err := t.FindEntry(part)
+		if err != nil {
+			return TreeFile{}, fmt.Errorf("%s: %w", name, err)
+		}
+		f = TreeFile{Path: path.Join(f.Path, part), Mode: entry.Mode, Hash: entry.Hash}
+	}
+	return f, nil
+}
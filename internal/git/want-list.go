@@ -3,8 +3,11 @@ package git
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/format/pktline"
@@ -12,59 +15,116 @@ import (
 )
 
 type WantList map[plumbing.Hash]bool
-type HaveList map[plumbing.Hash]bool
 
-func ParseWantList(wl WantList, reader io.Reader) (WantList, error) {
-	e := pktline.NewScanner(reader)
-	e.Scan()
-	for {
-		b := e.Bytes()
-		if bytes.Equal(b, pktline.Flush) || bytes.Equal(b, []byte("done")) {
-			return wl, nil
-		}
-		c := string(b)
-		c = strings.TrimSpace(c)
-		types := strings.Split(c, " ")
-		if len(types) < 2 {
-			return nil, errors.New("cannot decode want list")
+// UploadRequest is the want list sent by the client at the start of an
+// upload-pack negotiation, along with the capabilities it selected and any
+// limits on how much history it wants.
+type UploadRequest struct {
+	Wants        []plumbing.Hash
+	Capabilities map[string]string
+
+	Shallows    []plumbing.Hash
+	Depth       int
+	DeepenSince time.Time
+	DeepenNot   []string
+
+	Filter Filter
+}
+
+// Has reports whether the client selected the capability.
+func (u UploadRequest) Has(capability string) bool {
+	_, ok := u.Capabilities[capability]
+	return ok
+}
+
+// Deepen reports whether the client asked for its history to be limited.
+func (u UploadRequest) Deepen() bool {
+	return u.Depth > 0 || !u.DeepenSince.IsZero() || len(u.DeepenNot) > 0
+}
+
+// parseUploadLine handles the lines that may appear in both a protocol v0 want
+// list and the arguments of a protocol v2 fetch.
+func parseUploadLine(req *UploadRequest, types []string) error {
+	if len(types) < 2 {
+		return fmt.Errorf("missing argument to %s", types[0])
+	}
+
+	switch types[0] {
+	case "want":
+		hash := plumbing.NewHash(types[1])
+		req.Wants = append(req.Wants, hash)
+		log.Trace().Str("hash", hash.String()).Msg("upload-pack want")
+	case "shallow":
+		req.Shallows = append(req.Shallows, plumbing.NewHash(types[1]))
+	case "deepen":
+		depth, err := strconv.Atoi(types[1])
+		if err != nil || depth < 1 {
+			return fmt.Errorf("invalid deepen: %s", types[1])
 		}
-		if types[0] == "want" {
-			hash := plumbing.NewHash(types[1])
-			wl[hash] = true
-			log.Trace().Str("hash", hash.String()).Msg("upload-pack want")
+		req.Depth = depth
+	case "deepen-since":
+		since, err := strconv.ParseInt(types[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid deepen-since: %s", types[1])
 		}
-
-		if ok := e.Scan(); !ok {
-			log.Error().Msg("cannot decode want list")
+		req.DeepenSince = time.Unix(since, 0)
+	case "deepen-not":
+		req.DeepenNot = append(req.DeepenNot, types[1])
+	case "filter":
+		filter, err := ParseFilter(types[1])
+		if err != nil {
+			return err
 		}
+		req.Filter = filter
 	}
-
-	return wl, nil
+	return nil
 }
 
-func ParseHaveList(hl HaveList, reader io.Reader) (HaveList, error) {
+// DecodeUploadRequest reads the want list up to and including the flush packet
+// that terminates it. Capabilities are only sent on the first want line.
+func DecodeUploadRequest(reader io.Reader) (UploadRequest, error) {
+	req := UploadRequest{Capabilities: map[string]string{}}
+
 	e := pktline.NewScanner(reader)
-	e.Scan()
-	for {
+	for e.Scan() {
 		b := e.Bytes()
-		if bytes.Equal(b, pktline.Flush) || bytes.Equal(b, []byte("done\n")) {
-			return hl, nil
+		if bytes.Equal(b, pktline.Flush) {
+			return req, nil
 		}
-		log.Trace().Bytes("bytes", b).Msg("received want packet")
-		c := string(b)
-		c = strings.TrimSpace(c)
+		c := strings.TrimSpace(string(b))
 		types := strings.Split(c, " ")
 		if len(types) < 2 {
-			return nil, errors.New("cannot decode have list")
+			return req, errors.New("cannot decode want list")
 		}
-		if types[0] == "hl" {
-			hash := plumbing.NewHash(types[1])
-			hl[hash] = true
-			log.Trace().Str("hash", hash.String()).Msg("upload-pack have")
+		if err := parseUploadLine(&req, types); err != nil {
+			return req, err
 		}
-
-		if ok := e.Scan(); !ok {
-			log.Error().Msg("cannot decode want list")
+		if types[0] == "want" {
+			for _, capability := range types[2:] {
+				kv := strings.SplitN(capability, "=", 2)
+				if len(kv) == 2 {
+					req.Capabilities[kv[0]] = kv[1]
+				} else {
+					req.Capabilities[kv[0]] = ""
+				}
+			}
 		}
 	}
+
+	if e.Err() != nil {
+		return req, e.Err()
+	}
+	return req, io.ErrUnexpectedEOF
+}
+
+func ParseWantList(wl WantList, reader io.Reader) (WantList, error) {
+	req, err := DecodeUploadRequest(reader)
+	if err != nil {
+		return nil, err
+	}
+	for _, hash := range req.Wants {
+		wl[hash] = true
+	}
+
+	return wl, nil
 }
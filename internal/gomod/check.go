@@ -0,0 +1,84 @@
+package gomod
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	modzip "golang.org/x/mod/zip"
+
+	"github.com/Jameslikestea/grm/internal/git"
+	"github.com/Jameslikestea/grm/internal/storage"
+)
+
+// CheckGoMod checks that the go.mod of the version declares the module path
+// that GRM serves it from. A tag whose go.mod disagrees can never be used, and
+// as tags are immutable it could never be fixed either. Versions without a
+// go.mod are accepted.
+func CheckGoMod(stor storage.Storage, m Module, v Version) error {
+	f, err := m.findGoMod(stor, v.Ref.Hash)
+	if errors.Is(err, object.ErrEntryNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	content, err := git.ReadBlob(stor, m.GitRepo(), f.Hash)
+	if err != nil {
+		return err
+	}
+
+	mod, err := modfile.ParseLax("go.mod", content, nil)
+	if err != nil {
+		return fmt.Errorf("invalid go.mod: %v", err)
+	}
+	if mod.Module == nil {
+		return errors.New("go.mod has no module directive")
+	}
+
+	got := mod.Module.Mod.Path
+	if got == m.Path() {
+		return nil
+	}
+
+	// Explain the major version suffix rules when they are the only problem
+	prefix, major, ok := module.SplitPathVersion(got)
+	switch {
+	case ok && prefix == m.unversioned().Path() && major == "":
+		return fmt.Errorf("go.mod declares module %s, %s versions must use %s", got, m.Major, m.Path())
+	case ok && prefix == m.unversioned().Path() && m.Major == "":
+		return fmt.Errorf("go.mod declares module %s, it must be tagged %s.x.y", got, major[1:])
+	}
+	return fmt.Errorf("go.mod declares module %s, want %s", got, m.Path())
+}
+
+// CheckFiles checks the tree of the version against the rules for module
+// zips, such as the size limits, file names that are invalid on some systems
+// and paths that only differ in case. The go command refuses a zip that breaks
+// them, so the first invalid file is returned as the error together with its
+// reason. The result also lists the files that are silently left out of the
+// zip, such as vendored packages and files of nested modules.
+func CheckFiles(stor storage.Storage, m Module, v Version) (modzip.CheckedFiles, error) {
+	files, err := Files(stor, m, v)
+	if err != nil {
+		return modzip.CheckedFiles{}, err
+	}
+
+	cf, _ := modzip.CheckFiles(files)
+	switch {
+	case len(cf.Invalid) > 1:
+		return cf, fmt.Errorf("%v (and %d more invalid files)", cf.Invalid[0], len(cf.Invalid)-1)
+	case len(cf.Invalid) == 1:
+		return cf, cf.Invalid[0]
+	case cf.SizeError != nil:
+		return cf, cf.SizeError
+	}
+	return cf, nil
+}
+
+func (m Module) unversioned() Module {
+	m.Major = ""
+	return m
+}
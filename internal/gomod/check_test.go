@@ -0,0 +1,168 @@
+package gomod
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/Jameslikestea/grm/internal/config"
+	"github.com/Jameslikestea/grm/internal/storage"
+	"github.com/Jameslikestea/grm/internal/storage/memory"
+)
+
+func TestCheckGoMod(t *testing.T) {
+	config.SetDomain("grmpkg.com")
+
+	tests := []struct {
+		name    string
+		tag     string
+		files   map[string]string
+		wantErr string
+	}{
+		{
+			name:  "Matching Path",
+			tag:   "v1.0.0",
+			files: map[string]string{"go.mod": "module grmpkg.com/acme/widgets\n"},
+		},
+		{
+			name:  "Matching Major Version",
+			tag:   "v2.0.0",
+			files: map[string]string{"go.mod": "module grmpkg.com/acme/widgets/v2\n"},
+		},
+		{
+			name:  "No go.mod",
+			tag:   "v3.0.0",
+			files: map[string]string{"widgets.go": "package widgets\n"},
+		},
+		{
+			name: "Major Version Subdirectory",
+			tag:  "v2.0.0",
+			files: map[string]string{
+				"go.mod":    "module grmpkg.com/acme/widgets\n",
+				"v2/go.mod": "module grmpkg.com/acme/widgets/v2\n",
+			},
+		},
+		{
+			name:    "Other Path",
+			tag:     "v1.0.0",
+			files:   map[string]string{"go.mod": "module github.com/other/thing\n"},
+			wantErr: "go.mod declares module github.com/other/thing, want grmpkg.com/acme/widgets",
+		},
+		{
+			name:    "Missing Major Suffix",
+			tag:     "v2.0.0",
+			files:   map[string]string{"go.mod": "module grmpkg.com/acme/widgets\n"},
+			wantErr: "go.mod declares module grmpkg.com/acme/widgets, v2 versions must use grmpkg.com/acme/widgets/v2",
+		},
+		{
+			name:    "Unexpected Major Suffix",
+			tag:     "v1.0.0",
+			files:   map[string]string{"go.mod": "module grmpkg.com/acme/widgets/v2\n"},
+			wantErr: "go.mod declares module grmpkg.com/acme/widgets/v2, it must be tagged v2.x.y",
+		},
+		{
+			name:    "No Module Directive",
+			tag:     "v1.0.0",
+			files:   map[string]string{"go.mod": "go 1.16\n"},
+			wantErr: "go.mod has no module directive",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				stor := memory.NewMemoryStorage()
+				ref := storage.Reference{
+					Name: plumbing.NewTagReferenceName(tt.tag),
+					Hash: storeCommit(stor, "acme/widgets.git", tt.files),
+				}
+
+				m, v, ok := TagVersion(stor, "acme", "widgets", ref)
+				if !ok {
+					t.Fatalf("TagVersion() did not find a version")
+				}
+				err := CheckGoMod(stor, m, v)
+				if (err != nil) != (tt.wantErr != "") || (err != nil && err.Error() != tt.wantErr) {
+					t.Errorf("CheckGoMod() error = %v, want %q", err, tt.wantErr)
+				}
+			},
+		)
+	}
+}
+
+func TestCheckFiles(t *testing.T) {
+	config.SetDomain("grmpkg.com")
+
+	tests := []struct {
+		name        string
+		files       map[string]string
+		wantErr     string
+		wantOmitted []string
+	}{
+		{
+			name: "Valid Files",
+			files: map[string]string{
+				"go.mod":             "module grmpkg.com/acme/widgets\n",
+				"widgets.go":         "package widgets\n",
+				"internal/x/x.go":    "package x\n",
+				"vendor/modules.txt": "",
+			},
+		},
+		{
+			name: "Reserved Name",
+			files: map[string]string{
+				"go.mod":     "module grmpkg.com/acme/widgets\n",
+				"cmd/aux.go": "package main\n",
+			},
+			wantErr: "cmd/aux.go: ",
+		},
+		{
+			name: "Case Collision",
+			files: map[string]string{
+				"go.mod":      "module grmpkg.com/acme/widgets\n",
+				"docs/README": "a\n",
+				"docs/readme": "b\n",
+				"Docs/x":      "c\n",
+			},
+			wantErr: "(and 1 more invalid files)",
+		},
+		{
+			name: "Omitted Content",
+			files: map[string]string{
+				"go.mod":                        "module grmpkg.com/acme/widgets\n",
+				"vendor/example.com/dep/dep.go": "package dep\n",
+				"tools/go.mod":                  "module grmpkg.com/acme/widgets/tools\n",
+				"tools/tools.go":                "package tools\n",
+			},
+			wantOmitted: []string{"tools/go.mod", "tools/tools.go", "vendor/example.com/dep/dep.go"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				stor := memory.NewMemoryStorage()
+				ref := storage.Reference{
+					Name: "refs/tags/v1.0.0",
+					Hash: storeCommit(stor, "acme/widgets.git", tt.files),
+				}
+
+				m, v, _ := TagVersion(stor, "acme", "widgets", ref)
+				cf, err := CheckFiles(stor, m, v)
+				if (err != nil) != (tt.wantErr != "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+					t.Errorf("CheckFiles() error = %v, want %q", err, tt.wantErr)
+				}
+
+				omitted := []string{}
+				for _, f := range cf.Omitted {
+					omitted = append(omitted, f.Path)
+				}
+				sort.Strings(omitted)
+				if len(tt.wantOmitted) > 0 && !reflect.DeepEqual(omitted, tt.wantOmitted) {
+					t.Errorf("CheckFiles() omitted = %v, want %v", omitted, tt.wantOmitted)
+				}
+			},
+		)
+	}
+}
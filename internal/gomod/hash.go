@@ -0,0 +1,56 @@
+package gomod
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/mod/sumdb/dirhash"
+
+	"github.com/Jameslikestea/grm/internal/storage"
+)
+
+// Hashes returns the h1: hashes of the module zip and of the go.mod of the
+// version, as they appear in go.sum. The zip hash is computed from the zip
+// that WriteZip serves so the two can never disagree.
+func Hashes(stor storage.Storage, m Module, v Version) (string, string, error) {
+	buf := &bytes.Buffer{}
+	if err := WriteZip(buf, stor, m, v); err != nil {
+		return "", "", err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		return "", "", err
+	}
+
+	names := []string{}
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		files[f.Name] = f
+	}
+	zipHash, err := dirhash.Hash1(
+		names, func(name string) (io.ReadCloser, error) {
+			return files[name].Open()
+		},
+	)
+	if err != nil {
+		return "", "", err
+	}
+
+	mod, err := GoMod(stor, m, v)
+	if err != nil {
+		return "", "", err
+	}
+	modHash, err := dirhash.Hash1(
+		[]string{"go.mod"}, func(string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(mod)), nil
+		},
+	)
+	if err != nil {
+		return "", "", err
+	}
+
+	return zipHash, modHash, nil
+}
@@ -0,0 +1,50 @@
+package gomod
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/mod/sumdb/dirhash"
+
+	"github.com/Jameslikestea/grm/internal/config"
+	"github.com/Jameslikestea/grm/internal/storage"
+	"github.com/Jameslikestea/grm/internal/storage/memory"
+)
+
+func TestHashes(t *testing.T) {
+	config.SetDomain("grmpkg.com")
+	stor := memory.NewMemoryStorage()
+	m := Module{Namespace: "acme", Repo: "widgets"}
+	v := Version{
+		Version: "v1.0.0",
+		Ref: storage.Reference{
+			Hash: storeCommit(
+				stor, m.GitRepo(), map[string]string{
+					"go.mod":     "module grmpkg.com/acme/widgets\n",
+					"widgets.go": "package widgets\n",
+				},
+			),
+		},
+	}
+
+	zipHash, modHash, err := Hashes(stor, m, v)
+	if err != nil {
+		t.Fatalf("Hashes() error = %v", err)
+	}
+
+	// The go command hashes the zip that it downloaded from the proxy
+	f, _ := ioutil.TempFile("", "grm-module-*.zip")
+	defer os.Remove(f.Name())
+	WriteZip(f, stor, m, v)
+	f.Close()
+	want, _ := dirhash.HashZip(f.Name(), dirhash.Hash1)
+	if zipHash != want {
+		t.Errorf("Hashes() zip = %s, want %s", zipHash, want)
+	}
+
+	if !strings.HasPrefix(modHash, "h1:") || modHash == zipHash {
+		t.Errorf("Hashes() go.mod = %s", modHash)
+	}
+}
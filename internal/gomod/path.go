@@ -0,0 +1,75 @@
+package gomod
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/mod/module"
+
+	"github.com/Jameslikestea/grm/internal/config"
+)
+
+// Module is a Go module hosted in a GRM repository. Dir is the subdirectory of
+// the repository that holds a nested module and is empty for the module at
+// its root. Major is the /vN suffix of the module path and is empty for v0
+// and v1.
+type Module struct {
+	Namespace string
+	Repo      string
+	Dir       string
+	Major     string
+}
+
+// GitRepo is the name of the git repository in storage.
+func (m Module) GitRepo() string {
+	return fmt.Sprintf("%s/%s.git", m.Namespace, m.Repo)
+}
+
+// Path is the import path of the module.
+func (m Module) Path() string {
+	p := fmt.Sprintf("%s/%s/%s", config.GetDomain(), m.Namespace, m.Repo)
+	if m.Dir != "" {
+		p += "/" + m.Dir
+	}
+	if m.Major != "" {
+		p += "/" + m.Major
+	}
+	return p
+}
+
+// TagPrefix is the prefix of the tags that are versions of the module, such
+// as tools/ for the module in the tools directory.
+func (m Module) TagPrefix() string {
+	if m.Dir == "" {
+		return ""
+	}
+	return m.Dir + "/"
+}
+
+// ParsePath finds the repository that hosts the module path, which may be in
+// its escaped form as it is sent to a module proxy. Elements after the
+// repository name, other than the major version suffix, are the directory of
+// a nested module.
+func ParsePath(escaped string) (Module, error) {
+	p, err := module.UnescapePath(escaped)
+	if err != nil {
+		return Module{}, err
+	}
+
+	parts := strings.Split(p, "/")
+	if len(parts) < 3 || parts[0] != config.GetDomain() {
+		return Module{}, fmt.Errorf("%s is not hosted by %s", p, config.GetDomain())
+	}
+
+	prefix, major, ok := module.SplitPathVersion(p)
+	if !ok || strings.Count(prefix, "/") < 2 {
+		return Module{}, fmt.Errorf("%s is not a module path", p)
+	}
+	dirs := strings.Split(prefix, "/")[3:]
+	return Module{
+		Namespace: parts[1],
+		Repo:      parts[2],
+		Dir:       strings.Join(dirs, "/"),
+		Major:     strings.TrimPrefix(major, "/"),
+	}, nil
+}
@@ -0,0 +1,78 @@
+package gomod
+
+import (
+	"testing"
+
+	"github.com/Jameslikestea/grm/internal/config"
+)
+
+func TestParsePath(t *testing.T) {
+	config.SetDomain("grmpkg.com")
+
+	tests := []struct {
+		name    string
+		path    string
+		want    Module
+		wantErr bool
+	}{
+		{
+			name: "Repository",
+			path: "grmpkg.com/acme/widgets",
+			want: Module{Namespace: "acme", Repo: "widgets"},
+		},
+		{
+			name: "Major Version",
+			path: "grmpkg.com/acme/widgets/v2",
+			want: Module{Namespace: "acme", Repo: "widgets", Major: "v2"},
+		},
+		{
+			name: "Nested Module",
+			path: "grmpkg.com/acme/widgets/tools/cmd",
+			want: Module{Namespace: "acme", Repo: "widgets", Dir: "tools/cmd"},
+		},
+		{
+			name: "Nested Major Version",
+			path: "grmpkg.com/acme/widgets/tools/v3",
+			want: Module{Namespace: "acme", Repo: "widgets", Dir: "tools", Major: "v3"},
+		},
+		{
+			name: "Escaped",
+			path: "grmpkg.com/!acme/widgets",
+			want: Module{Namespace: "Acme", Repo: "widgets"},
+		},
+		{
+			name:    "Other Domain",
+			path:    "github.com/acme/widgets",
+			wantErr: true,
+		},
+		{
+			name:    "V1 Suffix",
+			path:    "grmpkg.com/acme/widgets/v1",
+			wantErr: true,
+		},
+		{
+			name:    "Namespace Major Version",
+			path:    "grmpkg.com/acme/v2",
+			wantErr: true,
+		},
+		{
+			name:    "Namespace Only",
+			path:    "grmpkg.com/acme",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := ParsePath(tt.path)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("ParsePath() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
+				if got != tt.want {
+					t.Errorf("ParsePath() got = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
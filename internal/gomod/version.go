@@ -0,0 +1,188 @@
+package gomod
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+
+	"github.com/Jameslikestea/grm/internal/git"
+	"github.com/Jameslikestea/grm/internal/storage"
+)
+
+// ErrUnknownVersion is returned when no tag of the repository provides the
+// requested version of a module.
+var ErrUnknownVersion = errors.New("unknown revision")
+
+// Version is a version of a module along with the tag that provides it.
+type Version struct {
+	Version string
+	Ref     storage.Reference
+}
+
+// Info is the version metadata served by a module proxy, the field names are
+// part of the GOPROXY protocol.
+type Info struct {
+	Version string
+	Time    time.Time
+}
+
+// Versions lists the versions of the module sorted by semantic version. Only
+// canonical semver tags are versions and their major version must match the
+// module path, tags from v2 onwards without a go.mod are served from the
+// unversioned path as +incompatible.
+func Versions(stor storage.Storage, m Module) ([]Version, error) {
+	refs, err := stor.ListReferences(m.GitRepo())
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []Version{}
+	for _, ref := range refs {
+		if !ref.Name.IsTag() {
+			continue
+		}
+		if v, ok := m.version(stor, ref); ok {
+			versions = append(versions, Version{Version: v, Ref: ref})
+		}
+	}
+
+	sort.Slice(
+		versions, func(i, j int) bool {
+			return semver.Compare(versions[i].Version, versions[j].Version) < 0
+		},
+	)
+	return versions, nil
+}
+
+func (m Module) version(stor storage.Storage, ref storage.Reference) (string, bool) {
+	tag := ref.Name.Short()
+	if !strings.HasPrefix(tag, m.TagPrefix()) {
+		return "", false
+	}
+	tag = strings.TrimPrefix(tag, m.TagPrefix())
+	if !semver.IsValid(tag) || semver.Canonical(tag) != tag {
+		return "", false
+	}
+
+	// A nested module only exists where its directory has a go.mod, so it
+	// never has +incompatible versions
+	if m.Dir != "" {
+		if _, err := m.findGoMod(stor, ref.Hash); err != nil {
+			return "", false
+		}
+	}
+
+	major := semver.Major(tag)
+	switch {
+	case m.Major != "":
+		return tag, major == m.Major
+	case major == "v0" || major == "v1":
+		return tag, true
+	case m.Dir != "":
+		return "", false
+	}
+
+	if _, err := m.findGoMod(stor, ref.Hash); err == nil {
+		return "", false
+	}
+	return tag + "+incompatible", true
+}
+
+// TagVersion finds the module version that the tag provides. Tags from v2
+// onwards belong to the /vN module path when they have a go.mod, either in the
+// directory of the module or in its vN subdirectory, and tags with a directory
+// prefix such as tools/v1.2.0 belong to the module in that directory.
+func TagVersion(stor storage.Storage, namespace, repo string, ref storage.Reference) (Module, Version, bool) {
+	m := Module{Namespace: namespace, Repo: repo}
+	name := ref.Name.Short()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		m.Dir = name[:i]
+	}
+	tag := strings.TrimPrefix(name, m.TagPrefix())
+	if !ref.Name.IsTag() || !semver.IsValid(tag) {
+		return m, Version{}, false
+	}
+	if err := module.CheckPath(m.Path()); err != nil {
+		return m, Version{}, false
+	}
+
+	if major := semver.Major(tag); major != "v0" && major != "v1" {
+		versioned := m
+		versioned.Major = major
+		if _, err := versioned.findGoMod(stor, ref.Hash); err == nil {
+			m.Major = major
+		}
+	}
+
+	v, ok := m.version(stor, ref)
+	return m, Version{Version: v, Ref: ref}, ok
+}
+
+// Resolve finds the tag that provides the version of the module.
+func Resolve(stor storage.Storage, m Module, version string) (Version, error) {
+	versions, err := Versions(stor, m)
+	if err != nil {
+		return Version{}, err
+	}
+	for _, v := range versions {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+	return Version{}, ErrUnknownVersion
+}
+
+// Latest picks the version that @latest resolves to, which is the highest
+// release or the highest pre-release when there are no releases. versions must
+// be sorted as returned by Versions.
+func Latest(versions []Version) (Version, bool) {
+	for i := len(versions) - 1; i >= 0; i-- {
+		if semver.Prerelease(versions[i].Version) == "" {
+			return versions[i], true
+		}
+	}
+	if len(versions) == 0 {
+		return Version{}, false
+	}
+	return versions[len(versions)-1], true
+}
+
+// VersionInfo returns the metadata of the version, the time is the commit time
+// of the tagged commit.
+func VersionInfo(stor storage.Storage, m Module, v Version) (Info, error) {
+	c, err := git.ResolveCommit(stor, m.GitRepo(), v.Ref.Hash)
+	if err != nil {
+		return Info{}, err
+	}
+	return Info{Version: v.Version, Time: c.Committer.When.UTC()}, nil
+}
+
+// findGoMod returns the go.mod in the directory of the module in the tree of
+// the tagged commit.
+func (m Module) findGoMod(stor storage.Storage, hash plumbing.Hash) (git.TreeFile, error) {
+	c, err := git.ResolveCommit(stor, m.GitRepo(), hash)
+	if err != nil {
+		return git.TreeFile{}, err
+	}
+	return git.FindFile(stor, m.GitRepo(), c.TreeHash, path.Join(m.codeDir(stor, c.TreeHash), "go.mod"))
+}
+
+// codeDir returns the directory of the tree that holds the module. From v2
+// onwards the go command also finds a module in the vN subdirectory of its
+// directory, which is tagged in the same way, as long as it has a go.mod.
+func (m Module) codeDir(stor storage.Storage, tree plumbing.Hash) string {
+	if m.Major == "" {
+		return m.Dir
+	}
+	dir := path.Join(m.Dir, m.Major)
+	if f, err := git.FindFile(stor, m.GitRepo(), tree, path.Join(dir, "go.mod")); err == nil && f.Mode.IsFile() {
+		return dir
+	}
+	return m.Dir
+}
@@ -0,0 +1,411 @@
+package gomod
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/Jameslikestea/grm/internal/config"
+	"github.com/Jameslikestea/grm/internal/storage"
+	"github.com/Jameslikestea/grm/internal/storage/memory"
+)
+
+func encodeObject(o interface {
+	Encode(plumbing.EncodedObject) error
+}, t plumbing.ObjectType) storage.Object {
+	m := &plumbing.MemoryObject{}
+	m.SetType(t)
+	o.Encode(m)
+	r, _ := m.Reader()
+	b, _ := ioutil.ReadAll(r)
+	return storage.Object{Hash: m.Hash(), Type: t, Content: b}
+}
+
+func blobObject(content string) storage.Object {
+	return storage.Object{
+		Hash:    plumbing.ComputeHash(plumbing.BlobObject, []byte(content)),
+		Type:    plumbing.BlobObject,
+		Content: []byte(content),
+	}
+}
+
+// storeCommit stores a commit of the files, which are all at the root of the
+// tree, and returns its hash.
+func storeCommit(stor storage.Storage, repo string, files map[string]string) plumbing.Hash {
+	objs, root := treeObjects(files)
+
+	sig := object.Signature{Name: "grm", Email: "grm@grmpkg.com", When: time.Unix(1600000000, 0).UTC()}
+	commit := encodeObject(
+		&object.Commit{Author: sig, Committer: sig, Message: "commit", TreeHash: root.Hash},
+		plumbing.CommitObject,
+	)
+
+	stor.StoreObjects(repo, append(objs, root, commit))
+	return commit.Hash
+}
+
+// treeObjects encodes the files as a tree, names containing a slash are
+// placed in subtrees.
+func treeObjects(files map[string]string) ([]storage.Object, storage.Object) {
+	objs := []storage.Object{}
+	tree := &object.Tree{}
+	dirs := map[string]map[string]string{}
+	for name, content := range files {
+		if i := strings.Index(name, "/"); i >= 0 {
+			if dirs[name[:i]] == nil {
+				dirs[name[:i]] = map[string]string{}
+			}
+			dirs[name[:i]][name[i+1:]] = content
+			continue
+		}
+		blob := blobObject(content)
+		objs = append(objs, blob)
+		tree.Entries = append(tree.Entries, object.TreeEntry{Name: name, Mode: filemode.Regular, Hash: blob.Hash})
+	}
+	for name, dir := range dirs {
+		sub, subtree := treeObjects(dir)
+		objs = append(append(objs, sub...), subtree)
+		tree.Entries = append(tree.Entries, object.TreeEntry{Name: name, Mode: filemode.Dir, Hash: subtree.Hash})
+	}
+	// Git sorts directories as if their names ended with a slash
+	sortName := func(e object.TreeEntry) string {
+		if e.Mode == filemode.Dir {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	sort.Slice(tree.Entries, func(i, j int) bool { return sortName(tree.Entries[i]) < sortName(tree.Entries[j]) })
+	return objs, encodeObject(tree, plumbing.TreeObject)
+}
+
+func TestVersions(t *testing.T) {
+	config.SetDomain("grmpkg.com")
+	stor := memory.NewMemoryStorage()
+
+	v1 := storeCommit(stor, "acme/widgets.git", map[string]string{"go.mod": "module grmpkg.com/acme/widgets\n"})
+	v2 := storeCommit(stor, "acme/widgets.git", map[string]string{"go.mod": "module grmpkg.com/acme/widgets/v2\n"})
+	legacy := storeCommit(stor, "acme/widgets.git", map[string]string{"widgets.go": "package widgets\n"})
+	mono := storeCommit(
+		stor, "acme/widgets.git", map[string]string{
+			"go.mod":       "module grmpkg.com/acme/widgets\n",
+			"tools/go.mod": "module grmpkg.com/acme/widgets/tools\n",
+			"docs/doc.go":  "package docs\n",
+		},
+	)
+	stor.StoreReferences(
+		"acme/widgets.git", []storage.Reference{
+			{Name: "refs/tags/v1.0.0", Hash: v1},
+			{Name: "refs/tags/v1.1.0-rc.1", Hash: v1},
+			{Name: "refs/tags/v1.2", Hash: v1},
+			{Name: "refs/tags/latest", Hash: v1},
+			{Name: "refs/tags/v2.0.0", Hash: v2},
+			{Name: "refs/tags/v3.0.0", Hash: legacy},
+			{Name: "refs/tags/tools/v0.1.0", Hash: mono},
+			{Name: "refs/tags/tools/v0.2.0", Hash: v1},
+			{Name: "refs/tags/tools/v2.0.0", Hash: mono},
+			{Name: "refs/tags/docs/v1.0.0", Hash: mono},
+		},
+	)
+
+	tests := []struct {
+		name   string
+		module Module
+		want   []string
+		latest string
+	}{
+		{
+			name:   "Unversioned Path",
+			module: Module{Namespace: "acme", Repo: "widgets"},
+			want:   []string{"v1.0.0", "v1.1.0-rc.1", "v3.0.0+incompatible"},
+			latest: "v3.0.0+incompatible",
+		},
+		{
+			name:   "Major Version",
+			module: Module{Namespace: "acme", Repo: "widgets", Major: "v2"},
+			want:   []string{"v2.0.0"},
+			latest: "v2.0.0",
+		},
+		{
+			name:   "No Versions",
+			module: Module{Namespace: "acme", Repo: "widgets", Major: "v4"},
+			want:   []string{},
+		},
+		{
+			name:   "Nested Module",
+			module: Module{Namespace: "acme", Repo: "widgets", Dir: "tools"},
+			want:   []string{"v0.1.0"},
+			latest: "v0.1.0",
+		},
+		{
+			name:   "Directory Without go.mod",
+			module: Module{Namespace: "acme", Repo: "widgets", Dir: "docs"},
+			want:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				versions, err := Versions(stor, tt.module)
+				if err != nil {
+					t.Fatalf("Versions() error = %v", err)
+				}
+				got := []string{}
+				for _, v := range versions {
+					got = append(got, v.Version)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("Versions() got = %v, want %v", got, tt.want)
+				}
+
+				latest, _ := Latest(versions)
+				if latest.Version != tt.latest {
+					t.Errorf("Latest() got = %v, want %v", latest.Version, tt.latest)
+				}
+			},
+		)
+	}
+}
+
+func TestLatest_Prerelease(t *testing.T) {
+	versions := []Version{{Version: "v0.1.0-alpha"}, {Version: "v0.2.0-beta"}}
+	if got, _ := Latest(versions); got.Version != "v0.2.0-beta" {
+		t.Errorf("Latest() got = %v, want v0.2.0-beta", got.Version)
+	}
+}
+
+func TestGoMod(t *testing.T) {
+	config.SetDomain("grmpkg.com")
+	stor := memory.NewMemoryStorage()
+	m := Module{Namespace: "acme", Repo: "widgets"}
+
+	withMod := storeCommit(stor, m.GitRepo(), map[string]string{"go.mod": "module grmpkg.com/acme/widgets\n\ngo 1.16\n"})
+	without := storeCommit(stor, m.GitRepo(), map[string]string{"widgets.go": "package widgets\n"})
+
+	tests := []struct {
+		name string
+		hash plumbing.Hash
+		want string
+	}{
+		{
+			name: "Stored",
+			hash: withMod,
+			want: "module grmpkg.com/acme/widgets\n\ngo 1.16\n",
+		},
+		{
+			name: "Synthesized",
+			hash: without,
+			want: "module grmpkg.com/acme/widgets\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := GoMod(stor, m, Version{Version: "v1.0.0", Ref: storage.Reference{Hash: tt.hash}})
+				if err != nil {
+					t.Fatalf("GoMod() error = %v", err)
+				}
+				if string(got) != tt.want {
+					t.Errorf("GoMod() got = %q, want %q", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func TestWriteZip(t *testing.T) {
+	config.SetDomain("grmpkg.com")
+	stor := memory.NewMemoryStorage()
+	m := Module{Namespace: "acme", Repo: "widgets"}
+
+	hash := storeCommit(
+		stor, m.GitRepo(), map[string]string{
+			"go.mod":     "module grmpkg.com/acme/widgets\n",
+			"widgets.go": "package widgets\n",
+		},
+	)
+
+	buf := &bytes.Buffer{}
+	if err := WriteZip(buf, stor, m, Version{Version: "v1.0.0", Ref: storage.Reference{Hash: hash}}); err != nil {
+		t.Fatalf("WriteZip() error = %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("WriteZip() did not write a zip: %v", err)
+	}
+	names := []string{}
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	want := []string{"grmpkg.com/acme/widgets@v1.0.0/go.mod", "grmpkg.com/acme/widgets@v1.0.0/widgets.go"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("WriteZip() wrote %v, want %v", names, want)
+	}
+}
+
+func TestWriteZip_NestedModule(t *testing.T) {
+	config.SetDomain("grmpkg.com")
+	stor := memory.NewMemoryStorage()
+	m := Module{Namespace: "acme", Repo: "widgets", Dir: "tools"}
+
+	hash := storeCommit(
+		stor, m.GitRepo(), map[string]string{
+			"LICENSE":        "MIT\n",
+			"go.mod":         "module grmpkg.com/acme/widgets\n",
+			"widgets.go":     "package widgets\n",
+			"tools/go.mod":   "module grmpkg.com/acme/widgets/tools\n",
+			"tools/tools.go": "package tools\n",
+		},
+	)
+
+	buf := &bytes.Buffer{}
+	if err := WriteZip(buf, stor, m, Version{Version: "v1.0.0", Ref: storage.Reference{Hash: hash}}); err != nil {
+		t.Fatalf("WriteZip() error = %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("WriteZip() did not write a zip: %v", err)
+	}
+	names := []string{}
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	want := []string{
+		"grmpkg.com/acme/widgets/tools@v1.0.0/LICENSE",
+		"grmpkg.com/acme/widgets/tools@v1.0.0/go.mod",
+		"grmpkg.com/acme/widgets/tools@v1.0.0/tools.go",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("WriteZip() wrote %v, want %v", names, want)
+	}
+}
+
+func TestWriteZip_MajorSubdirectory(t *testing.T) {
+	config.SetDomain("grmpkg.com")
+	stor := memory.NewMemoryStorage()
+	m := Module{Namespace: "acme", Repo: "widgets", Major: "v2"}
+
+	hash := storeCommit(
+		stor, m.GitRepo(), map[string]string{
+			"LICENSE":      "MIT\n",
+			"go.mod":       "module grmpkg.com/acme/widgets\n",
+			"widgets.go":   "package widgets\n",
+			"v2/go.mod":    "module grmpkg.com/acme/widgets/v2\n",
+			"v2/client.go": "package widgets\n",
+		},
+	)
+
+	buf := &bytes.Buffer{}
+	if err := WriteZip(buf, stor, m, Version{Version: "v2.0.0", Ref: storage.Reference{Hash: hash}}); err != nil {
+		t.Fatalf("WriteZip() error = %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("WriteZip() did not write a zip: %v", err)
+	}
+	names := []string{}
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	want := []string{
+		"grmpkg.com/acme/widgets/v2@v2.0.0/LICENSE",
+		"grmpkg.com/acme/widgets/v2@v2.0.0/client.go",
+		"grmpkg.com/acme/widgets/v2@v2.0.0/go.mod",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("WriteZip() wrote %v, want %v", names, want)
+	}
+}
+
+func TestTagVersion(t *testing.T) {
+	config.SetDomain("grmpkg.com")
+	stor := memory.NewMemoryStorage()
+
+	withMod := storeCommit(stor, "acme/widgets.git", map[string]string{"go.mod": "module grmpkg.com/acme/widgets/v2\n"})
+	without := storeCommit(stor, "acme/widgets.git", map[string]string{"widgets.go": "package widgets\n"})
+	nested := storeCommit(stor, "acme/widgets.git", map[string]string{"tools/go.mod": "module grmpkg.com/acme/widgets/tools\n"})
+	subdir := storeCommit(
+		stor, "acme/widgets.git", map[string]string{
+			"widgets.go": "package widgets\n",
+			"v2/go.mod":  "module grmpkg.com/acme/widgets/v2\n",
+		},
+	)
+
+	tests := []struct {
+		name    string
+		ref     storage.Reference
+		want    string
+		wantMod string
+		wantOk  bool
+	}{
+		{
+			name:    "V1",
+			ref:     storage.Reference{Name: "refs/tags/v1.0.0", Hash: without},
+			want:    "v1.0.0",
+			wantMod: "grmpkg.com/acme/widgets",
+			wantOk:  true,
+		},
+		{
+			name:    "Major Version",
+			ref:     storage.Reference{Name: "refs/tags/v2.1.0", Hash: withMod},
+			want:    "v2.1.0",
+			wantMod: "grmpkg.com/acme/widgets/v2",
+			wantOk:  true,
+		},
+		{
+			name:    "Major Version Subdirectory",
+			ref:     storage.Reference{Name: "refs/tags/v2.0.0", Hash: subdir},
+			want:    "v2.0.0",
+			wantMod: "grmpkg.com/acme/widgets/v2",
+			wantOk:  true,
+		},
+		{
+			name:    "Incompatible",
+			ref:     storage.Reference{Name: "refs/tags/v3.0.0", Hash: without},
+			want:    "v3.0.0+incompatible",
+			wantMod: "grmpkg.com/acme/widgets",
+			wantOk:  true,
+		},
+		{
+			name:    "Nested Module",
+			ref:     storage.Reference{Name: "refs/tags/tools/v1.2.0", Hash: nested},
+			want:    "v1.2.0",
+			wantMod: "grmpkg.com/acme/widgets/tools",
+			wantOk:  true,
+		},
+		{
+			name: "Nested Directory Without go.mod",
+			ref:  storage.Reference{Name: "refs/tags/tools/v1.2.0", Hash: without},
+		},
+		{
+			name: "Not A Version",
+			ref:  storage.Reference{Name: "refs/tags/latest", Hash: without},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				m, v, ok := TagVersion(stor, "acme", "widgets", tt.ref)
+				if ok != tt.wantOk {
+					t.Fatalf("TagVersion() ok = %v, want %v", ok, tt.wantOk)
+				}
+				if ok && (v.Version != tt.want || m.Path() != tt.wantMod) {
+					t.Errorf("TagVersion() = %s@%s, want %s@%s", m.Path(), v.Version, tt.wantMod, tt.want)
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,156 @@
+package gomod
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	modzip "golang.org/x/mod/zip"
+
+	"github.com/Jameslikestea/grm/internal/git"
+	"github.com/Jameslikestea/grm/internal/storage"
+)
+
+// GoMod returns the go.mod of the version. Versions without one get the
+// minimal go.mod that the go command synthesizes for them.
+func GoMod(stor storage.Storage, m Module, v Version) ([]byte, error) {
+	f, err := m.findGoMod(stor, v.Ref.Hash)
+	if errors.Is(err, object.ErrEntryNotFound) {
+		return []byte(fmt.Sprintf("module %s\n", modfile.AutoQuote(m.Path()))), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return git.ReadBlob(stor, m.GitRepo(), f.Hash)
+}
+
+// Files lists the files in the tree of the version for a module zip. Blobs are
+// only read from storage when they are needed. A nested module gets the files
+// of its directory, along with the LICENSE at the root of the repository when
+// it does not have its own, as the go command does for nested modules and
+// major version subdirectories.
+func Files(stor storage.Storage, m Module, v Version) ([]modzip.File, error) {
+	c, err := git.ResolveCommit(stor, m.GitRepo(), v.Ref.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := c.TreeHash
+	codeDir := m.codeDir(stor, c.TreeHash)
+	if codeDir != "" {
+		dir, err := git.FindFile(stor, m.GitRepo(), c.TreeHash, codeDir)
+		if err != nil {
+			return nil, err
+		}
+		if !dir.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", codeDir)
+		}
+		tree = dir.Hash
+	}
+
+	files := []modzip.File{}
+	license := false
+	err = git.WalkTree(
+		stor, m.GitRepo(), tree, func(f git.TreeFile) error {
+			if f.IsDir() || f.Mode == filemode.Submodule {
+				return nil
+			}
+			license = license || f.Path == "LICENSE"
+			files = append(files, &blobFile{stor: stor, repo: m.GitRepo(), file: f, modified: c.Committer.When})
+			return nil
+		},
+	)
+	if err != nil || codeDir == "" || license {
+		return files, err
+	}
+
+	if f, err := git.FindFile(stor, m.GitRepo(), c.TreeHash, "LICENSE"); err == nil && f.Mode.IsFile() {
+		files = append(files, &blobFile{stor: stor, repo: m.GitRepo(), file: f, modified: c.Committer.When})
+	}
+	return files, nil
+}
+
+// WriteZip writes the module zip of the version following the rules of
+// golang.org/x/mod/zip, so that the go command accepts it from a proxy.
+func WriteZip(w io.Writer, stor storage.Storage, m Module, v Version) error {
+	files, err := Files(stor, m, v)
+	if err != nil {
+		return err
+	}
+	return modzip.Create(w, module.Version{Path: m.Path(), Version: v.Version}, files)
+}
+
+// blobFile is a file of a module zip that is read from a tree.
+type blobFile struct {
+	stor     storage.Storage
+	repo     string
+	file     git.TreeFile
+	modified time.Time
+	content  []byte
+}
+
+func (b *blobFile) Path() string {
+	return b.file.Path
+}
+
+func (b *blobFile) Lstat() (os.FileInfo, error) {
+	if b.content == nil {
+		content, err := git.ReadBlob(b.stor, b.repo, b.file.Hash)
+		if err != nil {
+			return nil, err
+		}
+		b.content = content
+	}
+	return blobInfo{b}, nil
+}
+
+func (b *blobFile) Open() (io.ReadCloser, error) {
+	if _, err := b.Lstat(); err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(b.content)), nil
+}
+
+// blobInfo describes a blobFile, only regular files are added to module zips.
+type blobInfo struct {
+	b *blobFile
+}
+
+func (i blobInfo) Name() string {
+	return path.Base(i.b.file.Path)
+}
+
+func (i blobInfo) Size() int64 {
+	return int64(len(i.b.content))
+}
+
+func (i blobInfo) Mode() os.FileMode {
+	switch i.b.file.Mode {
+	case filemode.Symlink:
+		return os.ModeSymlink | 0777
+	case filemode.Executable:
+		return 0775
+	}
+	return 0664
+}
+
+func (i blobInfo) ModTime() time.Time {
+	return i.b.modified
+}
+
+func (i blobInfo) IsDir() bool {
+	return false
+}
+
+func (i blobInfo) Sys() interface{} {
+	return nil
+}
@@ -8,6 +8,9 @@ type Repo struct {
 	Name      string `json:"name"`
 	Namespace string `json:"namespace"`
 	Public    bool   `json:"public"`
+	// DefaultTag is the tag that HEAD points at, the highest semver tag is
+	// used when it is empty.
+	DefaultTag string `json:"default_tag,omitempty"`
 }
 
 type RepoPermission struct {
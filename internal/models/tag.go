@@ -0,0 +1,19 @@
+package models
+
+var _ Model = Tag{}
+
+// Tag is a tag of a repository along with the metadata recorded when it was
+// pushed. Tags that are module versions also record the go.sum hashes of the
+// module as it was received. Dir is the directory of the nested module that a
+// prefixed tag such as tools/v1.2.0 belongs to.
+type Tag struct {
+	Name        string   `json:"name"`
+	Hash        string   `json:"hash"`
+	Dir         string   `json:"dir,omitempty"`
+	PushOptions []string `json:"push_options,omitempty"`
+
+	Module   string `json:"module,omitempty"`
+	Version  string `json:"version,omitempty"`
+	Sum      string `json:"sum,omitempty"`
+	GoModSum string `json:"go_mod_sum,omitempty"`
+}
@@ -2,4 +2,7 @@ package policy
 
 type Manager interface {
 	Evaluate(query string, input interface{}) bool
+	// Reason evaluates a query whose value is a message explaining a
+	// decision, it is empty when the query is undefined.
+	Reason(query string, input interface{}) string
 }
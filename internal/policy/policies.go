@@ -7,6 +7,8 @@ const (
 
 const (
 	RepoValidName   = repo + ".valid_name"
+	RepoValidTag    = repo + ".valid_tag"
+	RepoTagReason   = repo + ".tag_reason"
 	RepoCreate      = repo + ".create"
 	RepoRead        = repo + ".read"
 	RepoWrite       = repo + ".write"
@@ -0,0 +1,43 @@
+package repos
+
+# Tags are immutable, so only tags that Go can use as module versions are
+# accepted. Nested modules are tagged with their directory as a prefix, such as
+# tools/v1.2.3. Operators can replace this file to change the rules,
+# tag_reason is reported to the client for every rejected tag.
+
+default valid_tag = false
+
+valid_tag = true {
+    not tag_reason
+}
+
+tag_parts := split(input.tag, "/")
+
+tag_version := tag_parts[count(tag_parts) - 1]
+
+tag_prefix := concat("/", array.slice(tag_parts, 0, count(tag_parts) - 1))
+
+tag_reason = sprintf("%s is not a semantic version such as v1.2.3", [tag_version]) {
+    not regex.match(`^v(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(-[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)*)?(\+[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)*)?$`, tag_version)
+} else = sprintf("%s has build metadata, which Go module versions cannot have", [tag_version]) {
+    contains(tag_version, "+")
+} else = sprintf("%s is not a valid module directory", [tag_prefix]) {
+    count(tag_parts) > 1
+    invalid_tag_prefix
+}
+
+invalid_tag_prefix {
+    element := tag_parts[i]
+    i < count(tag_parts) - 1
+    not regex.match(`^[A-Za-z0-9_~+-][A-Za-z0-9._~+-]*$`, element)
+}
+
+invalid_tag_prefix {
+    element := tag_parts[i]
+    i < count(tag_parts) - 1
+    endswith(element, ".")
+}
+
+invalid_tag_prefix {
+    tag_parts[0] == "vendor"
+}
@@ -0,0 +1,34 @@
+package repos
+
+test_release_tag {
+    valid_tag with input as {"tag": "v1.2.3"}
+}
+
+test_prerelease_tag {
+    valid_tag with input as {"tag": "v0.1.0-rc.1"}
+}
+
+test_nested_module_tag {
+    valid_tag with input as {"tag": "tools/cmd/v1.2.0"}
+}
+
+test_short_version {
+    not valid_tag with input as {"tag": "v1.2"}
+    tag_reason == "v1.2 is not a semantic version such as v1.2.3" with input as {"tag": "v1.2"}
+}
+
+test_named_tag {
+    not valid_tag with input as {"tag": "latest"}
+}
+
+test_build_metadata {
+    tag_reason == "v1.2.3+build.1 has build metadata, which Go module versions cannot have" with input as {"tag": "v1.2.3+build.1"}
+}
+
+test_invalid_prefix {
+    tag_reason == "tools/.hidden is not a valid module directory" with input as {"tag": "tools/.hidden/v1.0.0"}
+}
+
+test_vendor_prefix {
+    not valid_tag with input as {"tag": "vendor/v1.0.0"}
+}
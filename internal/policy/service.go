@@ -5,10 +5,14 @@ import (
 	"embed"
 	"io/fs"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/rego"
 	"github.com/rs/zerolog/log"
+
+	"github.com/Jameslikestea/grm/internal/config"
 )
 
 var _ Manager = (*Service)(nil)
@@ -33,6 +37,7 @@ func New() *Service {
 			return nil
 		},
 	)
+	loadOverrides(r, config.GetPolicyDirectory())
 
 	re, err := ast.CompileModules(r)
 	if err != nil {
@@ -45,6 +50,38 @@ func New() *Service {
 	}
 }
 
+// loadOverrides reads the rego files in dir, a file replaces the built in
+// policy with the same path so that operators can change the rules without
+// rebuilding, repos/tag.rego replaces the tag naming policy for example.
+func loadOverrides(r map[string]string, dir string) {
+	if dir == "" {
+		return
+	}
+	filepath.WalkDir(
+		dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				log.Warn().Err(err).Str("path", path).Msg("Cannot read policy directory")
+				return nil
+			}
+			if d.IsDir() || filepath.Ext(path) != ".rego" {
+				return nil
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return nil
+			}
+			b, err := os.ReadFile(path)
+			if err != nil {
+				log.Warn().Err(err).Str("path", path).Msg("Cannot read policy")
+				return nil
+			}
+			log.Info().Str("path", path).Msg("Loading Policy Override")
+			r["policies/"+filepath.ToSlash(rel)] = string(b)
+			return nil
+		},
+	)
+}
+
 func (s *Service) Evaluate(query string, input interface{}) bool {
 	log.Info().Interface("input", input).Msg("Querying Policies")
 	rs, err := rego.New(
@@ -57,3 +94,19 @@ func (s *Service) Evaluate(query string, input interface{}) bool {
 
 	return rs.Allowed()
 }
+
+func (s *Service) Reason(query string, input interface{}) string {
+	rs, err := rego.New(
+		rego.Query(query), rego.Compiler(s.r), rego.Input(input),
+	).Eval(context.Background())
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to evaluate query")
+		return ""
+	}
+	if len(rs) == 0 || len(rs[0].Expressions) == 0 {
+		return ""
+	}
+
+	reason, _ := rs[0].Expressions[0].Value.(string)
+	return reason
+}
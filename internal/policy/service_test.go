@@ -40,6 +40,38 @@ func TestService_Evaluate(t *testing.T) {
 			},
 			want: false,
 		},
+		{
+			name: "Semantic version tag",
+			args: args{
+				query: RepoValidTag,
+				input: PolicyRequest{Tag: "v1.2.3"},
+			},
+			want: true,
+		},
+		{
+			name: "Nested module tag",
+			args: args{
+				query: RepoValidTag,
+				input: PolicyRequest{Tag: "tools/v0.1.0-beta.1"},
+			},
+			want: true,
+		},
+		{
+			name: "Incomplete version tag",
+			args: args{
+				query: RepoValidTag,
+				input: PolicyRequest{Tag: "v1.2"},
+			},
+			want: false,
+		},
+		{
+			name: "Named tag",
+			args: args{
+				query: RepoValidTag,
+				input: PolicyRequest{Tag: "release"},
+			},
+			want: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(
@@ -52,3 +84,37 @@ func TestService_Evaluate(t *testing.T) {
 		)
 	}
 }
+
+func TestService_Reason(t *testing.T) {
+	tests := []struct {
+		name string
+		tag  string
+		want string
+	}{
+		{
+			name: "Valid tag",
+			tag:  "v1.0.0",
+			want: "",
+		},
+		{
+			name: "Not a version",
+			tag:  "release",
+			want: "release is not a semantic version such as v1.2.3",
+		},
+		{
+			name: "Build metadata",
+			tag:  "v1.0.0+meta",
+			want: "v1.0.0+meta has build metadata, which Go module versions cannot have",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				s := New()
+				if got := s.Reason(RepoTagReason, PolicyRequest{Tag: tt.tag}); got != tt.want {
+					t.Errorf("Reason() = %q, want %q", got, tt.want)
+				}
+			},
+		)
+	}
+}
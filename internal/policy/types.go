@@ -10,4 +10,5 @@ type PolicyRequest struct {
 	RepoPermissions      []models.RepoPermission       `json:"repo_permissions"`
 	NamespacePermissions []models.NamespacePermission  `json:"namespace_permissions"`
 	UserID               string                        `json:"uid"`
+	Tag                  string                        `json:"tag"`
 }
@@ -6,9 +6,11 @@ type Manager interface {
 	CreateRepo(req models.CreateRepoRequest) models.Repo
 	CreateRepoUserPermission(ns models.RepoPermission)
 	UpdateRepoPermissions(ns models.RepoPermission)
+	SetDefaultTag(namespace, repo, tag string) error
 
 	GetRepo(namespace, repo string) (models.Repo, error)
-	GetTags(namespace, repo string) []string
+	GetTags(namespace, repo string) []models.Tag
+	StoreTag(namespace, repo string, tag models.Tag)
 	GetReposByNamespace(namespace string) ([]models.Repo, error)
 	GetRepoPermissions(namespace, repo string) []models.RepoPermission
 	GetRepoUserPermissions(namespace, repo, uid string) models.RepoPermission
@@ -2,10 +2,13 @@ package service
 
 import (
 	"encoding/json"
+	"errors"
 	"sort"
+	"strings"
 
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/mod/semver"
 
 	"github.com/Jameslikestea/grm/internal/models"
 	"github.com/Jameslikestea/grm/internal/repository"
@@ -17,9 +20,15 @@ var _ repository.Manager = (*Service)(nil)
 const hashSalt = "repo:"
 const hashPermSalt = "repo:permission:"
 const hashNsSalt = "repo:ns:"
+const hashTagSalt = "repo:tag:"
 const repo = "_internal._repo"
 const permRepo = "_internal._repo._permissions"
 const nsRepo = "_internal._repo._namespace"
+const tagRepo = "_internal._repo._tags"
+
+// updateAttempts is how often SetDefaultTag retries when the repos of the
+// namespace change between reading and replacing them.
+const updateAttempts = 5
 
 type Service struct {
 	stor storage.Storage
@@ -126,6 +135,54 @@ func (s *Service) GetRepo(namespace, r string) (models.Repo, error) {
 	return ns, err
 }
 
+// SetDefaultTag changes the tag that HEAD points at, an empty tag goes back to
+// the highest semver tag.
+func (s *Service) SetDefaultTag(namespace, r, tag string) error {
+	re, err := s.GetRepo(namespace, r)
+	if err != nil {
+		return err
+	}
+	re.DefaultTag = tag
+
+	h := plumbing.ComputeHash(0, []byte(hashSalt+":"+re.Namespace+":"+re.Name))
+	obj := storage.Object{
+		Hash:    h,
+		Type:    0,
+		Content: models.Marshal(re),
+	}
+	if err := s.stor.StoreObject(repo, obj, 0); err != nil {
+		return err
+	}
+
+	// The namespace keeps its own copy of every repo for listings, which has
+	// to agree with the HEAD that is served. Other repos of the namespace can
+	// change it at the same time, so it is only replaced while unchanged.
+	nsh := plumbing.ComputeHash(0, []byte(hashNsSalt+re.Namespace))
+	for attempt := 0; attempt < updateAttempts; attempt++ {
+		o, err := s.stor.GetObject(nsRepo, nsh)
+		if err != nil {
+			return err
+		}
+		var repos []models.Repo
+		if err := models.Unmarshal(o.Content, &repos); err != nil {
+			return err
+		}
+		for i := range repos {
+			if repos[i].Name == re.Name {
+				repos[i] = re
+			}
+		}
+
+		err = s.stor.ReplaceObject(nsRepo, o, storage.Object{Hash: nsh, Type: 0, Content: models.Marshal(repos)})
+		if err != storage.ErrObjectChanged {
+			return err
+		}
+		log.Debug().Str("namespace", re.Namespace).Int("attempt", attempt).Msg("Namespace repos changed concurrently, retrying")
+	}
+
+	return errors.New("cannot set default tag: too many concurrent updates")
+}
+
 func (s *Service) GetReposByNamespace(namespace string) ([]models.Repo, error) {
 	var ns []models.Repo
 	h := plumbing.ComputeHash(0, []byte(hashNsSalt+namespace))
@@ -161,16 +218,60 @@ func (s *Service) GetRepoUserPermissions(namespace, r, uid string) models.RepoPe
 	return m
 }
 
-func (s *Service) GetTags(ns, r string) []string {
-	var tags []string
+// GetTags lists the tags of the repository with the metadata that was stored
+// when each of them was pushed. Tags are grouped by the directory of the
+// module they belong to, with the root module first, and versions within a
+// module are sorted by semantic version.
+func (s *Service) GetTags(ns, r string) []models.Tag {
+	tags := []models.Tag{}
 
 	references, _ := s.stor.ListReferences(ns + "/" + r + ".git")
 
 	for _, ref := range references {
-		tags = append(tags, ref.Name.Short())
+		tag := models.Tag{}
+		h := plumbing.ComputeHash(0, []byte(hashTagSalt+ns+":"+r+":"+ref.Name.Short()))
+		if o, err := s.stor.GetObject(tagRepo, h); err == nil {
+			models.Unmarshal(o.Content, &tag)
+		}
+		tag.Name = ref.Name.Short()
+		tag.Hash = ref.Hash.String()
+		tag.Dir = ""
+		if i := strings.LastIndex(tag.Name, "/"); i >= 0 {
+			tag.Dir = tag.Name[:i]
+		}
+		tags = append(tags, tag)
 	}
 
-	sort.Strings(tags)
+	sort.Slice(
+		tags, func(i, j int) bool {
+			return lessTag(tags[i], tags[j])
+		},
+	)
 
 	return tags
 }
+
+func lessTag(a, b models.Tag) bool {
+	if a.Dir != b.Dir {
+		return a.Dir < b.Dir
+	}
+	va := strings.TrimPrefix(a.Name, a.Dir+"/")
+	vb := strings.TrimPrefix(b.Name, b.Dir+"/")
+	if semver.IsValid(va) && semver.IsValid(vb) && semver.Compare(va, vb) != 0 {
+		return semver.Compare(va, vb) < 0
+	}
+	return a.Name < b.Name
+}
+
+// StoreTag records the metadata of a tag that has just been pushed.
+func (s *Service) StoreTag(ns, r string, tag models.Tag) {
+	h := plumbing.ComputeHash(0, []byte(hashTagSalt+ns+":"+r+":"+tag.Name))
+	obj := storage.Object{
+		Hash:    h,
+		Type:    0,
+		Content: models.Marshal(tag),
+	}
+	if err := s.stor.StoreObject(tagRepo, obj, 0); err != nil {
+		log.Warn().Err(err).Str("tag", tag.Name).Msg("Cannot store tag metadata")
+	}
+}
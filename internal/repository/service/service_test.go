@@ -0,0 +1,147 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/Jameslikestea/grm/internal/models"
+	"github.com/Jameslikestea/grm/internal/storage"
+	"github.com/Jameslikestea/grm/internal/storage/memory"
+)
+
+func TestService_GetTags(t *testing.T) {
+	stor := memory.NewMemoryStorage()
+	hash := plumbing.ComputeHash(plumbing.BlobObject, []byte("tag"))
+	stor.CreateReferences(
+		"acme/widgets.git", []storage.Reference{
+			{Name: "refs/tags/v1.10.0", Hash: hash},
+			{Name: "refs/tags/tools/v0.2.0", Hash: hash},
+			{Name: "refs/tags/v1.9.0", Hash: hash},
+			{Name: "refs/tags/release", Hash: hash},
+			{Name: "refs/tags/tools/v0.10.0", Hash: hash},
+		},
+	)
+
+	s := New(stor)
+	s.StoreTag("acme", "widgets", models.Tag{Name: "tools/v0.2.0", PushOptions: []string{"ci=1"}})
+
+	names := []string{}
+	dirs := []string{}
+	for _, tag := range s.GetTags("acme", "widgets") {
+		names = append(names, tag.Name)
+		dirs = append(dirs, tag.Dir)
+		if tag.Name == "tools/v0.2.0" && !reflect.DeepEqual(tag.PushOptions, []string{"ci=1"}) {
+			t.Errorf("GetTags() lost the push options of %s", tag.Name)
+		}
+	}
+
+	wantNames := []string{"release", "v1.9.0", "v1.10.0", "tools/v0.2.0", "tools/v0.10.0"}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("GetTags() names = %v, want %v", names, wantNames)
+	}
+	wantDirs := []string{"", "", "", "tools", "tools"}
+	if !reflect.DeepEqual(dirs, wantDirs) {
+		t.Errorf("GetTags() dirs = %v, want %v", dirs, wantDirs)
+	}
+}
+
+func TestService_SetDefaultTag(t *testing.T) {
+	s := New(memory.NewMemoryStorage())
+	s.CreateRepo(models.CreateRepoRequest{Namespace: "acme", Name: "widgets"})
+	s.CreateRepo(models.CreateRepoRequest{Namespace: "acme", Name: "gadgets"})
+
+	if err := s.SetDefaultTag("acme", "widgets", "v1.2.0"); err != nil {
+		t.Fatalf("SetDefaultTag() error = %v", err)
+	}
+
+	re, err := s.GetRepo("acme", "widgets")
+	if err != nil || re.DefaultTag != "v1.2.0" {
+		t.Errorf("GetRepo() = %+v, %v, want default tag v1.2.0", re, err)
+	}
+
+	repos, err := s.GetReposByNamespace("acme")
+	if err != nil {
+		t.Fatalf("GetReposByNamespace() error = %v", err)
+	}
+	tags := map[string]string{}
+	for _, re := range repos {
+		tags[re.Name] = re.DefaultTag
+	}
+	want := map[string]string{"widgets": "v1.2.0", "gadgets": ""}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("GetReposByNamespace() default tags = %v, want %v", tags, want)
+	}
+}
+
+// changing is storage where another server replaces one object with its own
+// content before the next replacement lands.
+type changing struct {
+	*memory.MemoryStorage
+	times int
+}
+
+func (c *changing) ReplaceObject(repo string, old storage.Object, object storage.Object) error {
+	if c.times > 0 {
+		c.times--
+		var repos []models.Repo
+		models.Unmarshal(old.Content, &repos)
+		repos = append(repos, models.Repo{Namespace: "acme", Name: fmt.Sprintf("tools%d", c.times)})
+		c.MemoryStorage.ReplaceObject(repo, old, storage.Object{Hash: old.Hash, Content: models.Marshal(repos)})
+	}
+	return c.MemoryStorage.ReplaceObject(repo, old, object)
+}
+
+func TestService_SetDefaultTag_Conflict(t *testing.T) {
+	tests := []struct {
+		name    string
+		times   int
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "Changed Once",
+			times: 1,
+			want:  []string{"tools0", "widgets"},
+		},
+		{
+			name:    "Changed Too Often",
+			times:   updateAttempts,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				stor := &changing{MemoryStorage: memory.NewMemoryStorage()}
+				s := New(stor)
+				s.CreateRepo(models.CreateRepoRequest{Namespace: "acme", Name: "widgets"})
+
+				stor.times = tt.times
+				err := s.SetDefaultTag("acme", "widgets", "v1.2.0")
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("SetDefaultTag() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if tt.wantErr {
+					return
+				}
+
+				repos, _ := s.GetReposByNamespace("acme")
+				var names []string
+				for _, re := range repos {
+					names = append(names, re.Name)
+					if re.Name == "widgets" && re.DefaultTag != "v1.2.0" {
+						t.Errorf("GetReposByNamespace() default tag = %q, want v1.2.0", re.DefaultTag)
+					}
+				}
+				sort.Strings(names)
+				if !reflect.DeepEqual(names, tt.want) {
+					t.Errorf("GetReposByNamespace() = %v, want %v", names, tt.want)
+				}
+			},
+		)
+	}
+}
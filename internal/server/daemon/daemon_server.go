@@ -0,0 +1,188 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/rs/zerolog/log"
+
+	"github.com/Jameslikestea/grm/internal/config"
+	"github.com/Jameslikestea/grm/internal/git"
+	"github.com/Jameslikestea/grm/internal/namespace"
+	servicens "github.com/Jameslikestea/grm/internal/namespace/service"
+	"github.com/Jameslikestea/grm/internal/policy"
+	"github.com/Jameslikestea/grm/internal/repository"
+	servicers "github.com/Jameslikestea/grm/internal/repository/service"
+	"github.com/Jameslikestea/grm/internal/server/ssh/upload"
+	"github.com/Jameslikestea/grm/internal/storage"
+	"github.com/Jameslikestea/grm/internal/storage/cql"
+	"github.com/Jameslikestea/grm/internal/storage/memory"
+	"github.com/Jameslikestea/grm/internal/storage/s3aws"
+)
+
+// requestTimeout bounds how long a client may take to send its request, the
+// connection is not limited once the fetch has started.
+const requestTimeout = 30 * time.Second
+
+// Server speaks the git daemon protocol. It only serves git-upload-pack and
+// every caller is anonymous, so only repositories that anonymous users can
+// read are available.
+type Server struct {
+	stor storage.Storage
+	pol  policy.Manager
+	ns   namespace.Manager
+	rs   repository.Manager
+}
+
+// Request is the first packet sent by a git daemon client.
+type Request struct {
+	Service string
+	Path    string
+	Host    string
+	Version int
+}
+
+func NewServer() *Server {
+	var stor storage.Storage
+
+	switch strings.ToUpper(config.GetStorageType()) {
+	case "MEMORY":
+		stor = memory.NewMemoryStorage()
+	case "S3":
+		stor = s3.NewS3Storage()
+	case "CQL":
+		stor = cql.NewCQLStorage()
+	default:
+		log.Warn().Msg("No Acceptable Storage Engine Chosen, Defaulting to In Memory")
+		stor = memory.NewMemoryStorage()
+	}
+
+	return &Server{
+		stor: stor,
+		pol:  policy.New(),
+		ns:   servicens.New(stor),
+		rs:   servicers.New(stor),
+	}
+}
+
+// ParseRequest decodes the request packet. It names the service and the
+// repository, followed by the NUL separated host and any extra parameters
+// such as the protocol version.
+func ParseRequest(b []byte) (Request, error) {
+	parts := strings.Split(strings.TrimSuffix(string(b), "\n"), "\x00")
+
+	cmd := strings.SplitN(parts[0], " ", 2)
+	if len(cmd) != 2 {
+		return Request{}, errors.New("invalid request")
+	}
+
+	req := Request{Service: cmd[0], Path: cmd[1]}
+	extra := []string{}
+	for _, param := range parts[1:] {
+		switch {
+		case strings.HasPrefix(param, "host="):
+			req.Host = strings.TrimPrefix(param, "host=")
+		case param != "":
+			extra = append(extra, param)
+		}
+	}
+	req.Version = git.ProtocolVersion(strings.Join(extra, ":"))
+
+	return req, nil
+}
+
+// splitPath returns the namespace and repository addressed by the request.
+func splitPath(path string) (string, string, bool) {
+	repo := strings.Split(strings.TrimSuffix(strings.Trim(path, "/"), ".git"), "/")
+	if len(repo) != 2 {
+		return "", "", false
+	}
+	for _, part := range repo {
+		if part == "" || part == "." || part == ".." {
+			return "", "", false
+		}
+	}
+	return repo[0], repo[1], true
+}
+
+func (s *Server) listen() {
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%s", config.GetDaemonInterface(), config.GetDaemonPort()))
+	if err != nil {
+		log.Fatal().Err(err).Msg("Cannot start git daemon listener")
+		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+		return
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Warn().Err(err).Msg("Error accepting connection")
+			continue
+		}
+
+		go s.handleConnection(conn)
+	}
+}
+
+func (s *Server) handleConnection(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(requestTimeout))
+
+	e := pktline.NewEncoder(conn)
+	sc := pktline.NewScanner(conn)
+	if !sc.Scan() {
+		log.Warn().Err(sc.Err()).Msg("Cannot read git daemon request")
+		return
+	}
+
+	req, err := ParseRequest(sc.Bytes())
+	if err != nil {
+		log.Warn().Err(err).Msg("Cannot parse git daemon request")
+		e.Encodef("ERR %s\n", err)
+		return
+	}
+	log.Info().Str("service", req.Service).Str("path", req.Path).Str("host", req.Host).Int(
+		"version",
+		req.Version,
+	).Msg("Git Daemon Request")
+
+	if req.Service != "git-upload-pack" {
+		e.Encodef("ERR service not enabled: %s\n", req.Service)
+		return
+	}
+
+	ns, name, ok := splitPath(req.Path)
+	if !ok {
+		e.Encodef("ERR access denied or repository not exported: %s\n", req.Path)
+		return
+	}
+
+	repo, _ := s.rs.GetRepo(ns, name)
+	nspc, _ := s.ns.GetNamespace(ns)
+	allow := s.pol.Evaluate(
+		policy.RepoRead, policy.PolicyRequest{
+			RepoPermissions:      s.rs.GetRepoPermissions(ns, name),
+			Repo:                 repo,
+			NamespacePermissions: s.ns.GetNamespacePermissions(ns),
+			Namespace:            nspc,
+		},
+	)
+	if !allow {
+		e.Encodef("ERR access denied or repository not exported: %s\n", req.Path)
+		return
+	}
+
+	conn.SetReadDeadline(time.Time{})
+	upload.UploadPack(conn, ioutil.Discard, fmt.Sprintf("%s/%s.git", ns, name), s.stor, req.Version, repo.DefaultTag)
+}
+
+func (s *Server) Run() {
+	log.Info().Msg("Starting Git Daemon")
+	s.listen()
+}
@@ -0,0 +1,70 @@
+package daemon
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Jameslikestea/grm/internal/git"
+)
+
+func TestParseRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		b       string
+		want    Request
+		wantErr bool
+	}{
+		{
+			name: "Protocol V0",
+			b:    "git-upload-pack /ns/repo.git\x00host=grmpkg.com\x00",
+			want: Request{Service: "git-upload-pack", Path: "/ns/repo.git", Host: "grmpkg.com", Version: git.ProtocolV0},
+		},
+		{
+			name: "Protocol V2",
+			b:    "git-upload-pack /ns/repo.git\x00host=grmpkg.com\x00\x00version=2\x00",
+			want: Request{Service: "git-upload-pack", Path: "/ns/repo.git", Host: "grmpkg.com", Version: git.ProtocolV2},
+		},
+		{
+			name:    "Missing Path",
+			b:       "git-upload-pack\x00host=grmpkg.com\x00",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := ParseRequest([]byte(tt.b))
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("ParseRequest() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("ParseRequest() got = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func TestSplitPath(t *testing.T) {
+	tests := []struct {
+		path   string
+		wantNs string
+		want   string
+		wantOk bool
+	}{
+		{path: "/ns/repo.git", wantNs: "ns", want: "repo", wantOk: true},
+		{path: "/ns/repo", wantNs: "ns", want: "repo", wantOk: true},
+		{path: "/repo.git", wantOk: false},
+		{path: "/ns/../repo.git", wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.path, func(t *testing.T) {
+				ns, repo, ok := splitPath(tt.path)
+				if ns != tt.wantNs || repo != tt.want || ok != tt.wantOk {
+					t.Errorf("splitPath() = %v, %v, %v, want %v, %v, %v", ns, repo, ok, tt.wantNs, tt.want, tt.wantOk)
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"github.com/Jameslikestea/grm/internal/git"
+	"github.com/Jameslikestea/grm/internal/namespace"
+	"github.com/Jameslikestea/grm/internal/policy"
+	"github.com/Jameslikestea/grm/internal/repository"
+	"github.com/Jameslikestea/grm/internal/server/http/middleware"
+	"github.com/Jameslikestea/grm/internal/storage"
+)
+
+// archiveFormats maps the file extensions that can be downloaded to the
+// archive format and the content type that is served.
+var archiveFormats = []struct {
+	Extension   string
+	Format      git.ArchiveFormat
+	ContentType string
+}{
+	{Extension: ".tar.gz", Format: git.ArchiveTarGz, ContentType: "application/gzip"},
+	{Extension: ".zip", Format: git.ArchiveZip, ContentType: "application/zip"},
+}
+
+// Archive serves a tarball or zip of a tag. Tags are immutable so the response
+// can be cached forever, only public repositories are cached by shared caches.
+func Archive(stor storage.Storage, n namespace.Manager, r repository.Manager, p policy.Manager) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		ns := ctx.Params("namespace")
+		repo := ctx.Params("repo")
+		file := ctx.Params("*")
+		uid := ctx.Locals(middleware.USER_ID).(string)
+
+		perms := r.GetRepoPermissions(ns, repo)
+		nsPerms := n.GetNamespacePermissions(ns)
+		namespace, err := r.GetRepo(ns, repo)
+		if err != nil {
+			ctx.Status(http.StatusNotFound)
+			ctx.Write([]byte(http.StatusText(http.StatusNotFound)))
+			return nil
+		}
+
+		allow := p.Evaluate(
+			policy.RepoRead, policy.PolicyRequest{
+				UserID:               uid,
+				Repo:                 namespace,
+				RepoPermissions:      perms,
+				NamespacePermissions: nsPerms,
+			},
+		)
+
+		log.Info().Bool("allow", allow).Str("namespace", ns).Str("repo", repo).Str("file", file).Str(
+			"user_id",
+			uid,
+		).Msg("User Requested Archive")
+
+		if !allow {
+			ctx.Status(http.StatusForbidden)
+			ctx.Write([]byte(http.StatusText(http.StatusForbidden)))
+			return nil
+		}
+
+		var tag string
+		var opts git.ArchiveOptions
+		contentType, ext := "", ""
+		for _, f := range archiveFormats {
+			if strings.HasSuffix(file, f.Extension) {
+				tag = strings.TrimSuffix(file, f.Extension)
+				opts = git.ArchiveOptions{Format: f.Format, Level: -1}
+				contentType, ext = f.ContentType, f.Extension
+				break
+			}
+		}
+
+		gitRepo := fmt.Sprintf("%s/%s.git", ns, repo)
+		refs, err := stor.ListReferences(gitRepo)
+		if err != nil {
+			log.Error().Err(err).Msg("Cannot list references for archive")
+		}
+		ref, ok := git.ResolveTreeish(refs, "", plumbing.NewTagReferenceName(tag).String())
+		if contentType == "" || tag == "" || !ok {
+			ctx.Status(http.StatusNotFound)
+			ctx.Write([]byte(http.StatusText(http.StatusNotFound)))
+			return nil
+		}
+
+		name := fmt.Sprintf("%s-%s", repo, strings.ReplaceAll(tag, "/", "-"))
+		etag := fmt.Sprintf(`"%s"`, ref.Hash.String())
+		ctx.Set("ETag", etag)
+		ctx.Set("Cache-Control", immutableCache(namespace))
+
+		if ctx.Get("If-None-Match") == etag {
+			ctx.Status(http.StatusNotModified)
+			return nil
+		}
+
+		opts.Prefix = name + "/"
+		buf := &bytes.Buffer{}
+		if err := git.WriteArchive(buf, stor, gitRepo, ref.Hash, opts); err != nil {
+			log.Error().Err(err).Str("ref", ref.Name.String()).Msg("Cannot write archive")
+			ctx.Set("Cache-Control", "no-cache")
+			ctx.Status(http.StatusInternalServerError)
+			ctx.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			return nil
+		}
+
+		ctx.Set("Content-Type", contentType)
+		ctx.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name+ext))
+		ctx.Status(http.StatusOK)
+		ctx.Write(buf.Bytes())
+		return nil
+	}
+}
@@ -3,12 +3,19 @@ package handlers
 import (
 	"bytes"
 	"fmt"
+	"net/http"
+	"strings"
 
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/gofiber/fiber/v2"
 	"github.com/rs/zerolog/log"
 
 	"github.com/Jameslikestea/grm/internal/git"
+	"github.com/Jameslikestea/grm/internal/namespace"
+	"github.com/Jameslikestea/grm/internal/policy"
+	"github.com/Jameslikestea/grm/internal/repository"
+	"github.com/Jameslikestea/grm/internal/server/http/middleware"
+	"github.com/Jameslikestea/grm/internal/server/ssh/receive"
 	"github.com/Jameslikestea/grm/internal/storage"
 )
 
@@ -17,7 +24,72 @@ func Git(ctx *fiber.Ctx) error {
 	ctx.Write([]byte("200 OK\n"))
 	return nil
 }
-func AdvertiseReference(stor storage.Storage) fiber.Handler {
+
+// authorizeGit evaluates the query against the repository addressed by the
+// smart HTTP path. Anonymous users are challenged for credentials so that git
+// clients know to retry with a session token as the password.
+func authorizeGit(ctx *fiber.Ctx, query string, n namespace.Manager, r repository.Manager, p policy.Manager) bool {
+	path := strings.Split(ctx.Params("*1"), "/")
+	if len(path) != 2 {
+		ctx.Status(http.StatusNotFound)
+		ctx.Write([]byte(http.StatusText(http.StatusNotFound)))
+		return false
+	}
+
+	return authorizeRepo(ctx, query, path[0], path[1], n, r, p)
+}
+
+// authorizeRepo evaluates the query against the repository for clients that
+// authenticate with basic auth, such as git and the go command.
+func authorizeRepo(ctx *fiber.Ctx, query string, ns string, name string, n namespace.Manager, r repository.Manager, p policy.Manager) bool {
+	uid := ctx.Locals(middleware.USER_ID).(string)
+
+	repo, _ := r.GetRepo(ns, name)
+	nspc, _ := n.GetNamespace(ns)
+
+	allow := p.Evaluate(
+		query, policy.PolicyRequest{
+			UserID:               uid,
+			RepoPermissions:      r.GetRepoPermissions(ns, name),
+			Repo:                 repo,
+			NamespacePermissions: n.GetNamespacePermissions(ns),
+			Namespace:            nspc,
+		},
+	)
+
+	log.Info().Bool("allow", allow).Str("namespace", ns).Str("repo", name).Str(
+		"user_id",
+		uid,
+	).Str("query", query).Msg("Git HTTP Request")
+
+	if allow {
+		return true
+	}
+
+	if uid == "" {
+		ctx.Set("WWW-Authenticate", `Basic realm="grmpkg"`)
+		ctx.Status(http.StatusUnauthorized)
+		ctx.Write([]byte(http.StatusText(http.StatusUnauthorized)))
+		return false
+	}
+
+	ctx.Status(http.StatusForbidden)
+	ctx.Write([]byte(http.StatusText(http.StatusForbidden)))
+	return false
+}
+
+// defaultTag returns the tag that the repository addressed by the smart HTTP
+// path has configured for HEAD.
+func defaultTag(ctx *fiber.Ctx, r repository.Manager) string {
+	path := strings.Split(ctx.Params("*1"), "/")
+	if len(path) != 2 {
+		return ""
+	}
+	repo, _ := r.GetRepo(path[0], path[1])
+	return repo.DefaultTag
+}
+
+func AdvertiseReference(stor storage.Storage, n namespace.Manager, r repository.Manager, p policy.Manager) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
 		repo := fmt.Sprintf("%s.git", ctx.Params("*1"))
 		service := ctx.Query("service")
@@ -25,17 +97,40 @@ func AdvertiseReference(stor storage.Storage) fiber.Handler {
 		log.Info().Str("service", service).Str("repo", repo).Msg("Advertising references")
 
 		switch service {
-		case "git-upload-pack":
+		case "git-upload-pack", "git-receive-pack":
+			query := policy.RepoRead
+			if service == "git-receive-pack" {
+				query = policy.RepoWrite
+			}
+			if !authorizeGit(ctx, query, n, r, p) {
+				return nil
+			}
 			ctx.Set("Content-Type", fmt.Sprintf("application/x-%s-advertisement", service))
 			ctx.Set("Cache-Control", "no-cache")
+			if service == "git-upload-pack" && git.ProtocolVersion(ctx.Get("Git-Protocol")) == git.ProtocolV2 {
+				ctx.Status(200)
+				git.GenerateCapabilityAdvertisement(ctx)
+				return nil
+			}
 			refs, err := stor.ListReferences(repo)
 			if err != nil {
 				log.Warn().Err(err).Msg("Cannot list refs")
 				ctx.Status(500)
 				ctx.Write([]byte("Internal Server Error"))
+				return nil
+			}
+			var peeled map[plumbing.ReferenceName]plumbing.Hash
+			var head plumbing.ReferenceName
+			if service == "git-upload-pack" {
+				peeled = git.PeelReferences(stor, repo, refs)
+				head = git.DefaultHead(refs, defaultTag(ctx, r))
 			}
 			ctx.Status(200)
-			git.GenerateReferencePack(refs, true, service, ctx)
+			if service == "git-receive-pack" {
+				git.GenerateReceiveReferencePack(refs, true, storage.SupportsAtomic(stor), ctx)
+				return nil
+			}
+			git.GenerateReferencePack(refs, peeled, head, true, service, ctx)
 		default:
 			ctx.Status(500)
 			ctx.Write([]byte("Internal Server Error"))
@@ -44,33 +139,51 @@ func AdvertiseReference(stor storage.Storage) fiber.Handler {
 	}
 }
 
-func UploadPack(stor storage.Storage) fiber.Handler {
+func UploadPack(stor storage.Storage, n namespace.Manager, r repository.Manager, p policy.Manager) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
 		repo := fmt.Sprintf("%s.git", ctx.Params("*1"))
 
 		log.Info().Str("service", "git-upload-pack").Msg("Uploading Pack")
+		if !authorizeGit(ctx, policy.RepoRead, n, r, p) {
+			return nil
+		}
 		body := ctx.Body()
-		wantReader := bytes.NewReader(body)
-		haveReader := bytes.NewReader(body)
 
-		w := git.WantList{}
-		h := git.HaveList{}
+		if git.ProtocolVersion(ctx.Get("Git-Protocol")) == git.ProtocolV2 {
+			cmd, err := git.DecodeCommand(bytes.NewReader(body))
+			if err != nil {
+				log.Warn().Err(err).Msg("Cannot decode protocol v2 command")
+				ctx.Status(http.StatusBadRequest)
+				ctx.Write([]byte(http.StatusText(http.StatusBadRequest)))
+				return nil
+			}
+			ctx.Set("Content-Type", "application/x-git-upload-pack-result")
+			ctx.Set("Cache-Control", "no-cache")
+			git.ServeCommand(cmd, stor, repo, defaultTag(ctx, r), ctx)
+			return nil
+		}
+		ctx.Set("Content-Type", "application/x-git-upload-pack-result")
+		ctx.Set("Cache-Control", "no-cache")
 
-		git.ParseWantList(w, wantReader)
-		git.ParseHaveList(h, haveReader)
+		git.UploadPack(bytes.NewReader(body), ctx, nil, stor, repo, true)
 
-		wants := make([]plumbing.Hash, len(w))
-		i := 0
-		for k := range w {
-			wants[i] = k
-			i++
+		return nil
+	}
+}
+
+func ReceivePack(stor storage.Storage, n namespace.Manager, r repository.Manager, p policy.Manager) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		repo := fmt.Sprintf("%s.git", ctx.Params("*1"))
+
+		log.Info().Str("service", "git-receive-pack").Str("repo", repo).Msg("Receiving Pack")
+		if !authorizeGit(ctx, policy.RepoWrite, n, r, p) {
+			return nil
 		}
-		objs := git.GetNewObjects(stor, repo, wants, h)
-		ctx.Set("Content-Type", "application/x-git-upload-pack-result")
+
+		ctx.Set("Content-Type", "application/x-git-receive-pack-result")
 		ctx.Set("Cache-Control", "no-cache")
 
-		ctx.Write([]byte("0008NAK\n"))
-		git.EncodePackfile(ctx, objs)
+		receive.ReceivePack(bytes.NewReader(ctx.Body()), ctx, nil, repo, stor, p)
 
 		return nil
 	}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/Jameslikestea/grm/internal/config"
+	servicens "github.com/Jameslikestea/grm/internal/namespace/service"
+	"github.com/Jameslikestea/grm/internal/policy"
+	servicers "github.com/Jameslikestea/grm/internal/repository/service"
+	"github.com/Jameslikestea/grm/internal/server/http/middleware"
+	"github.com/Jameslikestea/grm/internal/storage"
+	"github.com/Jameslikestea/grm/internal/storage/memory"
+)
+
+// allowAll is a policy that allows every request.
+type allowAll struct{}
+
+func (allowAll) Evaluate(string, interface{}) bool { return true }
+func (allowAll) Reason(string, interface{}) string { return "" }
+
+// denyAll is a policy that denies every request.
+type denyAll struct{}
+
+func (denyAll) Evaluate(string, interface{}) bool { return false }
+func (denyAll) Reason(string, interface{}) string { return "" }
+
+// gitApp serves the smart HTTP endpoints for a signed in user, with the body
+// limit of the server.
+func gitApp(stor storage.Storage, pol policy.Manager) *fiber.App {
+	app := fiber.New(fiber.Config{BodyLimit: config.GetHTTPBodyLimit()})
+	app.Use(
+		func(ctx *fiber.Ctx) error {
+			ctx.Locals(middleware.USER_ID, "user")
+			return ctx.Next()
+		},
+	)
+	ns, rs := servicens.New(stor), servicers.New(stor)
+	app.Get("/*.git/info/refs", AdvertiseReference(stor, ns, rs, pol))
+	app.Post("/*.git/git-upload-pack", UploadPack(stor, ns, rs, pol))
+	app.Post("/*.git/git-receive-pack", ReceivePack(stor, ns, rs, pol))
+	return app
+}
+
+// commitObjects returns the objects of a commit of the files, which are all at
+// the root of the tree. The commit is the last object.
+func commitObjects(files map[string]string) []storage.Object {
+	encode := func(o interface {
+		Encode(plumbing.EncodedObject) error
+	}, typ plumbing.ObjectType) storage.Object {
+		m := &plumbing.MemoryObject{}
+		m.SetType(typ)
+		o.Encode(m)
+		r, _ := m.Reader()
+		b, _ := ioutil.ReadAll(r)
+		return storage.Object{Hash: m.Hash(), Type: typ, Content: b}
+	}
+
+	objs := []storage.Object{}
+	tree := &object.Tree{}
+	for name, content := range files {
+		blob := storage.Object{
+			Hash:    plumbing.ComputeHash(plumbing.BlobObject, []byte(content)),
+			Type:    plumbing.BlobObject,
+			Content: []byte(content),
+		}
+		objs = append(objs, blob)
+		tree.Entries = append(tree.Entries, object.TreeEntry{Name: name, Mode: filemode.Regular, Hash: blob.Hash})
+	}
+	sort.Slice(tree.Entries, func(i, j int) bool { return tree.Entries[i].Name < tree.Entries[j].Name })
+	root := encode(tree, plumbing.TreeObject)
+
+	sig := object.Signature{Name: "grm", Email: "grm@grmpkg.com", When: time.Unix(0, 0).UTC()}
+	commit := encode(&object.Commit{Author: sig, Committer: sig, Message: "commit", TreeHash: root.Hash}, plumbing.CommitObject)
+	return append(objs, root, commit)
+}
+
+// packObjects writes an undeltified packfile containing the objects.
+func packObjects(objs ...storage.Object) []byte {
+	pack := &bytes.Buffer{}
+	pack.WriteString("PACK")
+	binary.Write(pack, binary.BigEndian, uint32(2))
+	binary.Write(pack, binary.BigEndian, uint32(len(objs)))
+	for _, obj := range objs {
+		size := len(obj.Content)
+		c := byte(obj.Type)<<4 | byte(size&0x0f)
+		for size >>= 4; size > 0; size >>= 7 {
+			pack.WriteByte(c | 0x80)
+			c = byte(size & 0x7f)
+		}
+		pack.WriteByte(c)
+
+		z := zlib.NewWriter(pack)
+		z.Write(obj.Content)
+		z.Close()
+	}
+	sum := sha1.Sum(pack.Bytes())
+	pack.Write(sum[:])
+	return pack.Bytes()
+}
+
+func TestReceivePack_Large(t *testing.T) {
+	config.SetDefaults()
+	config.SetDomain("grmpkg.com")
+	stor := memory.NewMemoryStorage()
+
+	// Random content does not compress, so the pack is larger than the file
+	large := make([]byte, 5<<20)
+	rand.New(rand.NewSource(1)).Read(large)
+	objs := commitObjects(
+		map[string]string{
+			"go.mod":   "module grmpkg.com/ns/repo\n",
+			"data.bin": string(large),
+		},
+	)
+	commit := objs[len(objs)-1].Hash
+
+	body := &bytes.Buffer{}
+	e := pktline.NewEncoder(body)
+	e.Encodef("%s %s refs/tags/v1.0.0\x00report-status\n", plumbing.ZeroHash, commit)
+	e.Flush()
+	body.Write(packObjects(objs...))
+	if body.Len() <= 4<<20 {
+		t.Fatalf("push is only %d bytes", body.Len())
+	}
+
+	resp, err := gitApp(stor, allowAll{}).Test(httptest.NewRequest("POST", "/ns/repo.git/git-receive-pack", body), -1)
+	if err != nil {
+		t.Fatalf("ReceivePack() error = %v", err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 || !strings.Contains(string(b), "ok refs/tags/v1.0.0\n") {
+		t.Errorf("ReceivePack() = %d %q, want the tag created", resp.StatusCode, b)
+	}
+}
+
+func TestUploadPack_V2Authorization(t *testing.T) {
+	stor := memory.NewMemoryStorage()
+	objs := commitObjects(map[string]string{"go.mod": "module grmpkg.com/ns/repo\n"})
+	stor.StoreObjects("ns/repo.git", objs)
+	stor.CreateReferences("ns/repo.git", []storage.Reference{{Name: "refs/tags/v1.0.0", Hash: objs[len(objs)-1].Hash}})
+
+	tests := []struct {
+		name     string
+		pol      policy.Manager
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Allowed",
+			pol:      allowAll{},
+			wantCode: 200,
+			wantBody: " refs/tags/v1.0.0\n",
+		},
+		{
+			name:     "Denied",
+			pol:      denyAll{},
+			wantCode: 403,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				req := httptest.NewRequest("POST", "/ns/repo.git/git-upload-pack", strings.NewReader("0014command=ls-refs\n0000"))
+				req.Header.Set("Git-Protocol", "version=2")
+
+				resp, err := gitApp(stor, tt.pol).Test(req, -1)
+				if err != nil {
+					t.Fatalf("UploadPack() error = %v", err)
+				}
+				b, _ := ioutil.ReadAll(resp.Body)
+				if resp.StatusCode != tt.wantCode || !strings.Contains(string(b), tt.wantBody) {
+					t.Errorf("UploadPack() = %d %q, want %d %q", resp.StatusCode, b, tt.wantCode, tt.wantBody)
+				}
+			},
+		)
+	}
+}
+
+// unlisted is a storage that cannot list references.
+type unlisted struct{ storage.Storage }
+
+func (unlisted) ListReferences(string) ([]storage.Reference, error) {
+	return nil, errors.New("storage unavailable")
+}
+
+func TestAdvertiseReference_ListError(t *testing.T) {
+	stor := unlisted{memory.NewMemoryStorage()}
+
+	for _, service := range []string{"git-upload-pack", "git-receive-pack"} {
+		t.Run(
+			service, func(t *testing.T) {
+				req := httptest.NewRequest("GET", "/ns/repo.git/info/refs?service="+service, nil)
+				resp, err := gitApp(stor, allowAll{}).Test(req, -1)
+				if err != nil {
+					t.Fatalf("AdvertiseReference() error = %v", err)
+				}
+				b, _ := ioutil.ReadAll(resp.Body)
+				if resp.StatusCode != 500 || string(b) != "Internal Server Error" {
+					t.Errorf("AdvertiseReference() = %d %q, want only the error", resp.StatusCode, b)
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/Jameslikestea/grm/internal/config"
+	"github.com/Jameslikestea/grm/internal/namespace"
+	"github.com/Jameslikestea/grm/internal/policy"
+	"github.com/Jameslikestea/grm/internal/repository"
+)
+
+// GoGet answers the ?go-get=1 requests that the go command makes to find the
+// repository of an import path. Any package path below a repository resolves
+// to it, and requests without go-get=1 are passed on to the next route.
+func GoGet(n namespace.Manager, r repository.Manager, p policy.Manager) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		if ctx.Query("go-get") != "1" {
+			return ctx.Next()
+		}
+
+		// The go command reads meta tags even from error pages, so nothing
+		// but a bare status is written when the repository cannot be served
+		parts := strings.Split(strings.Trim(ctx.Path(), "/"), "/")
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			ctx.Status(http.StatusNotFound)
+			ctx.Write([]byte(http.StatusText(http.StatusNotFound)))
+			return nil
+		}
+		ns, name := parts[0], strings.TrimSuffix(parts[1], ".git")
+
+		repo, err := r.GetRepo(ns, name)
+		if err != nil {
+			ctx.Status(http.StatusNotFound)
+			ctx.Write([]byte(http.StatusText(http.StatusNotFound)))
+			return nil
+		}
+		if !authorizeRepo(ctx, policy.RepoRead, ns, name, n, r, p) {
+			return nil
+		}
+
+		if !repo.Public {
+			ctx.Set("Cache-Control", "private, no-cache")
+		}
+		ctx.Set("Content-Type", "text/html; charset=utf-8")
+		ctx.Status(http.StatusOK)
+		ctx.Write([]byte(goGetPage(ns, name, strings.Join(parts, "/"))))
+		return nil
+	}
+}
+
+// goGetPage is the page served to the go command. go-import points at the git
+// repository and go-source at the repository page of GRM. GRM has no pages
+// for directories or files, so those templates are left out with _.
+func goGetPage(ns, name, pkg string) string {
+	domain := config.GetDomain()
+	root := fmt.Sprintf("%s/%s/%s", domain, ns, name)
+	home := "https://" + root
+
+	return fmt.Sprintf(
+		`<!DOCTYPE html>
+<html>
+<head>
+<meta name="go-import" content="%s">
+<meta name="go-source" content="%s">
+</head>
+<body>
+go get %s
+</body>
+</html>
+`,
+		html.EscapeString(fmt.Sprintf("%s git %s.git", root, home)),
+		html.EscapeString(fmt.Sprintf("%s %s _ _", root, home)),
+		html.EscapeString(domain+"/"+pkg),
+	)
+}
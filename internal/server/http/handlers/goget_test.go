@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/Jameslikestea/grm/internal/config"
+	"github.com/Jameslikestea/grm/internal/models"
+	servicens "github.com/Jameslikestea/grm/internal/namespace/service"
+	servicers "github.com/Jameslikestea/grm/internal/repository/service"
+	"github.com/Jameslikestea/grm/internal/server/http/middleware"
+	"github.com/Jameslikestea/grm/internal/storage/memory"
+)
+
+func TestGoGetPage(t *testing.T) {
+	config.SetDomain("grmpkg.com")
+
+	got := goGetPage("acme", "widgets", "acme/widgets/cmd/widget")
+	for _, want := range []string{
+		`<meta name="go-import" content="grmpkg.com/acme/widgets git https://grmpkg.com/acme/widgets.git">`,
+		`<meta name="go-source" content="grmpkg.com/acme/widgets https://grmpkg.com/acme/widgets _ _">`,
+		"go get grmpkg.com/acme/widgets/cmd/widget\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("goGetPage() = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestGoGet(t *testing.T) {
+	config.SetDomain("grmpkg.com")
+	stor := memory.NewMemoryStorage()
+	servicers.New(stor).CreateRepo(models.CreateRepoRequest{Namespace: "acme", Name: "widgets", Public: true})
+
+	app := fiber.New()
+	app.Use(
+		func(ctx *fiber.Ctx) error {
+			ctx.Locals(middleware.USER_ID, "user")
+			return ctx.Next()
+		},
+	)
+	app.Get("/*", GoGet(servicens.New(stor), servicers.New(stor), allowAll{}))
+	app.Get(
+		"/*", func(ctx *fiber.Ctx) error {
+			return ctx.SendString("next")
+		},
+	)
+
+	tests := []struct {
+		name     string
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Package",
+			path:     "/acme/widgets/cmd/widget?go-get=1",
+			wantCode: 200,
+			wantBody: `content="grmpkg.com/acme/widgets git https://grmpkg.com/acme/widgets.git"`,
+		},
+		{
+			name:     "Without go-get",
+			path:     "/acme/widgets/cmd/widget",
+			wantCode: 200,
+			wantBody: "next",
+		},
+		{
+			name:     "Other go-get Value",
+			path:     "/acme/widgets?go-get=0",
+			wantCode: 200,
+			wantBody: "next",
+		},
+		{
+			name:     "Unknown Repository",
+			path:     "/acme/gadgets?go-get=1",
+			wantCode: 404,
+			wantBody: "Not Found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				resp, err := app.Test(httptest.NewRequest("GET", tt.path, nil), -1)
+				if err != nil {
+					t.Fatalf("GoGet() error = %v", err)
+				}
+				b, _ := ioutil.ReadAll(resp.Body)
+				if resp.StatusCode != tt.wantCode || !strings.Contains(string(b), tt.wantBody) {
+					t.Errorf("GoGet() = %d %q, want %d %q", resp.StatusCode, b, tt.wantCode, tt.wantBody)
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/mod/module"
+
+	"github.com/Jameslikestea/grm/internal/gomod"
+	"github.com/Jameslikestea/grm/internal/models"
+	"github.com/Jameslikestea/grm/internal/namespace"
+	"github.com/Jameslikestea/grm/internal/policy"
+	"github.com/Jameslikestea/grm/internal/repository"
+	"github.com/Jameslikestea/grm/internal/storage"
+)
+
+// immutableCache is the Cache-Control of responses that are derived from a
+// tag, only public repositories may be stored by shared caches.
+func immutableCache(repo models.Repo) string {
+	if repo.Public {
+		return "public, max-age=31536000, immutable"
+	}
+	return "private, max-age=31536000, immutable"
+}
+
+// authorizeModule resolves the escaped module path of a GOPROXY request and
+// checks that the user can read the repository hosting it.
+func authorizeModule(
+	ctx *fiber.Ctx,
+	escaped string,
+	n namespace.Manager,
+	r repository.Manager,
+	p policy.Manager,
+) (gomod.Module, models.Repo, bool) {
+	m, err := gomod.ParsePath(escaped)
+	if err != nil {
+		proxyNotFound(ctx, err.Error())
+		return m, models.Repo{}, false
+	}
+
+	repo, err := r.GetRepo(m.Namespace, m.Repo)
+	if err != nil {
+		proxyNotFound(ctx, "unknown module "+m.Path())
+		return m, repo, false
+	}
+
+	return m, repo, authorizeRepo(ctx, policy.RepoRead, m.Namespace, m.Repo, n, r, p)
+}
+
+// proxyNotFound answers with a 404, the go command shows the body to the user
+// and moves on to the next proxy.
+func proxyNotFound(ctx *fiber.Ctx, msg string) {
+	ctx.Set("Cache-Control", "no-cache")
+	ctx.Status(http.StatusNotFound)
+	ctx.Write([]byte("not found: " + msg + "\n"))
+}
+
+// ModuleProxy serves the /@v/ endpoints of the GOPROXY protocol for every
+// module hosted in GRM. Versions are tags so everything except the version
+// list can be cached forever.
+func ModuleProxy(stor storage.Storage, n namespace.Manager, r repository.Manager, p policy.Manager) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		file := ctx.Params("*2")
+
+		m, repo, ok := authorizeModule(ctx, ctx.Params("*1"), n, r, p)
+		if !ok {
+			return nil
+		}
+
+		log.Info().Str("module", m.Path()).Str("file", file).Msg("Module Proxy Request")
+
+		if file == "list" {
+			versions, err := gomod.Versions(stor, m)
+			if err != nil {
+				log.Error().Err(err).Msg("Cannot list module versions")
+				ctx.Status(http.StatusInternalServerError)
+				ctx.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+				return nil
+			}
+
+			list := &bytes.Buffer{}
+			for _, v := range versions {
+				list.WriteString(v.Version + "\n")
+			}
+			ctx.Set("Content-Type", "text/plain; charset=utf-8")
+			ctx.Set("Cache-Control", "no-cache")
+			ctx.Status(http.StatusOK)
+			ctx.Write(list.Bytes())
+			return nil
+		}
+
+		i := strings.LastIndex(file, ".")
+		if i < 0 {
+			proxyNotFound(ctx, file)
+			return nil
+		}
+		version, err := module.UnescapeVersion(file[:i])
+		if err != nil {
+			proxyNotFound(ctx, err.Error())
+			return nil
+		}
+		v, err := gomod.Resolve(stor, m, version)
+		if err != nil {
+			proxyNotFound(ctx, m.Path()+"@"+version+": "+err.Error())
+			return nil
+		}
+
+		buf := &bytes.Buffer{}
+		switch file[i:] {
+		case ".info":
+			var info gomod.Info
+			if info, err = gomod.VersionInfo(stor, m, v); err == nil {
+				ctx.Set("Cache-Control", immutableCache(repo))
+				return ctx.JSON(info)
+			}
+		case ".mod":
+			var mod []byte
+			if mod, err = gomod.GoMod(stor, m, v); err == nil {
+				buf.Write(mod)
+				ctx.Set("Content-Type", "text/plain; charset=utf-8")
+			}
+		case ".zip":
+			if err = gomod.WriteZip(buf, stor, m, v); err == nil {
+				ctx.Set("Content-Type", "application/zip")
+			}
+		default:
+			proxyNotFound(ctx, file)
+			return nil
+		}
+
+		if err != nil {
+			log.Error().Err(err).Str("module", m.Path()).Str("version", version).Msg("Cannot serve module")
+			ctx.Set("Cache-Control", "no-cache")
+			ctx.Status(http.StatusInternalServerError)
+			ctx.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			return nil
+		}
+
+		ctx.Set("Cache-Control", immutableCache(repo))
+		ctx.Status(http.StatusOK)
+		ctx.Write(buf.Bytes())
+		return nil
+	}
+}
+
+// ModuleLatest serves the @latest endpoint of the GOPROXY protocol.
+func ModuleLatest(stor storage.Storage, n namespace.Manager, r repository.Manager, p policy.Manager) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		m, _, ok := authorizeModule(ctx, ctx.Params("*1"), n, r, p)
+		if !ok {
+			return nil
+		}
+
+		versions, err := gomod.Versions(stor, m)
+		if err != nil {
+			log.Error().Err(err).Msg("Cannot list module versions")
+			ctx.Status(http.StatusInternalServerError)
+			ctx.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			return nil
+		}
+		v, ok := gomod.Latest(versions)
+		if !ok {
+			proxyNotFound(ctx, m.Path()+" has no versions")
+			return nil
+		}
+		info, err := gomod.VersionInfo(stor, m, v)
+		if err != nil {
+			log.Error().Err(err).Str("module", m.Path()).Msg("Cannot resolve latest version")
+			ctx.Status(http.StatusInternalServerError)
+			ctx.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			return nil
+		}
+
+		ctx.Set("Cache-Control", "no-cache")
+		return ctx.JSON(info)
+	}
+}
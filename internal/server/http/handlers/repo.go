@@ -3,11 +3,14 @@ package handlers
 import (
 	"fmt"
 	"net/http"
+	"path"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/rs/zerolog/log"
 
 	"github.com/Jameslikestea/grm/internal/config"
+	"github.com/Jameslikestea/grm/internal/gomod"
 	"github.com/Jameslikestea/grm/internal/models"
 	"github.com/Jameslikestea/grm/internal/namespace"
 	"github.com/Jameslikestea/grm/internal/policy"
@@ -110,6 +113,155 @@ func GetRepository(n namespace.Manager, r repository.Manager, p policy.Manager)
 	}
 }
 
+// GetRepositoryTags lists the tags of the repository along with the options
+// they were pushed with. The dir query parameter limits the list to the tags
+// of one module, an empty dir being the module at the root.
+func GetRepositoryTags(n namespace.Manager, r repository.Manager, p policy.Manager) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		ns := ctx.Params("namespace")
+		repo := ctx.Params("repo")
+		uid := ctx.Locals(middleware.USER_ID).(string)
+
+		perms := r.GetRepoPermissions(ns, repo)
+		nsPerms := n.GetNamespacePermissions(ns)
+		namespace, err := r.GetRepo(ns, repo)
+		if err != nil {
+			ctx.Status(http.StatusNotFound)
+			ctx.Write([]byte(http.StatusText(http.StatusNotFound)))
+			return nil
+		}
+
+		allow := p.Evaluate(
+			policy.RepoRead, policy.PolicyRequest{
+				UserID:               uid,
+				Repo:                 namespace,
+				RepoPermissions:      perms,
+				NamespacePermissions: nsPerms,
+			},
+		)
+
+		if !allow {
+			ctx.Status(http.StatusForbidden)
+			ctx.Write([]byte(http.StatusText(http.StatusForbidden)))
+			return nil
+		}
+
+		tags := r.GetTags(ns, repo)
+		if ctx.Context().QueryArgs().Has("dir") {
+			tags = moduleTags(tags, ctx.Query("dir"))
+		}
+		ctx.JSON(tags)
+
+		return nil
+	}
+}
+
+// SetRepositoryHead configures the tag that HEAD points at, so that a plain
+// clone checks it out. An empty tag goes back to the highest semver tag.
+func SetRepositoryHead(n namespace.Manager, r repository.Manager, p policy.Manager) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		ns := ctx.Params("namespace")
+		repo := ctx.Params("repo")
+		tag := ctx.Query("tag")
+		uid := ctx.Locals(middleware.USER_ID).(string)
+
+		perms := r.GetRepoPermissions(ns, repo)
+		nsPerms := n.GetNamespacePermissions(ns)
+		namespace, err := r.GetRepo(ns, repo)
+		if err != nil {
+			ctx.Status(http.StatusNotFound)
+			ctx.Write([]byte(http.StatusText(http.StatusNotFound)))
+			return nil
+		}
+
+		allow := p.Evaluate(
+			policy.RepoAdmin, policy.PolicyRequest{
+				UserID:               uid,
+				Repo:                 namespace,
+				RepoPermissions:      perms,
+				NamespacePermissions: nsPerms,
+			},
+		)
+
+		log.Info().Bool("allow", allow).Str("namespace", ns).Str("repo", repo).Str("tag", tag).Str(
+			"user_id",
+			uid,
+		).Msg("User Set Repo Head")
+
+		if !allow {
+			ctx.Status(http.StatusForbidden)
+			ctx.Write([]byte(http.StatusText(http.StatusForbidden)))
+			return nil
+		}
+
+		if tag != "" && !hasTag(r.GetTags(ns, repo), tag) {
+			ctx.Status(http.StatusBadRequest)
+			ctx.Write([]byte(http.StatusText(http.StatusBadRequest)))
+			return nil
+		}
+
+		if err := r.SetDefaultTag(ns, repo, tag); err != nil {
+			log.Error().Err(err).Msg("Cannot set default tag")
+			ctx.Status(http.StatusInternalServerError)
+			ctx.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			return nil
+		}
+
+		namespace.DefaultTag = tag
+		ctx.JSON(namespace)
+
+		return nil
+	}
+}
+
+func hasTag(tags []models.Tag, name string) bool {
+	for _, tag := range tags {
+		if tag.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// moduleDir finds the module that a path below the repository belongs to, which
+// is the deepest directory that has tags, and otherwise the root module.
+func moduleDir(tags []models.Tag, sub string) string {
+	dirs := map[string]bool{}
+	for _, tag := range tags {
+		dirs[tag.Dir] = true
+	}
+	for d := strings.Trim(sub, "/"); d != "" && d != "."; d = path.Dir(d) {
+		if dirs[d] {
+			return d
+		}
+	}
+	return ""
+}
+
+// moduleDirs lists the directories of the modules that have tags, in the order
+// that GetTags returns them.
+func moduleDirs(tags []models.Tag) []string {
+	dirs := []string{}
+	for _, tag := range tags {
+		if len(dirs) == 0 || dirs[len(dirs)-1] != tag.Dir {
+			dirs = append(dirs, tag.Dir)
+		}
+	}
+	return dirs
+}
+
+func moduleTags(tags []models.Tag, dir string) []models.Tag {
+	filtered := []models.Tag{}
+	for _, tag := range tags {
+		if tag.Dir == dir {
+			filtered = append(filtered, tag)
+		}
+	}
+	return filtered
+}
+
+// FERepository renders the page of the repository, a path below it shows the
+// versions of the nested module that the path belongs to.
 func FERepository(n namespace.Manager, r repository.Manager, p policy.Manager) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
 		ns := ctx.Params("namespace")
@@ -150,12 +302,18 @@ func FERepository(n namespace.Manager, r repository.Manager, p policy.Manager) f
 			return nil
 		}
 
+		dir := moduleDir(tags, ctx.Params("*"))
+		m := gomod.Module{Namespace: namespace.Namespace, Repo: namespace.Name, Dir: dir}
+
 		ctx.Render(
 			"repository", fiber.Map{
 				"Base":      config.GetDomain(),
 				"Name":      namespace.Name,
 				"Namespace": namespace.Namespace,
-				"Tags":      tags,
+				"Dir":       dir,
+				"Module":    m.Path(),
+				"Modules":   moduleDirs(tags),
+				"Tags":      moduleTags(tags, dir),
 				"Anon":      !auth,
 			},
 		)
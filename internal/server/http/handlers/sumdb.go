@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb/tlog"
+
+	"github.com/Jameslikestea/grm/internal/config"
+	"github.com/Jameslikestea/grm/internal/gomod"
+	"github.com/Jameslikestea/grm/internal/repository"
+	"github.com/Jameslikestea/grm/internal/storage"
+	"github.com/Jameslikestea/grm/internal/sumdb"
+)
+
+// sumDBError answers a checksum database request, the go command treats 404
+// as a module that is not in the database.
+func sumDBError(ctx *fiber.Ctx, err error) {
+	ctx.Set("Content-Type", "text/plain; charset=utf-8")
+	ctx.Set("Cache-Control", "no-cache")
+	if errors.Is(err, sumdb.ErrNotFound) {
+		ctx.Status(http.StatusNotFound)
+	} else {
+		log.Error().Err(err).Msg("Checksum database error")
+		ctx.Status(http.StatusInternalServerError)
+	}
+	ctx.Write([]byte(err.Error() + "\n"))
+}
+
+// sumDBName checks that the request is for this checksum database, the go
+// command asks proxies for /sumdb/<name>/supported before using them.
+func sumDBName(ctx *fiber.Ctx, db sumdb.Manager) bool {
+	if ctx.Params("name") != db.Name() {
+		sumDBError(ctx, sumdb.ErrNotFound)
+		return false
+	}
+	return true
+}
+
+// SumDBInfo describes how clients should set GOSUMDB to use the checksum
+// database.
+func SumDBInfo(db sumdb.Manager) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		key, err := db.VerifierKey()
+		if err != nil {
+			sumDBError(ctx, err)
+			return nil
+		}
+		url := fmt.Sprintf("https://%s/sumdb/%s", config.GetDomain(), db.Name())
+		return ctx.JSON(
+			fiber.Map{
+				"name":    db.Name(),
+				"key":     key,
+				"url":     url,
+				"gosumdb": key + " " + url,
+			},
+		)
+	}
+}
+
+func SumDBSupported(db sumdb.Manager) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		if !sumDBName(ctx, db) {
+			return nil
+		}
+		ctx.Status(http.StatusOK)
+		return nil
+	}
+}
+
+func SumDBLatest(db sumdb.Manager) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		if !sumDBName(ctx, db) {
+			return nil
+		}
+		signed, err := db.Signed()
+		if err != nil {
+			sumDBError(ctx, err)
+			return nil
+		}
+		ctx.Set("Content-Type", "text/plain; charset=utf-8")
+		ctx.Set("Cache-Control", "no-cache")
+		ctx.Status(http.StatusOK)
+		ctx.Write(signed)
+		return nil
+	}
+}
+
+// SumDBLookup returns the record of a module version. Versions of public
+// modules are added to the log the first time they are looked up, private
+// modules are never logged so that their names are not published.
+func SumDBLookup(stor storage.Storage, db sumdb.Manager, r repository.Manager) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		if !sumDBName(ctx, db) {
+			return nil
+		}
+
+		mod := ctx.Params("*")
+		i := strings.Index(mod, "@")
+		if i < 0 {
+			ctx.Status(http.StatusBadRequest)
+			ctx.Write([]byte("invalid module@version syntax\n"))
+			return nil
+		}
+		path, err := module.UnescapePath(mod[:i])
+		if err != nil {
+			sumDBError(ctx, sumdb.ErrNotFound)
+			return nil
+		}
+		version, err := module.UnescapeVersion(mod[i+1:])
+		if err != nil {
+			sumDBError(ctx, sumdb.ErrNotFound)
+			return nil
+		}
+
+		m := module.Version{Path: path, Version: version}
+		id, err := db.Lookup(m)
+		if errors.Is(err, sumdb.ErrNotFound) {
+			id, err = addModule(stor, db, r, m)
+		}
+		if err != nil {
+			sumDBError(ctx, err)
+			return nil
+		}
+
+		records, err := db.ReadRecords(id, 1)
+		if err != nil {
+			sumDBError(ctx, err)
+			return nil
+		}
+		msg, err := tlog.FormatRecord(id, records[0])
+		if err != nil {
+			sumDBError(ctx, err)
+			return nil
+		}
+		signed, err := db.Signed()
+		if err != nil {
+			sumDBError(ctx, err)
+			return nil
+		}
+
+		ctx.Set("Content-Type", "text/plain; charset=utf-8")
+		ctx.Set("Cache-Control", "no-cache")
+		ctx.Status(http.StatusOK)
+		ctx.Write(msg)
+		ctx.Write(signed)
+		return nil
+	}
+}
+
+// addModule appends the hashes of a version of a public module to the log.
+func addModule(stor storage.Storage, db sumdb.Manager, r repository.Manager, m module.Version) (int64, error) {
+	mod, err := gomod.ParsePath(m.Path)
+	if err != nil {
+		return 0, sumdb.ErrNotFound
+	}
+	repo, err := r.GetRepo(mod.Namespace, mod.Repo)
+	if err != nil || !repo.Public {
+		return 0, sumdb.ErrNotFound
+	}
+	v, err := gomod.Resolve(stor, mod, m.Version)
+	if err != nil {
+		return 0, sumdb.ErrNotFound
+	}
+
+	// Prefer the hashes that were recorded when the tag was pushed
+	for _, tag := range r.GetTags(mod.Namespace, mod.Repo) {
+		if tag.Name == v.Ref.Name.Short() && tag.Module == m.Path && tag.Version == m.Version && tag.Sum != "" {
+			return db.Add(m, tag.Sum, tag.GoModSum)
+		}
+	}
+
+	zipHash, modHash, err := gomod.Hashes(stor, mod, v)
+	if err != nil {
+		return 0, err
+	}
+	return db.Add(m, zipHash, modHash)
+}
+
+// SumDBTile serves the tiles of the log, tiles never change once they are
+// complete so full tiles can be cached forever.
+func SumDBTile(db sumdb.Manager) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		if !sumDBName(ctx, db) {
+			return nil
+		}
+
+		t, err := tlog.ParseTilePath("tile/" + ctx.Params("*"))
+		if err != nil {
+			ctx.Status(http.StatusBadRequest)
+			ctx.Write([]byte("invalid tile syntax\n"))
+			return nil
+		}
+
+		var data []byte
+		if t.L == -1 {
+			start := t.N << uint(t.H)
+			records, err := db.ReadRecords(start, int64(t.W))
+			if err != nil {
+				sumDBError(ctx, err)
+				return nil
+			}
+			for i, text := range records {
+				msg, err := tlog.FormatRecord(start+int64(i), text)
+				if err != nil {
+					sumDBError(ctx, err)
+					return nil
+				}
+				data = append(data, msg...)
+			}
+			ctx.Set("Content-Type", "text/plain; charset=utf-8")
+		} else {
+			if data, err = db.ReadTileData(t); err != nil {
+				sumDBError(ctx, err)
+				return nil
+			}
+			ctx.Set("Content-Type", "application/octet-stream")
+		}
+
+		if t.W == 1<<uint(t.H) {
+			ctx.Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			ctx.Set("Cache-Control", "no-cache")
+		}
+		ctx.Status(http.StatusOK)
+		ctx.Write(data)
+		return nil
+	}
+}
@@ -27,6 +27,8 @@ import (
 	"github.com/Jameslikestea/grm/internal/storage/cql"
 	"github.com/Jameslikestea/grm/internal/storage/memory"
 	"github.com/Jameslikestea/grm/internal/storage/s3aws"
+	"github.com/Jameslikestea/grm/internal/sumdb"
+	servicesd "github.com/Jameslikestea/grm/internal/sumdb/service"
 )
 
 //go:embed templates
@@ -40,6 +42,7 @@ type Server struct {
 	ns    namespace.Manager
 	rs    repository.Manager
 	ps    pubkey.Manager
+	db    sumdb.Manager
 }
 
 func NewServer() *Server {
@@ -84,6 +87,7 @@ func NewServer() *Server {
 	ns := servicens.New(stor)
 	rs := servicers.New(stor)
 	ps := serviceps.New(stor)
+	db := servicesd.New(stor)
 
 	s := &Server{
 		s: fiber.New(
@@ -91,6 +95,7 @@ func NewServer() *Server {
 				Views:                 engine,
 				AppName:               "grmpkg",
 				DisableStartupMessage: true,
+				BodyLimit:             config.GetHTTPBodyLimit(),
 			},
 		),
 		stor:  stor,
@@ -99,6 +104,7 @@ func NewServer() *Server {
 		ns:    ns,
 		rs:    rs,
 		ps:    ps,
+		db:    db,
 	}
 
 	s.constructMiddleware()
@@ -124,10 +130,24 @@ func (s *Server) constructRoutes() {
 	s.s.Post("/authn/ssh", handlers.HandleAddSSHKey(s.ps))
 
 	s.s.Get("/*.git", handlers.Git)
-	s.s.Get("/*.git/info/refs", handlers.AdvertiseReference(s.stor))
-	s.s.Post("/*.git/git-upload-pack", handlers.UploadPack(s.stor))
+	s.s.Get("/*.git/info/refs", handlers.AdvertiseReference(s.stor, s.ns, s.rs, s.pol))
+	s.s.Post("/*.git/git-upload-pack", handlers.UploadPack(s.stor, s.ns, s.rs, s.pol))
+	s.s.Post("/*.git/git-receive-pack", handlers.ReceivePack(s.stor, s.ns, s.rs, s.pol))
+
+	s.s.Get("/*/@v/*", handlers.ModuleProxy(s.stor, s.ns, s.rs, s.pol))
+	s.s.Get("/*/@latest", handlers.ModuleLatest(s.stor, s.ns, s.rs, s.pol))
+
+	if config.GetSumDBEnabled() {
+		s.s.Get("/sumdb/:name/supported", handlers.SumDBSupported(s.db))
+		s.s.Get("/sumdb/:name/latest", handlers.SumDBLatest(s.db))
+		s.s.Get("/sumdb/:name/lookup/*", handlers.SumDBLookup(s.stor, s.db, s.rs))
+		s.s.Get("/sumdb/:name/tile/*", handlers.SumDBTile(s.db))
+		s.s.Get("/api/sumdb", handlers.SumDBInfo(s.db))
+	}
 
+	s.s.Get("/*", handlers.GoGet(s.ns, s.rs, s.pol))
 	s.s.Get("/:namespace", handlers.FENamespace(s.ns, s.rs, s.pol))
+	s.s.Get("/:namespace/:repo/archive/*", handlers.Archive(s.stor, s.ns, s.rs, s.pol))
 	s.s.Get("/:namespace/:repo", handlers.FERepository(s.ns, s.rs, s.pol))
 	s.s.Get("/:namespace/:repo/*", handlers.FERepository(s.ns, s.rs, s.pol))
 	s.s.Get("/package", handlers.Package)
@@ -136,6 +156,8 @@ func (s *Server) constructRoutes() {
 	s.s.Get("/api/ns/:namespace", handlers.GetNamespace(s.ns, s.pol))
 	s.s.Post("/api/ns/:namespace/r/:repo", handlers.CreateRepository(s.ns, s.rs, s.pol))
 	s.s.Get("/api/ns/:namespace/r/:repo", handlers.GetRepository(s.ns, s.rs, s.pol))
+	s.s.Get("/api/ns/:namespace/r/:repo/tags", handlers.GetRepositoryTags(s.ns, s.rs, s.pol))
+	s.s.Put("/api/ns/:namespace/r/:repo/head", handlers.SetRepositoryHead(s.ns, s.rs, s.pol))
 
 	s.s.Get("/*", handlers.Repository)
 
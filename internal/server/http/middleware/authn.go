@@ -1,6 +1,9 @@
 package middleware
 
 import (
+	"encoding/base64"
+	"strings"
+
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/gofiber/fiber/v2"
 
@@ -20,6 +23,9 @@ func Authn(a authn.Authenticator) fiber.Handler {
 		c.Locals(SESSION_ID, "")
 
 		h := c.Cookies("grm.authentication")
+		if h == "" {
+			h = basicAuthToken(c.Get(fiber.HeaderAuthorization))
+		}
 
 		if h == "" {
 			return c.Next()
@@ -42,3 +48,23 @@ func Authn(a authn.Authenticator) fiber.Handler {
 		return c.Next()
 	}
 }
+
+// basicAuthToken extracts the session token from a basic authorization header,
+// git clients cannot send cookies so the token is supplied as the password.
+func basicAuthToken(header string) string {
+	if !strings.HasPrefix(header, "Basic ") {
+		return ""
+	}
+
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(header, "Basic "))
+	if err != nil {
+		return ""
+	}
+
+	creds := strings.SplitN(string(b), ":", 2)
+	if len(creds) != 2 {
+		return ""
+	}
+
+	return creds[1]
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/rs/zerolog/log"
 	"golang.org/x/crypto/ssh"
 
+	"github.com/Jameslikestea/grm/internal/git"
 	servicens "github.com/Jameslikestea/grm/internal/namespace/service"
 	"github.com/Jameslikestea/grm/internal/policy"
 	serviceps "github.com/Jameslikestea/grm/internal/pubkey/service"
@@ -52,10 +53,25 @@ func cleanRepo(s string) string {
 	return clean
 }
 
+// splitRepo finds the repository that a path addresses. When nested is set,
+// paths below a repository, such as ns/repo/tools for a nested module, are
+// served by the repository itself. target is the name of the repository in
+// storage.
+func splitRepo(p string, nested bool) (target string, repo []string, ok bool) {
+	parts := strings.Split(strings.TrimSuffix(p, ".git"), "/")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" || (len(parts) > 2 && !nested) {
+		return "", nil, false
+	}
+
+	repo = parts[:2]
+	return repo[0] + "/" + repo[1] + ".git", repo, true
+}
+
 func SSHChannelHandler(ch ssh.Channel, in <-chan *ssh.Request, stor storage.Storage, key string) {
 	defer ch.Close()
 
 	var uid = ""
+	var version = git.ProtocolV0
 
 	pol := policy.New()
 	ps := serviceps.New(stor)
@@ -70,17 +86,29 @@ func SSHChannelHandler(ch ssh.Channel, in <-chan *ssh.Request, stor storage.Stor
 	for req := range in {
 		payload := cleanCommand(req.Payload)
 		switch req.Type {
+		case "env":
+			// Clients request protocol v2 by setting GIT_PROTOCOL before exec
+			var env struct {
+				Name  string
+				Value string
+			}
+			if err := ssh.Unmarshal(req.Payload, &env); err == nil && env.Name == "GIT_PROTOCOL" {
+				version = git.ProtocolVersion(env.Value)
+				log.Debug().Str("value", env.Value).Int("version", version).Msg("Git Protocol Requested")
+			}
+			if req.WantReply {
+				req.Reply(true, nil)
+			}
 		case "exec":
 			log.Debug().Str("payload", payload).Msg("Handling Connection")
 
 			cmd := strings.Split(payload, " ")
 			if len(cmd) > 1 {
-				target := cleanRepo(strings.Join(cmd[1:], " "))
-
-				check := strings.TrimSuffix(target, ".git")
-				repo := strings.Split(check, "/")
-
-				if len(repo) != 2 {
+				// Only reads may address a nested module, a push to a path below
+				// a repository must not land in the repository itself
+				nested := cmd[0] == "git-upload-pack" || cmd[0] == "git-upload-archive"
+				target, repo, ok := splitRepo(cleanRepo(strings.Join(cmd[1:], " ")), nested)
+				if !ok {
 					ch.Stderr().Write([]byte("Invalid Repo\n"))
 					ch.SendRequest("exit-status", false, []byte{0, 0, 0, 0})
 					ch.Close()
@@ -110,7 +138,7 @@ func SSHChannelHandler(ch ssh.Channel, in <-chan *ssh.Request, stor storage.Stor
 						ch.Close()
 						break
 					}
-					receive.SSHReceivePack(ch, target, stor)
+					receive.SSHReceivePack(ch, target, stor, pol)
 				case "git-upload-pack":
 					if a := pol.Evaluate(
 						policy.RepoRead, policy.PolicyRequest{
@@ -126,7 +154,23 @@ func SSHChannelHandler(ch ssh.Channel, in <-chan *ssh.Request, stor storage.Stor
 						ch.Close()
 						break
 					}
-					upload.SSHUploadPack(ch, target, stor)
+					upload.SSHUploadPack(ch, target, stor, version, r.DefaultTag)
+				case "git-upload-archive":
+					if a := pol.Evaluate(
+						policy.RepoRead, policy.PolicyRequest{
+							UserID:               uid,
+							RepoPermissions:      permissions,
+							Repo:                 r,
+							NamespacePermissions: nspermissions,
+							Namespace:            n,
+						},
+					); !a {
+						ch.Stderr().Write([]byte("Forbidden\n"))
+						ch.SendRequest("exit-status", false, []byte{0, 0, 0, 0})
+						ch.Close()
+						break
+					}
+					upload.SSHUploadArchive(ch, target, stor, r.DefaultTag)
 				default:
 				}
 			}
@@ -2,142 +2,345 @@ package receive
 
 import (
 	"bytes"
+	"crypto/sha1"
+	"errors"
 	"fmt"
+	"hash"
+	"io"
+	"strings"
 
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/go-git/go-git/v5/plumbing/format/packfile"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/crypto/ssh"
 
 	"github.com/Jameslikestea/grm/internal/git"
+	"github.com/Jameslikestea/grm/internal/gomod"
+	"github.com/Jameslikestea/grm/internal/models"
+	"github.com/Jameslikestea/grm/internal/policy"
+	servicers "github.com/Jameslikestea/grm/internal/repository/service"
 	"github.com/Jameslikestea/grm/internal/storage"
 )
 
-func SSHReceivePack(ch ssh.Channel, repo string, stor storage.Storage) {
+func SSHReceivePack(ch ssh.Channel, repo string, stor storage.Storage, pol policy.Manager) {
 	// Stage 1 is to receive information from the client.
 	advertiseRefs(ch, stor, repo)
-	refs, err := git.DecodeRefs(ch)
+	ReceivePack(ch, ch, ch.Stderr(), repo, stor, pol)
+}
+
+// ReceivePack reads the reference update requests and packfile sent by the
+// client from r and writes the report back to w. Human readable messages are
+// multiplexed with the report when the client selected a sideband, otherwise
+// they are written to progress. It does not advertise references, so that it
+// can be shared by transports that split the advertisement into its own
+// request. Tag names are checked against the repos.valid_tag policy.
+func ReceivePack(r io.Reader, w io.Writer, progress io.Writer, repo string, stor storage.Storage, pol policy.Manager) {
+	// The capabilities are known once the first command was read, so a
+	// request that is cut short later on still gets the error on the sideband
+	req, err := git.DecodeReceiveRequest(r)
 	if err != nil {
-		ch.Stderr().Write([]byte("Cannot decode references\n"))
+		log.Warn().Err(err).Msg("Cannot decode references")
+		sb := git.NewSideband(w, progress, req.Capabilities)
+		sb.Fatal("Cannot decode references\n")
+		sb.Close()
 		return
 	}
-	objs := decodePack(ch, stor, repo)
+	refs := req.Refs()
+	atomic := req.Has("atomic")
+	sb := git.NewSideband(w, progress, req.Capabilities)
+
+	// Objects stay in quarantine until at least one reference is accepted
+	q := storage.NewQuarantine(stor, repo)
+	defer q.Discard()
+
+	// Git sends no packfile when every command is a delete
+	if !req.DeleteOnly() {
+		count, err := decodePack(r, q)
+		if err != nil {
+			log.Warn().Err(err).Msg("Cannot unpack packfile")
+			report := git.Report{}
+			for _, ref := range refs {
+				report[ref.Name] = git.ReportItem{
+					Ok:     false,
+					Reason: "unpacker error",
+				}
+			}
+			writeReport(sb, req, report, err)
+			sb.Fatal("unpack failed: %s\n", err)
+			sb.Close()
+			return
+		}
+		sb.Progress("Receiving objects: %d, done.\n", count)
+	}
 
 	report := git.Report{}
+	for _, u := range req.Updates {
+		item := git.ReportItem{Ok: true, Old: u.Old, New: u.New}
+		switch {
+		case atomic && !storage.SupportsAtomic(stor):
+			// Clients only ask for it when it was advertised
+			item = git.ReportItem{Ok: false, Reason: "atomic pushes are not supported"}
+		case !u.Name.IsTag():
+			item = git.ReportItem{Ok: false, Reason: "GRM only accepts tags"}
+		case u.Delete():
+			item = git.ReportItem{Ok: false, Reason: "GRM tags cannot be deleted"}
+		case !u.Create():
+			item = git.ReportItem{Ok: false, Reason: "GRM tags are immutable"}
+		default:
+			if err := validateTagName(u.Name, repo, stor, pol); err != nil {
+				log.Warn().Err(err).Str("ref", u.Name.String()).Msg("Tag name rejected by policy")
+				item = git.ReportItem{Ok: false, Reason: err.Error()}
+			}
+		}
+		report[u.Name] = item
+	}
+
+	validateTags(report, repo, stor)
+
 	validRefs := []storage.Reference{}
 	for _, ref := range refs {
-		if ref.Name.IsTag() {
-			validRefs = append(validRefs, ref)
+		if !report[ref.Name].Ok {
+			continue
 		}
-		report[ref.Name] = git.ReportItem{
-			Ok:     ref.Name.IsTag(),
-			Reason: "GRM only accepts tags",
+		sb.Progress("Validating tag %s\n", ref.Name.Short())
+		if err := validateRef(ref, q); err != nil {
+			log.Warn().Err(err).Str("ref", ref.Name.String()).Msg("Reference is not connected")
+			report[ref.Name] = git.ReportItem{
+				Ok:     false,
+				Reason: err.Error(),
+			}
+			continue
+		}
+		if err := validateModule(ref, repo, q.View(), sb); err != nil {
+			log.Warn().Err(err).Str("ref", ref.Name.String()).Msg("Reference is not a valid module version")
+			report[ref.Name] = git.ReportItem{
+				Ok:     false,
+				Reason: err.Error(),
+			}
+			continue
 		}
+		validRefs = append(validRefs, ref)
 	}
 
-	validateTags(report, repo, stor)
-
-	if len(validRefs) > 0 {
+	// An atomic push is all or nothing, so one rejected reference rejects the
+	// rest of them as well
+	if atomic && len(validRefs) != len(refs) {
+		rejectAtomic(report, validRefs)
+		validRefs = nil
+	}
 
-		mapper := map[plumbing.Hash]storage.Object{}
-		for _, obj := range objs {
-			mapper[obj.Hash] = obj
+	conflicts, err := q.Promote(validRefs, atomic)
+	if err != nil {
+		log.Error().Err(err).Msg("Cannot promote quarantined objects")
+		for _, ref := range validRefs {
+			report[ref.Name] = git.ReportItem{
+				Ok:     false,
+				Reason: "cannot store objects",
+			}
+		}
+	}
+	// Another push may have created the same tag since it was validated
+	if atomic && len(conflicts) > 0 {
+		rejectAtomic(report, conflicts)
+		validateTags(report, repo, stor)
+	} else {
+		for _, ref := range conflicts {
+			report[ref.Name] = git.ReportItem{
+				Ok:     false,
+				Reason: "GRM tags are immutable",
+			}
 		}
+	}
 
-		for _, ref := range validRefs {
-			ch.Stderr().Write([]byte(fmt.Sprintf("Server Validating: %s\n", ref.Name)))
-			// if !validateRef(ref, mapper) {
-			// 	report[ref.Name] = git.ReportItem{
-			// 		Ok:     false,
-			// 		Reason: "Could not validate object tree",
-			// 	}
-			// }
+	recordTags(report, req, repo, stor)
+
+	writeReport(sb, req, report, nil)
+	sb.Close()
+}
+
+// writeReport writes the report in the newest format that the client selected.
+func writeReport(w io.Writer, req git.ReceiveRequest, report git.Report, unpackErr error) {
+	if req.Has("report-status-v2") {
+		report.WriteStatusV2(w, unpackErr)
+		return
+	}
+	report.WriteStatus(w, unpackErr)
+}
+
+// recordTags stores the metadata of every tag that was created by the push,
+// so that the push options can be shown alongside the tag.
+func recordTags(report git.Report, req git.ReceiveRequest, repo string, stor storage.Storage) {
+	path := strings.Split(strings.TrimSuffix(repo, ".git"), "/")
+	if len(path) != 2 {
+		log.Warn().Str("repo", repo).Msg("Cannot record tags of an invalid repo")
+		return
+	}
+
+	rs := servicers.New(stor)
+	for _, u := range req.Updates {
+		if !report[u.Name].Ok {
+			continue
+		}
+		tag := models.Tag{
+			Name:        u.Name.Short(),
+			Hash:        u.New.String(),
+			PushOptions: req.Options,
 		}
+		recordSums(&tag, path[0], path[1], storage.Reference{Name: u.Name, Hash: u.New}, stor)
+		rs.StoreTag(path[0], path[1], tag)
+	}
+}
 
-		stor.StoreObjects(repo, objs)
-		stor.StoreReferences(repo, validRefs)
+// validateTagName evaluates the tag naming policy for a new tag, the policy
+// explains a rejection through repos.tag_reason.
+func validateTagName(name plumbing.ReferenceName, repo string, stor storage.Storage, pol policy.Manager) error {
+	req := policy.PolicyRequest{Tag: name.Short()}
+	path := strings.Split(strings.TrimSuffix(repo, ".git"), "/")
+	if len(path) == 2 {
+		req.Repo, _ = servicers.New(stor).GetRepo(path[0], path[1])
 	}
 
-	report.Write(ch)
+	if pol.Evaluate(policy.RepoValidTag, req) {
+		return nil
+	}
+	if reason := pol.Reason(policy.RepoTagReason, req); reason != "" {
+		return errors.New(reason)
+	}
+	return fmt.Errorf("tag %s is not allowed", name.Short())
 }
 
-func validateRef(reference storage.Reference, objs map[plumbing.Hash]storage.Object) bool {
-	obj, ok := objs[reference.Hash]
+// validateModule checks that a tag which is a module version can be used by
+// the go command, stor must be able to see the quarantined objects. Files that
+// will be left out of the module zip are reported to the client as progress.
+func validateModule(ref storage.Reference, repo string, stor storage.Storage, sb *git.Sideband) error {
+	path := strings.Split(strings.TrimSuffix(repo, ".git"), "/")
+	if len(path) != 2 {
+		return nil
+	}
 
+	m, v, ok := gomod.TagVersion(stor, path[0], path[1], ref)
 	if !ok {
-		return false
+		return nil
+	}
+	if err := gomod.CheckGoMod(stor, m, v); err != nil {
+		return err
 	}
 
-	return validateObj(obj, objs)
+	cf, err := gomod.CheckFiles(stor, m, v)
+	for _, f := range cf.Omitted {
+		sb.Progress("Omitting %s from %s@%s: %v\n", f.Path, m.Path(), v.Version, f.Err)
+	}
+	return err
 }
 
-func validateObj(obj storage.Object, objs map[plumbing.Hash]storage.Object) bool {
-	switch obj.Type {
-	case plumbing.CommitObject:
-		o := &plumbing.MemoryObject{}
-		o.SetType(plumbing.CommitObject)
-		o.SetSize(int64(len(obj.Content)))
-		o.Write(obj.Content)
-		commit := object.Commit{}
-		err := commit.Decode(o)
+// recordSums adds the go.sum hashes of the module version that the tag
+// provides, so that they can later be compared with what clients download.
+func recordSums(tag *models.Tag, ns, repo string, ref storage.Reference, stor storage.Storage) {
+	m, v, ok := gomod.TagVersion(stor, ns, repo, ref)
+	if !ok {
+		return
+	}
+
+	sum, modSum, err := gomod.Hashes(stor, m, v)
+	if err != nil {
+		log.Warn().Err(err).Str("module", m.Path()).Str("version", v.Version).Msg("Cannot hash module")
+		return
+	}
+	tag.Module = m.Path()
+	tag.Version = v.Version
+	tag.Sum = sum
+	tag.GoModSum = modSum
+}
+
+// rejectAtomic marks the references that were otherwise accepted as failed
+// because of another reference in the same atomic push.
+func rejectAtomic(report git.Report, refs []storage.Reference) {
+	for _, ref := range refs {
+		report[ref.Name] = git.ReportItem{
+			Ok:     false,
+			Reason: "atomic push failed",
+		}
+	}
+}
+
+// validateRef checks that every object reachable from the reference is either
+// in quarantine or already stored. Stored objects were checked when they were
+// pushed, so the walk does not continue past them.
+func validateRef(reference storage.Reference, q *storage.Quarantine) error {
+	seen := map[plumbing.Hash]bool{}
+	queue := []plumbing.Hash{reference.Hash}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+
+		obj, err := q.GetObject(hash)
 		if err != nil {
-			return false
+			return fmt.Errorf("missing object %s", hash.String())
 		}
-		log.Debug().Msg("validating commit tree")
-		tree, ok := objs[commit.TreeHash]
-		if !ok {
-			return false
+		if !q.Has(hash) {
+			continue
 		}
-		if !validateObj(tree, objs) {
-			return false
+
+		links, err := validateObj(obj)
+		if err != nil {
+			return err
 		}
+		queue = append(queue, links...)
+	}
 
-		log.Debug().Int("parents", len(commit.ParentHashes)).Msg("validating commit parents")
-		for _, parentHash := range commit.ParentHashes {
-			parent, ok := objs[parentHash]
-			if !ok {
-				return false
-			}
-			if !validateObj(parent, objs) {
-				return false
-			}
+	return nil
+}
+
+// validateObj decodes the object and returns the objects that it references.
+func validateObj(obj storage.Object) ([]plumbing.Hash, error) {
+	o := &plumbing.MemoryObject{}
+	o.SetType(obj.Type)
+	o.SetSize(int64(len(obj.Content)))
+	o.Write(obj.Content)
+
+	switch obj.Type {
+	case plumbing.CommitObject:
+		commit := object.Commit{}
+		if err := commit.Decode(o); err != nil {
+			return nil, fmt.Errorf("invalid commit %s", obj.Hash.String())
 		}
+		log.Debug().Int("parents", len(commit.ParentHashes)).Msg("validating commit")
+		return append([]plumbing.Hash{commit.TreeHash}, commit.ParentHashes...), nil
 
-		return true
 	case plumbing.TreeObject:
-		o := &plumbing.MemoryObject{}
-		o.SetType(plumbing.TreeObject)
-		o.SetSize(int64(len(obj.Content)))
-		o.Write(obj.Content)
 		tree := object.Tree{}
-		err := tree.Decode(o)
-		if err != nil {
-			return false
+		if err := tree.Decode(o); err != nil {
+			return nil, fmt.Errorf("invalid tree %s", obj.Hash.String())
 		}
-
 		log.Debug().Int("entries", len(tree.Entries)).Msg("validating tree entries")
+		links := []plumbing.Hash{}
 		for _, entry := range tree.Entries {
-			ob, ok := objs[entry.Hash]
-			if !ok {
-				return false
-			}
-			if !validateObj(ob, objs) {
-				return false
+			// Submodules point at commits in other repositories
+			if entry.Mode == filemode.Submodule {
+				continue
 			}
+			links = append(links, entry.Hash)
 		}
-		return true
-	case plumbing.BlobObject:
-		log.Debug().Msg("validating blob")
-		return true
+		return links, nil
+
 	case plumbing.TagObject:
-		return true
-	case plumbing.OFSDeltaObject:
-		return true
-	case plumbing.REFDeltaObject:
-		return true
+		tag := object.Tag{}
+		if err := tag.Decode(o); err != nil {
+			return nil, fmt.Errorf("invalid tag %s", obj.Hash.String())
+		}
+		return []plumbing.Hash{tag.Target}, nil
+
+	case plumbing.BlobObject:
+		return nil, nil
 	}
-	return true
+
+	return nil, fmt.Errorf("invalid object type %s", obj.Type.String())
 }
 
 func hunt(hash plumbing.Hash, cache, nObjs map[plumbing.Hash]storage.Object, seen map[plumbing.Hash]bool) {
@@ -195,49 +398,104 @@ func advertiseRefs(ch ssh.Channel, stor storage.Storage, repo string) {
 	if err != nil {
 		log.Error().Err(err).Msg("Cannot list references for advertise pack")
 	}
-	git.GenerateReferencePack(refs, false, "git-receive-pack", ch)
+	git.GenerateReceiveReferencePack(refs, false, storage.SupportsAtomic(stor), ch)
 }
 
-func decodePack(ch ssh.Channel, stor storage.Storage, repo string) []storage.Object {
-	objs := []storage.Object{}
-
-	bufs := map[int64][]byte{}
-	hahs := map[plumbing.Hash][]byte{}
+// pendingDelta is a delta whose base has not been resolved yet. Thin packs may
+// refer to a base that appears later in the pack, or to one that only exists
+// in the repository. Deltas above the spill threshold wait in the quarantine
+// file rather than in memory.
+type pendingDelta struct {
+	offset     int64
+	base       plumbing.Hash
+	baseOffset int64
+	delta      []byte
+	spill      *storage.Spill
+}
 
-	btyp := map[int64]plumbing.ObjectType{}
-	htyp := map[plumbing.Hash]plumbing.ObjectType{}
+// newPendingDelta keeps a copy of the delta until its base is resolved.
+func newPendingDelta(q *storage.Quarantine, p pendingDelta, delta []byte) (pendingDelta, error) {
+	if !q.Spills(int64(len(delta))) {
+		p.delta = append([]byte{}, delta...)
+		return p, nil
+	}
 
-	obs, err := stor.ListObjects(repo)
+	s, err := q.WriteSpill(
+		func(w io.Writer) error {
+			_, err := w.Write(delta)
+			return err
+		},
+	)
 	if err != nil {
-		log.Error().Err(err).Msg("Cannot get objects from DB")
+		return p, fmt.Errorf("cannot spill delta at offset %d: %s", p.offset, err)
 	}
-	for _, obj := range obs {
-		hahs[obj.Hash] = obj.Content
-		htyp[obj.Hash] = obj.Type
+	p.spill = &s
+	return p, nil
+}
+
+// load returns the delta, reading it back from the quarantine file if it was
+// spilled.
+func (p pendingDelta) load(q *storage.Quarantine) ([]byte, error) {
+	if p.spill == nil {
+		return p.delta, nil
 	}
+	return q.ReadSpill(*p.spill)
+}
 
-	reader := packfile.NewScanner(ch)
+// decodePack streams the packfile sent by the client into the quarantine.
+// Every object is resolved and hashed, and the checksum in the pack trailer is
+// verified. Delta bases are looked up on demand, first in the quarantine and
+// then in the repository, so only the index of the pack is held in memory.
+// Objects and pending deltas above the spill threshold are written straight to
+// the quarantine file instead of being buffered.
+func decodePack(r io.Reader, q *storage.Quarantine) (int, error) {
+	offsets := map[int64]plumbing.Hash{}
+	pending := []pendingDelta{}
+	unresolved := map[int64]bool{}
+
+	checksum := newPackChecksum()
+	reader := packfile.NewScanner(io.TeeReader(r, checksum))
 
 	v, o, err := reader.Header()
 	if err != nil {
-		log.Error().Err(err).Msg("Couldn't decode packfile")
+		return 0, fmt.Errorf("invalid pack header: %s", err)
 	}
 	log.Info().Uint32("version", v).Uint32("objects", o).Msg("Receiving Packfiles")
 
+	b := &bytes.Buffer{}
 	for i := uint32(0); i < o; i++ {
-		b := bytes.NewBuffer([]byte(""))
-		header, _ := reader.NextObjectHeader()
+		header, err := reader.NextObjectHeader()
+		if err != nil {
+			return 0, fmt.Errorf("invalid object header: %s", err)
+		}
+
+		switch header.Type {
+		case plumbing.CommitObject, plumbing.TreeObject, plumbing.BlobObject, plumbing.TagObject:
+			if q.Spills(header.Length) {
+				hash, err := spillObject(reader, header, q)
+				if err != nil {
+					return 0, err
+				}
+				offsets[header.Offset] = hash
+				continue
+			}
+		}
+
+		b.Reset()
+		n, _, err := reader.NextObject(b)
+		if err != nil {
+			return 0, fmt.Errorf("invalid object at offset %d: %s", header.Offset, err)
+		}
+		if n != header.Length {
+			return 0, fmt.Errorf("object at offset %d has the wrong size", header.Offset)
+		}
 
-		reader.NextObject(b)
+		var obj storage.Object
 
 		switch header.Type {
 		case plumbing.CommitObject, plumbing.TreeObject, plumbing.BlobObject, plumbing.TagObject:
-			bts := b.Bytes()
-			bufs[header.Offset] = bts
-			btyp[header.Offset] = header.Type
-			hash := plumbing.ComputeHash(header.Type, bts)
-			hahs[hash] = bts
-			htyp[hash] = header.Type
+			obj.Type = header.Type
+			obj.Content = append([]byte{}, b.Bytes()...)
 
 			log.Trace().Int64("offset", header.Offset).Int("length", b.Len()).Str(
 				"type",
@@ -250,67 +508,190 @@ func decodePack(ch ssh.Channel, stor storage.Storage, repo string) []storage.Obj
 				header.OffsetReference,
 			).Msg("received ofs delta")
 
-			src, ok := bufs[header.OffsetReference]
+			hash, ok := offsets[header.OffsetReference]
+			if !ok {
+				if !unresolved[header.OffsetReference] {
+					return 0, fmt.Errorf("missing delta base at offset %d", header.OffsetReference)
+				}
+				unresolved[header.Offset] = true
+				p, err := newPendingDelta(q, pendingDelta{offset: header.Offset, baseOffset: header.OffsetReference}, b.Bytes())
+				if err != nil {
+					return 0, err
+				}
+				pending = append(pending, p)
+				continue
+			}
+			if obj, ok, err = applyDelta(q, hash, b.Bytes()); err != nil {
+				return 0, fmt.Errorf("cannot apply delta at offset %d: %s", header.Offset, err)
+			}
 			if !ok {
-				log.Error().Msg("Cannot find offset in pack")
+				return 0, fmt.Errorf("missing delta base at offset %d", header.OffsetReference)
 			}
-			t := btyp[header.OffsetReference]
 
-			s, d := src, b.Bytes()
+		case plumbing.REFDeltaObject:
+			log.Trace().Str("reference", header.Reference.String()).Msg("received ref delta")
 
-			bts, err := packfile.PatchDelta(s, d)
-			if err != nil {
-				log.Error().Err(err).Bytes("src", s).Bytes("delta", d).Msg("Cannot apply delta")
+			var ok bool
+			if obj, ok, err = applyDelta(q, header.Reference, b.Bytes()); err != nil {
+				return 0, fmt.Errorf("cannot apply delta at offset %d: %s", header.Offset, err)
+			}
+			if !ok {
+				unresolved[header.Offset] = true
+				p, err := newPendingDelta(q, pendingDelta{offset: header.Offset, base: header.Reference}, b.Bytes())
+				if err != nil {
+					return 0, err
+				}
+				pending = append(pending, p)
+				continue
 			}
 
-			m := &plumbing.MemoryObject{}
-			m.Write(bts)
-			m.SetType(t)
-			hash := m.Hash()
+		default:
+			return 0, fmt.Errorf("invalid object type at offset %d", header.Offset)
+		}
 
-			bufs[header.Offset] = bts
-			btyp[header.Offset] = t
-			hahs[hash] = bts
-			htyp[hash] = t
+		obj.Hash = plumbing.ComputeHash(obj.Type, obj.Content)
+		offsets[header.Offset] = obj.Hash
+		if err := q.Add(obj); err != nil {
+			return 0, fmt.Errorf("cannot quarantine object: %s", err)
+		}
+	}
 
-		case plumbing.REFDeltaObject:
-			log.Trace().Str("reference", header.Reference.String()).Msg("received ref delta")
+	trailer, err := reader.Checksum()
+	if err != nil {
+		return 0, fmt.Errorf("missing pack checksum: %s", err)
+	}
+	if trailer != checksum.Sum() {
+		return 0, errors.New("pack checksum mismatch")
+	}
 
-			src, ok := hahs[header.Reference]
-			if !ok {
-				log.Error().Msg("Cannot find offset in pack")
+	if err := resolvePending(q, pending, offsets); err != nil {
+		return 0, err
+	}
+
+	return int(o), nil
+}
+
+// spillObject streams an object that is too large to buffer to the quarantine
+// file, hashing it on the way.
+func spillObject(reader *packfile.Scanner, header *packfile.ObjectHeader, q *storage.Quarantine) (plumbing.Hash, error) {
+	h := plumbing.NewHasher(header.Type, header.Length)
+	s, err := q.WriteSpill(
+		func(w io.Writer) error {
+			_, _, err := reader.NextObject(io.MultiWriter(w, h))
+			return err
+		},
+	)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("invalid object at offset %d: %s", header.Offset, err)
+	}
+	if s.Len() != header.Length {
+		return plumbing.ZeroHash, fmt.Errorf("object at offset %d has the wrong size", header.Offset)
+	}
+
+	hash := h.Sum()
+	if err := q.AddSpill(hash, header.Type, s); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("cannot quarantine object: %s", err)
+	}
+
+	log.Trace().Int64("offset", header.Offset).Int64("length", header.Length).Str(
+		"type",
+		header.Type.String(),
+	).Msg("received object straight to disk")
+	return hash, nil
+}
+
+// resolvePending completes a thin pack once the whole pack has been read.
+// Deltas are retried until none can be resolved, any that remain refer to a
+// base that is neither in the pack nor in the repository.
+func resolvePending(q *storage.Quarantine, pending []pendingDelta, offsets map[int64]plumbing.Hash) error {
+	for len(pending) > 0 {
+		remaining := []pendingDelta{}
+		for _, p := range pending {
+			base := p.base
+			if base.IsZero() {
+				hash, ok := offsets[p.baseOffset]
+				if !ok {
+					remaining = append(remaining, p)
+					continue
+				}
+				base = hash
 			}
-			t := htyp[header.Reference]
-			s, d := src, b.Bytes()
 
-			bts, err := packfile.PatchDelta(s, d)
+			delta, err := p.load(q)
 			if err != nil {
-				log.Error().Err(err).Bytes("src", s).Bytes("delta", d).Msg("Cannot apply delta")
+				return fmt.Errorf("cannot read delta at offset %d: %s", p.offset, err)
 			}
+			obj, ok, err := applyDelta(q, base, delta)
+			if err != nil {
+				return fmt.Errorf("cannot apply delta at offset %d: %s", p.offset, err)
+			}
+			if !ok {
+				remaining = append(remaining, p)
+				continue
+			}
+			obj.Hash = plumbing.ComputeHash(obj.Type, obj.Content)
+			offsets[p.offset] = obj.Hash
+			if err := q.Add(obj); err != nil {
+				return fmt.Errorf("cannot quarantine object: %s", err)
+			}
+			log.Trace().Str("base", base.String()).Int64("offset", p.offset).Msg("Resolved thin pack delta")
+		}
 
-			m := &plumbing.MemoryObject{}
-			m.Write(bts)
-			m.SetType(t)
-			hash := m.Hash()
-
-			bufs[header.Offset] = bts
-			btyp[header.Offset] = t
-			hahs[hash] = bts
-			htyp[hash] = t
+		if len(remaining) == len(pending) {
+			for _, p := range remaining {
+				if !p.base.IsZero() {
+					return fmt.Errorf("missing delta base %s", p.base.String())
+				}
+			}
+			return fmt.Errorf("missing delta base at offset %d", remaining[0].baseOffset)
 		}
+		pending = remaining
+	}
 
-		b.Reset()
+	return nil
+}
+
+// applyDelta resolves a delta against its base, which is looked up by hash. ok
+// is false when the base is in neither the quarantine nor the repository, any
+// other error reading the base is returned as it is.
+func applyDelta(q *storage.Quarantine, base plumbing.Hash, delta []byte) (storage.Object, bool, error) {
+	src, err := q.GetObject(base)
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		return storage.Object{}, false, nil
+	}
+	if err != nil {
+		return storage.Object{}, false, err
 	}
 
-	for hash, content := range hahs {
-		objs = append(
-			objs, storage.Object{
-				Hash:    hash,
-				Type:    htyp[hash],
-				Content: content,
-			},
-		)
+	content, err := packfile.PatchDelta(src.Content, delta)
+	if err != nil {
+		return storage.Object{}, true, err
+	}
+	return storage.Object{Type: src.Type, Content: content}, true, nil
+}
+
+// packChecksum hashes everything written to it apart from the final twenty
+// bytes, which hold the checksum of the rest of the pack.
+type packChecksum struct {
+	h    hash.Hash
+	tail []byte
+}
+
+func newPackChecksum() *packChecksum {
+	return &packChecksum{h: sha1.New()}
+}
+
+func (p *packChecksum) Write(b []byte) (int, error) {
+	p.tail = append(p.tail, b...)
+	if n := len(p.tail) - sha1.Size; n > 0 {
+		p.h.Write(p.tail[:n])
+		p.tail = append(p.tail[:0], p.tail[n:]...)
 	}
+	return len(b), nil
+}
 
-	return objs
+func (p *packChecksum) Sum() plumbing.Hash {
+	var sum plumbing.Hash
+	copy(sum[:], p.h.Sum(nil))
+	return sum
 }
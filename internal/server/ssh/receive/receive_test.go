@@ -0,0 +1,536 @@
+package receive
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/Jameslikestea/grm/internal/config"
+	"github.com/Jameslikestea/grm/internal/git"
+	"github.com/Jameslikestea/grm/internal/models"
+	"github.com/Jameslikestea/grm/internal/storage"
+	"github.com/Jameslikestea/grm/internal/storage/memory"
+)
+
+// packObjects writes a packfile containing the objects. REF_DELTA entries use
+// Hash for the base and Content for the delta.
+func packObjects(objs ...storage.Object) []byte {
+	pack := &bytes.Buffer{}
+	pack.WriteString("PACK")
+	binary.Write(pack, binary.BigEndian, uint32(2))
+	binary.Write(pack, binary.BigEndian, uint32(len(objs)))
+	for _, obj := range objs {
+		size := len(obj.Content)
+		c := byte(obj.Type)<<4 | byte(size&0x0f)
+		for size >>= 4; size > 0; size >>= 7 {
+			pack.WriteByte(c | 0x80)
+			c = byte(size & 0x7f)
+		}
+		pack.WriteByte(c)
+		if obj.Type == plumbing.REFDeltaObject {
+			pack.Write(obj.Hash[:])
+		}
+
+		z := zlib.NewWriter(pack)
+		z.Write(obj.Content)
+		z.Close()
+	}
+	sum := sha1.Sum(pack.Bytes())
+	pack.Write(sum[:])
+	return pack.Bytes()
+}
+
+// commitObjects returns the objects of a commit of the files, which are all at
+// the root of the tree. The commit is the last object.
+func commitObjects(files map[string]string) []storage.Object {
+	encode := func(o interface {
+		Encode(plumbing.EncodedObject) error
+	}, typ plumbing.ObjectType) storage.Object {
+		m := &plumbing.MemoryObject{}
+		m.SetType(typ)
+		o.Encode(m)
+		r, _ := m.Reader()
+		b, _ := ioutil.ReadAll(r)
+		return storage.Object{Hash: m.Hash(), Type: typ, Content: b}
+	}
+
+	objs := []storage.Object{}
+	tree := &object.Tree{}
+	for name, content := range files {
+		blob := storage.Object{
+			Hash:    plumbing.ComputeHash(plumbing.BlobObject, []byte(content)),
+			Type:    plumbing.BlobObject,
+			Content: []byte(content),
+		}
+		objs = append(objs, blob)
+		tree.Entries = append(tree.Entries, object.TreeEntry{Name: name, Mode: filemode.Regular, Hash: blob.Hash})
+	}
+	sort.Slice(tree.Entries, func(i, j int) bool { return tree.Entries[i].Name < tree.Entries[j].Name })
+	root := encode(tree, plumbing.TreeObject)
+
+	sig := object.Signature{Name: "grm", Email: "grm@grmpkg.com", When: time.Unix(0, 0).UTC()}
+	commit := encode(&object.Commit{Author: sig, Committer: sig, Message: "commit", TreeHash: root.Hash}, plumbing.CommitObject)
+	return append(objs, root, commit)
+}
+
+// allowAll is a policy that accepts every tag name.
+type allowAll struct{}
+
+func (allowAll) Evaluate(string, interface{}) bool { return true }
+func (allowAll) Reason(string, interface{}) string { return "" }
+
+// pushRequest encodes the commands of a push, with the capabilities on the
+// first command, followed by the pack.
+func pushRequest(caps string, cmds []string, pack []byte) []byte {
+	b := &bytes.Buffer{}
+	e := pktline.NewEncoder(b)
+	for i, cmd := range cmds {
+		if i == 0 {
+			cmd += "\x00" + caps
+		}
+		e.EncodeString(cmd + "\n")
+	}
+	e.Flush()
+	b.Write(pack)
+	return b.Bytes()
+}
+
+// nonAtomic is storage that cannot create references atomically.
+type nonAtomic struct {
+	*memory.MemoryStorage
+}
+
+func (nonAtomic) AtomicReferences() bool { return false }
+
+func TestReceivePack_AtomicUnsupported(t *testing.T) {
+	config.SetDomain("grmpkg.com")
+	stor := nonAtomic{memory.NewMemoryStorage()}
+	objs := commitObjects(map[string]string{"go.mod": "module grmpkg.com/ns/repo\n"})
+	commit := objs[len(objs)-1].Hash
+
+	zero := plumbing.ZeroHash.String()
+	req := pushRequest(
+		"report-status atomic", []string{
+			zero + " " + commit.String() + " refs/tags/v1.0.0",
+			zero + " " + commit.String() + " refs/tags/v1.0.1",
+		}, packObjects(objs...),
+	)
+
+	out := &bytes.Buffer{}
+	ReceivePack(bytes.NewReader(req), out, ioutil.Discard, "ns/repo.git", stor, allowAll{})
+
+	if !strings.Contains(out.String(), "ng refs/tags/v1.0.0 atomic pushes are not supported") {
+		t.Errorf("ReceivePack() = %q, want the atomic push rejected", out.String())
+	}
+	if refs, _ := stor.ListReferences("ns/repo.git"); len(refs) != 0 {
+		t.Errorf("ReceivePack() created %v", refs)
+	}
+}
+
+func TestReceivePack_DeleteOnly(t *testing.T) {
+	stor := memory.NewMemoryStorage()
+	objs := commitObjects(map[string]string{"go.mod": "module grmpkg.com/ns/repo\n"})
+	commit := objs[len(objs)-1].Hash
+	stor.StoreObjects("ns/repo.git", objs)
+	stor.CreateReferences("ns/repo.git", []storage.Reference{{Name: "refs/tags/v1.0.0", Hash: commit}})
+
+	// No packfile follows the commands
+	req := pushRequest(
+		"report-status", []string{
+			commit.String() + " " + plumbing.ZeroHash.String() + " refs/tags/v1.0.0",
+		}, nil,
+	)
+
+	out := &bytes.Buffer{}
+	ReceivePack(bytes.NewReader(req), out, ioutil.Discard, "ns/repo.git", stor, allowAll{})
+
+	for _, want := range []string{"unpack ok\n", "ng refs/tags/v1.0.0 "} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("ReceivePack() = %q, want %q", out.String(), want)
+		}
+	}
+	if refs, _ := stor.ListReferences("ns/repo.git"); len(refs) != 1 {
+		t.Errorf("ReceivePack() left %v", refs)
+	}
+}
+
+func TestReceivePack_Fatal(t *testing.T) {
+	objs := commitObjects(map[string]string{"go.mod": "module grmpkg.com/ns/repo\n"})
+	commit := objs[len(objs)-1].Hash
+	cmd := plumbing.ZeroHash.String() + " " + commit.String() + " refs/tags/v1.0.0"
+
+	pack := packObjects(objs...)
+	corrupt := append([]byte{}, pack...)
+	corrupt[len(corrupt)-1] ^= 0xff
+
+	// The commands without the flush packet that ends them
+	truncated := pushRequest("report-status side-band-64k", []string{cmd}, nil)
+	truncated = truncated[:len(truncated)-4]
+
+	tests := []struct {
+		name       string
+		req        []byte
+		wantW      string
+		wantStderr string
+	}{
+		{
+			name:  "Truncated Request",
+			req:   truncated,
+			wantW: "\x03Cannot decode references\n",
+		},
+		{
+			name:  "Unpack Failure",
+			req:   pushRequest("report-status side-band-64k", []string{cmd}, corrupt),
+			wantW: "\x03unpack failed: ",
+		},
+		{
+			name:       "Unpack Failure Without Sideband",
+			req:        pushRequest("report-status", []string{cmd}, corrupt),
+			wantW:      "ng refs/tags/v1.0.0 unpacker error\n",
+			wantStderr: "unpack failed: ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				w, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+				ReceivePack(bytes.NewReader(tt.req), w, stderr, "ns/repo.git", memory.NewMemoryStorage(), allowAll{})
+				if !strings.Contains(w.String(), tt.wantW) || !strings.Contains(stderr.String(), tt.wantStderr) {
+					t.Errorf("ReceivePack() = %q, %q, want %q, %q", w.String(), stderr.String(), tt.wantW, tt.wantStderr)
+				}
+			},
+		)
+	}
+}
+
+func TestDecodePack(t *testing.T) {
+	blob := storage.Object{
+		Hash:    plumbing.ComputeHash(plumbing.BlobObject, []byte("hello world\n")),
+		Type:    plumbing.BlobObject,
+		Content: []byte("hello world\n"),
+	}
+	valid := packObjects(blob)
+
+	corrupt := append([]byte{}, valid...)
+	corrupt[len(corrupt)-1] ^= 0xff
+
+	tests := []struct {
+		name    string
+		pack    []byte
+		want    int
+		wantErr bool
+	}{
+		{
+			name: "Valid",
+			pack: valid,
+			want: 1,
+		},
+		{
+			name:    "Checksum Mismatch",
+			pack:    corrupt,
+			wantErr: true,
+		},
+		{
+			name:    "Truncated",
+			pack:    valid[:len(valid)-25],
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				q := storage.NewQuarantine(memory.NewMemoryStorage(), "ns/repo.git")
+				defer q.Discard()
+
+				got, err := decodePack(bytes.NewReader(tt.pack), q)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("decodePack() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if err != nil {
+					return
+				}
+				if got != tt.want || q.Len() != tt.want {
+					t.Errorf("decodePack() decoded %d objects, want %d", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func TestValidateRef(t *testing.T) {
+	stor := memory.NewMemoryStorage()
+	blob := storage.Object{
+		Hash:    plumbing.ComputeHash(plumbing.BlobObject, []byte("hello world\n")),
+		Type:    plumbing.BlobObject,
+		Content: []byte("hello world\n"),
+	}
+
+	ref := storage.Reference{Name: "refs/tags/v1.0.0", Hash: blob.Hash}
+
+	q := storage.NewQuarantine(stor, "ns/repo.git")
+	if err := validateRef(ref, q); err == nil {
+		t.Errorf("validateRef() accepted a missing object")
+	}
+
+	q.Add(blob)
+	if err := validateRef(ref, q); err != nil {
+		t.Errorf("validateRef() error = %v", err)
+	}
+
+	stor.StoreObject("ns/repo.git", blob, 0)
+	if err := validateRef(ref, storage.NewQuarantine(stor, "ns/repo.git")); err != nil {
+		t.Errorf("validateRef() error = %v", err)
+	}
+}
+
+func TestDecodePack_Thin(t *testing.T) {
+	blob := func(content string) storage.Object {
+		return storage.Object{
+			Hash:    plumbing.ComputeHash(plumbing.BlobObject, []byte(content)),
+			Type:    plumbing.BlobObject,
+			Content: []byte(content),
+		}
+	}
+	base := blob("the quick brown fox jumps over the lazy dog\n")
+	target := blob("the quick brown fox jumps over the lazy cat\n")
+	delta := storage.Object{
+		Hash:    base.Hash,
+		Type:    plumbing.REFDeltaObject,
+		Content: packfile.DiffDelta(base.Content, target.Content),
+	}
+
+	tests := []struct {
+		name    string
+		stored  []storage.Object
+		pack    []byte
+		wantErr bool
+	}{
+		{
+			name:   "Base In Storage",
+			stored: []storage.Object{base},
+			pack:   packObjects(delta),
+		},
+		{
+			name: "Base Later In Pack",
+			pack: packObjects(delta, base),
+		},
+		{
+			name:    "Missing Base",
+			pack:    packObjects(delta),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				stor := memory.NewMemoryStorage()
+				stor.StoreObjects("ns/repo.git", tt.stored)
+				q := storage.NewQuarantine(stor, "ns/repo.git")
+				defer q.Discard()
+
+				_, err := decodePack(bytes.NewReader(tt.pack), q)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("decodePack() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if err == nil && !q.Has(target.Hash) {
+					t.Errorf("decodePack() did not resolve the thin delta")
+				}
+			},
+		)
+	}
+}
+
+// unavailable is storage that cannot read any object.
+type unavailable struct {
+	*memory.MemoryStorage
+}
+
+func (unavailable) GetObject(string, plumbing.Hash) (storage.Object, error) {
+	return storage.Object{}, errors.New("storage unavailable")
+}
+
+func TestDecodePack_StorageError(t *testing.T) {
+	base := []byte("the quick brown fox jumps over the lazy dog\n")
+	delta := storage.Object{
+		Hash:    plumbing.ComputeHash(plumbing.BlobObject, base),
+		Type:    plumbing.REFDeltaObject,
+		Content: packfile.DiffDelta(base, []byte("the quick brown fox jumps over the lazy cat\n")),
+	}
+
+	q := storage.NewQuarantine(unavailable{memory.NewMemoryStorage()}, "ns/repo.git")
+	defer q.Discard()
+
+	_, err := decodePack(bytes.NewReader(packObjects(delta)), q)
+	if err == nil || !strings.Contains(err.Error(), "storage unavailable") {
+		t.Errorf("decodePack() error = %v, want the storage error", err)
+	}
+}
+
+func TestDecodePack_Spill(t *testing.T) {
+	defer config.SetPackSpillThreshold(config.GetPackSpillThreshold())
+	config.SetPackSpillThreshold(64 << 10)
+
+	blob := func(content string) storage.Object {
+		return storage.Object{
+			Hash:    plumbing.ComputeHash(plumbing.BlobObject, []byte(content)),
+			Type:    plumbing.BlobObject,
+			Content: []byte(content),
+		}
+	}
+	large := blob(strings.Repeat("grm", 1<<20))
+
+	t.Run(
+		"Object", func(t *testing.T) {
+			pack := packObjects(large)
+			q := storage.NewQuarantine(memory.NewMemoryStorage(), "ns/repo.git")
+			defer q.Discard()
+
+			// The object is streamed to disk, so decoding allocates far less
+			// than its size
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			_, err := decodePack(bytes.NewReader(pack), q)
+			runtime.ReadMemStats(&after)
+			if err != nil {
+				t.Fatalf("decodePack() error = %v", err)
+			}
+			if alloc := after.TotalAlloc - before.TotalAlloc; alloc > uint64(len(large.Content)/4) {
+				t.Errorf("decodePack() allocated %d bytes for a %d byte object", alloc, len(large.Content))
+			}
+
+			obj, err := q.GetObject(large.Hash)
+			if err != nil || !bytes.Equal(obj.Content, large.Content) {
+				t.Errorf("GetObject() did not return the spilled object, error = %v", err)
+			}
+		},
+	)
+
+	t.Run(
+		"Pending Delta", func(t *testing.T) {
+			target := blob(strings.Repeat("grm", 1<<20) + strings.Repeat("pkg", 1<<16))
+			delta := storage.Object{
+				Hash:    large.Hash,
+				Type:    plumbing.REFDeltaObject,
+				Content: packfile.DiffDelta(large.Content, target.Content),
+			}
+			if len(delta.Content) <= 64<<10 {
+				t.Fatalf("delta of %d bytes is below the spill threshold", len(delta.Content))
+			}
+
+			// The delta comes before its base, so it waits on disk
+			q := storage.NewQuarantine(memory.NewMemoryStorage(), "ns/repo.git")
+			defer q.Discard()
+			if _, err := decodePack(bytes.NewReader(packObjects(delta, large)), q); err != nil {
+				t.Fatalf("decodePack() error = %v", err)
+			}
+
+			obj, err := q.GetObject(target.Hash)
+			if err != nil || !bytes.Equal(obj.Content, target.Content) {
+				t.Errorf("GetObject() did not return the resolved delta, error = %v", err)
+			}
+		},
+	)
+}
+
+func TestRecordSums(t *testing.T) {
+	config.SetDomain("grmpkg.com")
+	stor := memory.NewMemoryStorage()
+	blob := storage.Object{
+		Hash:    plumbing.ComputeHash(plumbing.BlobObject, []byte("hello world\n")),
+		Type:    plumbing.BlobObject,
+		Content: []byte("hello world\n"),
+	}
+	stor.StoreObject("ns/repo.git", blob, 0)
+
+	tag := models.Tag{Name: "latest"}
+	recordSums(&tag, "ns", "repo", storage.Reference{Name: "refs/tags/latest", Hash: blob.Hash}, stor)
+	if tag.Sum != "" || tag.Module != "" {
+		t.Errorf("recordSums() hashed a tag that is not a version")
+	}
+
+	tag = models.Tag{Name: "v1.0.0"}
+	recordSums(&tag, "ns", "repo", storage.Reference{Name: "refs/tags/v1.0.0", Hash: blob.Hash}, stor)
+	if tag.Sum != "" {
+		t.Errorf("recordSums() hashed a tag that does not point at a commit")
+	}
+
+	objs := commitObjects(map[string]string{"hello.txt": "hello world\n"})
+	commit := objs[len(objs)-1]
+	stor.StoreObjects("ns/repo.git", objs)
+
+	tag = models.Tag{Name: "v1.0.0"}
+	recordSums(&tag, "ns", "repo", storage.Reference{Name: "refs/tags/v1.0.0", Hash: commit.Hash}, stor)
+	if tag.Version != "v1.0.0" || !strings.HasPrefix(tag.Sum, "h1:") || !strings.HasPrefix(tag.GoModSum, "h1:") {
+		t.Errorf("recordSums() = %+v", tag)
+	}
+}
+
+func TestValidateModule(t *testing.T) {
+	config.SetDomain("grmpkg.com")
+
+	tests := []struct {
+		name    string
+		tag     plumbing.ReferenceName
+		files   map[string]string
+		wantErr bool
+	}{
+		{
+			name:  "Matching go.mod",
+			tag:   "refs/tags/v1.0.0",
+			files: map[string]string{"go.mod": "module grmpkg.com/ns/repo\n"},
+		},
+		{
+			name:    "Mismatched go.mod",
+			tag:     "refs/tags/v1.0.0",
+			files:   map[string]string{"go.mod": "module github.com/other/thing\n"},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid file name",
+			tag:     "refs/tags/v1.0.0",
+			files:   map[string]string{"go.mod": "module grmpkg.com/ns/repo\n", "aux.go": "package repo\n"},
+			wantErr: true,
+		},
+		{
+			name:    "Case collision",
+			tag:     "refs/tags/v1.0.0",
+			files:   map[string]string{"go.mod": "module grmpkg.com/ns/repo\n", "README": "a\n", "readme": "b\n"},
+			wantErr: true,
+		},
+		{
+			name:  "Not A Version",
+			tag:   "refs/tags/release",
+			files: map[string]string{"go.mod": "module github.com/other/thing\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				objs := commitObjects(tt.files)
+				q := storage.NewQuarantine(memory.NewMemoryStorage(), "ns/repo.git")
+				defer q.Discard()
+				q.Add(objs...)
+
+				ref := storage.Reference{Name: tt.tag, Hash: objs[len(objs)-1].Hash}
+				sb := git.NewSideband(ioutil.Discard, ioutil.Discard, nil)
+				if err := validateModule(ref, "ns/repo.git", q.View(), sb); (err != nil) != tt.wantErr {
+					t.Errorf("validateModule() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,14 @@
+package upload
+
+import (
+	"golang.org/x/crypto/ssh"
+
+	"github.com/Jameslikestea/grm/internal/git"
+	"github.com/Jameslikestea/grm/internal/storage"
+)
+
+// SSHUploadArchive serves git archive --remote over SSH. defaultTag is the tag
+// that the repository has configured for HEAD, if any.
+func SSHUploadArchive(ch ssh.Channel, repo string, stor storage.Storage, defaultTag string) {
+	git.UploadArchive(ch, ch, stor, repo, defaultTag)
+}
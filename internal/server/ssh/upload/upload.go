@@ -1,6 +1,8 @@
 package upload
 
 import (
+	"io"
+
 	"github.com/rs/zerolog/log"
 	"golang.org/x/crypto/ssh"
 
@@ -8,23 +10,51 @@ import (
 	"github.com/Jameslikestea/grm/internal/storage"
 )
 
-func SSHUploadPack(ch ssh.Channel, repo string, stor storage.Storage) {
-	advertiseRefs(ch, stor, repo)
-	wants, haves, _ := git.DecodeWants(ch, stor, repo)
+// SSHUploadPack serves a fetch or clone over SSH. defaultTag is the tag that
+// the repository has configured for HEAD, if any.
+func SSHUploadPack(ch ssh.Channel, repo string, stor storage.Storage, version int, defaultTag string) {
+	UploadPack(ch, ch.Stderr(), repo, stor, version, defaultTag)
+}
 
-	log.Info().Int("haves", len(haves)).Msg("Received Haves")
-	objs := git.GetNewObjects(stor, repo, wants, haves)
-	ch.Write([]byte("0008NAK\n"))
-	git.EncodePackfile(ch, objs)
+// UploadPack serves a fetch or clone over a stateful connection, such as an
+// SSH channel or a git daemon connection.
+func UploadPack(ch io.ReadWriter, stderr io.Writer, repo string, stor storage.Storage, version int, defaultTag string) {
+	if version == git.ProtocolV2 {
+		uploadPackV2(ch, repo, stor, defaultTag)
+		return
+	}
 
-	log.Trace().Int("objs", len(objs)).Msg("Counted number of objects")
+	advertiseRefs(ch, stor, repo, defaultTag)
+	git.UploadPack(ch, ch, stderr, stor, repo, false)
+}
 
+// uploadPackV2 advertises the server capabilities and then serves commands
+// until the client ends the session.
+func uploadPackV2(ch io.ReadWriter, repo string, stor storage.Storage, defaultTag string) {
+	git.GenerateCapabilityAdvertisement(ch)
+	for {
+		cmd, err := git.DecodeCommand(ch)
+		if err != nil {
+			if err != io.EOF {
+				log.Warn().Err(err).Msg("Cannot decode protocol v2 command")
+			}
+			return
+		}
+		git.ServeCommand(cmd, stor, repo, defaultTag, ch)
+	}
 }
 
-func advertiseRefs(ch ssh.Channel, stor storage.Storage, repo string) {
+func advertiseRefs(ch io.Writer, stor storage.Storage, repo string, defaultTag string) {
 	refs, err := stor.ListReferences(repo)
 	if err != nil {
 		log.Error().Err(err).Msg("Cannot list references for advertise pack")
 	}
-	git.GenerateReferencePack(refs, false, "git-upload-pack", ch)
+	git.GenerateReferencePack(
+		refs,
+		git.PeelReferences(stor, repo, refs),
+		git.DefaultHead(refs, defaultTag),
+		false,
+		"git-upload-pack",
+		ch,
+	)
 }
@@ -3,6 +3,7 @@ package sshcmd
 import (
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 
 	"github.com/rs/zerolog"
@@ -10,6 +11,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/Jameslikestea/grm/internal/config"
+	"github.com/Jameslikestea/grm/internal/server/daemon"
 	"github.com/Jameslikestea/grm/internal/server/http"
 	"github.com/Jameslikestea/grm/internal/server/ssh"
 )
@@ -56,6 +58,24 @@ func initRootCmd() {
 			Set:   config.SetSSHInterface,
 			Usage: "Set the SSH interface (0.0.0.0)",
 		},
+		{
+			Name: "daemon.enabled",
+			Set: func(v string) {
+				e, _ := strconv.ParseBool(v)
+				config.SetDaemonEnabled(e)
+			},
+			Usage: "Enable the read only git:// daemon (false)",
+		},
+		{
+			Name:  "daemon.port",
+			Set:   config.SetDaemonPort,
+			Usage: "Set the git:// daemon port (9418)",
+		},
+		{
+			Name:  "daemon.interface",
+			Set:   config.SetDaemonInterface,
+			Usage: "Set the git:// daemon interface (0.0.0.0)",
+		},
 	}
 
 	rootCmd = &cobra.Command{
@@ -96,6 +116,11 @@ func initRootCmd() {
 			go h.Run()
 			go s.Run()
 
+			if config.GetDaemonEnabled() {
+				d := daemon.NewServer()
+				go d.Run()
+			}
+
 			<-c
 			log.Info().Msg("Stopping Services")
 		},
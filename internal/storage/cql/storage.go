@@ -135,6 +135,30 @@ func (C CQLStorage) StoreReferences(s string, references []storage.Reference) er
 	return nil
 }
 
+// CreateReferences inserts the references in a conditional batch, the batch is
+// only applied if none of the references exist. All references of a package
+// share a partition so the batch is atomic.
+func (C CQLStorage) CreateReferences(s string, references []storage.Reference) error {
+	stmt, _ := C.ref.InsertBuilder().Unique().ToCql()
+
+	b := C.conn.NewBatch(gocql.LoggedBatch)
+	for _, ref := range references {
+		b.Query(stmt, s, ref.Name.String(), ref.Hash.String())
+	}
+
+	applied, iter, err := C.conn.MapExecuteBatchCAS(b, map[string]interface{}{})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create references")
+		return err
+	}
+	iter.Close()
+
+	if !applied {
+		return storage.ErrReferenceExists
+	}
+	return nil
+}
+
 func (C CQLStorage) StoreObjects(s string, objects []storage.Object) error {
 	var e errgroup.Group
 	for _, obj := range objects {
@@ -161,6 +185,51 @@ func (C CQLStorage) StoreObject(s string, object storage.Object, ttl int) error
 	return nil
 }
 
+// CreateObject inserts the object with IF NOT EXISTS, so that of two concurrent
+// inserts exactly one is applied.
+func (C CQLStorage) CreateObject(s string, object storage.Object) error {
+	stmt, _ := C.obj.InsertBuilder().Unique().ToCql()
+
+	applied, err := C.conn.Session.Query(
+		stmt,
+		s,
+		object.Type,
+		object.Hash.String(),
+		object.Content,
+	).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create object")
+		return err
+	}
+
+	if !applied {
+		return storage.ErrObjectExists
+	}
+	return nil
+}
+
+// ReplaceObject updates the object in a lightweight transaction that only
+// applies while the content is still the content of old.
+func (C CQLStorage) ReplaceObject(s string, old storage.Object, object storage.Object) error {
+	applied, err := C.conn.Session.Query(
+		"UPDATE objs SET type = ?, content = ? WHERE package = ? AND hash = ? IF content = ?",
+		object.Type,
+		object.Content,
+		s,
+		object.Hash.String(),
+		old.Content,
+	).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to replace object")
+		return err
+	}
+
+	if !applied {
+		return storage.ErrObjectChanged
+	}
+	return nil
+}
+
 func (C CQLStorage) ListReferences(s string) ([]storage.Reference, error) {
 	type ref struct {
 		Package string
@@ -238,12 +307,13 @@ func (C CQLStorage) GetObject(s string, hash plumbing.Hash) (storage.Object, err
 	).ToCql()
 
 	err := C.conn.Query(stmt, names).Bind(hash.String(), s).Get(&refs)
-	if err != nil {
+	if err != nil && !errors.Is(err, gocql.ErrNotFound) {
 		log.Error().Err(err).Str("statement", stmt).Msg("Cannot Select package refs")
+		return storage.Object{}, err
 	}
 
 	if refs.Hash == "" {
-		return storage.Object{}, errors.New("no such object")
+		return storage.Object{}, storage.ErrObjectNotFound
 	}
 
 	var os storage.Object
@@ -1,7 +1,8 @@
 package memory
 
 import (
-	"errors"
+	"bytes"
+	"sync"
 
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/gocql/gocql"
@@ -11,18 +12,34 @@ import (
 
 var _ storage.Storage = MemoryStorage{}
 
+// MemoryStorage keeps everything in maps guarded by one mutex, so that it can
+// be shared by concurrent pushes. References are kept per repository, objects
+// are addressed by their hash alone.
 type MemoryStorage struct {
-	refs    map[string]plumbing.Hash
+	mu      *sync.Mutex
+	refs    map[string]map[plumbing.ReferenceName]plumbing.Hash
 	objects map[plumbing.Hash]storage.Object
 }
 
 func NewMemoryStorage() *MemoryStorage {
 	return &MemoryStorage{
-		refs:    make(map[string]plumbing.Hash),
+		mu:      &sync.Mutex{},
+		refs:    make(map[string]map[plumbing.ReferenceName]plumbing.Hash),
 		objects: make(map[plumbing.Hash]storage.Object),
 	}
 }
 
+// repoRefs returns the references of the repository, creating the map when
+// create is set. The caller must hold the lock.
+func (m MemoryStorage) repoRefs(repo string, create bool) map[plumbing.ReferenceName]plumbing.Hash {
+	refs, ok := m.refs[repo]
+	if !ok && create {
+		refs = make(map[plumbing.ReferenceName]plumbing.Hash)
+		m.refs[repo] = refs
+	}
+	return refs
+}
+
 func (m MemoryStorage) GenerateHashKey() error {
 	// In memory should be for testing only, so we're just going to hard code
 	// a hash key
@@ -40,46 +57,103 @@ func (m MemoryStorage) GetHashKey() ([]storage.HashKey, error) {
 }
 
 func (m MemoryStorage) StoreReferences(repo string, references []storage.Reference) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	refs := m.repoRefs(repo, true)
 	for _, ref := range references {
 		if _, ok := m.objects[ref.Hash]; !ok {
 			continue
 		}
-		m.refs[ref.Name.String()] = ref.Hash
+		refs[ref.Name] = ref.Hash
+	}
+	return nil
+}
+
+func (m MemoryStorage) CreateReferences(repo string, references []storage.Reference) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	refs := m.repoRefs(repo, true)
+	for _, ref := range references {
+		if _, ok := refs[ref.Name]; ok {
+			return storage.ErrReferenceExists
+		}
+	}
+	for _, ref := range references {
+		refs[ref.Name] = ref.Hash
 	}
 	return nil
 }
 
 func (m MemoryStorage) StoreObjects(repo string, objects []storage.Object) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	for _, obj := range objects {
-		m.StoreObject(repo, obj, 0)
+		m.objects[obj.Hash] = obj
 	}
 	return nil
 }
 
 func (m MemoryStorage) StoreObject(repo string, object storage.Object, ttl int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.objects[object.Hash] = object
+	return nil
+}
+
+func (m MemoryStorage) CreateObject(repo string, object storage.Object) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.objects[object.Hash]; ok {
+		return storage.ErrObjectExists
+	}
+	m.objects[object.Hash] = object
+	return nil
+}
+
+func (m MemoryStorage) ReplaceObject(repo string, old storage.Object, object storage.Object) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if cur, ok := m.objects[old.Hash]; !ok || !bytes.Equal(cur.Content, old.Content) {
+		return storage.ErrObjectChanged
+	}
 	m.objects[object.Hash] = object
 	return nil
 }
 
 func (m MemoryStorage) GetObject(repo string, hash plumbing.Hash) (storage.Object, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	obj, ok := m.objects[hash]
 	if !ok {
-		return storage.Object{}, errors.New("no such object")
+		return storage.Object{}, storage.ErrObjectNotFound
 	}
 	return obj, nil
 
 }
 
 func (m MemoryStorage) ListReferences(repo string) ([]storage.Reference, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	l := []storage.Reference{}
-	for r, h := range m.refs {
-		l = append(l, storage.Reference{Name: plumbing.ReferenceName(r), Hash: h})
+	for r, h := range m.repoRefs(repo, false) {
+		l = append(l, storage.Reference{Name: r, Hash: h})
 	}
 
 	return l, nil
 }
 
 func (m MemoryStorage) ListObjects(repo string) ([]storage.Object, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	l := []storage.Object{}
 	for _, h := range m.objects {
 		l = append(l, h)
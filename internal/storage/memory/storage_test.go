@@ -0,0 +1,99 @@
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/Jameslikestea/grm/internal/storage"
+)
+
+func TestMemoryStorage_CreateReferences(t *testing.T) {
+	m := NewMemoryStorage()
+	ref := storage.Reference{Name: "refs/tags/v1.0.0", Hash: plumbing.ComputeHash(plumbing.BlobObject, []byte("a"))}
+
+	if err := m.CreateReferences("acme/widgets.git", []storage.Reference{ref}); err != nil {
+		t.Fatalf("CreateReferences() error = %v", err)
+	}
+	if err := m.CreateReferences("acme/widgets.git", []storage.Reference{ref}); !errors.Is(err, storage.ErrReferenceExists) {
+		t.Errorf("CreateReferences() of an existing tag error = %v, want %v", err, storage.ErrReferenceExists)
+	}
+	// The same tag in another repository is a different reference
+	if err := m.CreateReferences("acme/gadgets.git", []storage.Reference{ref}); err != nil {
+		t.Errorf("CreateReferences() in another repository error = %v", err)
+	}
+
+	refs, _ := m.ListReferences("acme/other.git")
+	if len(refs) != 0 {
+		t.Errorf("ListReferences() of an empty repository = %v", refs)
+	}
+}
+
+func TestMemoryStorage_CreateObject(t *testing.T) {
+	m := NewMemoryStorage()
+	obj := storage.Object{Hash: plumbing.ComputeHash(plumbing.BlobObject, []byte("a")), Type: plumbing.BlobObject, Content: []byte("a")}
+
+	if _, err := m.GetObject("acme/widgets.git", obj.Hash); !errors.Is(err, storage.ErrObjectNotFound) {
+		t.Errorf("GetObject() of a missing object error = %v, want %v", err, storage.ErrObjectNotFound)
+	}
+	if err := m.CreateObject("acme/widgets.git", obj); err != nil {
+		t.Fatalf("CreateObject() error = %v", err)
+	}
+	if err := m.CreateObject("acme/widgets.git", obj); !errors.Is(err, storage.ErrObjectExists) {
+		t.Errorf("CreateObject() of an existing object error = %v, want %v", err, storage.ErrObjectExists)
+	}
+}
+
+func TestMemoryStorage_ReplaceObject(t *testing.T) {
+	m := NewMemoryStorage()
+	hash := plumbing.ComputeHash(plumbing.BlobObject, []byte("list"))
+	old := storage.Object{Hash: hash, Content: []byte("a")}
+	m.StoreObject("acme/widgets.git", old, 0)
+
+	if err := m.ReplaceObject("acme/widgets.git", old, storage.Object{Hash: hash, Content: []byte("b")}); err != nil {
+		t.Fatalf("ReplaceObject() error = %v", err)
+	}
+	// A second replacement of the content it read loses
+	if err := m.ReplaceObject("acme/widgets.git", old, storage.Object{Hash: hash, Content: []byte("c")}); !errors.Is(err, storage.ErrObjectChanged) {
+		t.Errorf("ReplaceObject() of changed content error = %v, want %v", err, storage.ErrObjectChanged)
+	}
+	if obj, _ := m.GetObject("acme/widgets.git", hash); string(obj.Content) != "b" {
+		t.Errorf("GetObject() = %q, want %q", obj.Content, "b")
+	}
+
+	missing := storage.Object{Hash: plumbing.ComputeHash(plumbing.BlobObject, []byte("missing"))}
+	if err := m.ReplaceObject("acme/widgets.git", missing, missing); !errors.Is(err, storage.ErrObjectChanged) {
+		t.Errorf("ReplaceObject() of a missing object error = %v, want %v", err, storage.ErrObjectChanged)
+	}
+}
+
+func TestMemoryStorage_Concurrent(t *testing.T) {
+	m := NewMemoryStorage()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				content := []byte(fmt.Sprintf("%d-%d", i, j))
+				obj := storage.Object{Hash: plumbing.ComputeHash(plumbing.BlobObject, content), Type: plumbing.BlobObject, Content: content}
+				m.StoreObject("acme/widgets.git", obj, 0)
+				m.StoreObjects("acme/widgets.git", []storage.Object{obj})
+				m.GetObject("acme/widgets.git", obj.Hash)
+				m.ListObjects("acme/widgets.git")
+				m.CreateReferences("acme/widgets.git", []storage.Reference{{Name: plumbing.NewTagReferenceName(string(content)), Hash: obj.Hash}})
+				m.ListReferences("acme/widgets.git")
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	refs, _ := m.ListReferences("acme/widgets.git")
+	if len(refs) != 800 {
+		t.Errorf("ListReferences() found %d references, want 800", len(refs))
+	}
+}
@@ -0,0 +1,332 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/rs/zerolog/log"
+
+	"github.com/Jameslikestea/grm/internal/config"
+)
+
+// Quarantine holds the objects received during a push while the references
+// are validated. Nothing is written to the underlying storage until Promote is
+// called, so a rejected push leaves no orphaned objects behind. Objects larger
+// than the spill threshold are kept in a temporary file rather than in memory.
+type Quarantine struct {
+	stor Storage
+	repo string
+
+	mu        sync.Mutex
+	objects   map[plumbing.Hash]Object
+	spilled   map[plumbing.Hash]spilledObject
+	threshold int64
+	file      *os.File
+	size      int64
+	closed    bool
+}
+
+// spilledObject is the location of an object in the quarantine file.
+type spilledObject struct {
+	typ    plumbing.ObjectType
+	offset int64
+	length int64
+}
+
+// NewQuarantine creates an empty quarantine for objects pushed to repo.
+func NewQuarantine(stor Storage, repo string) *Quarantine {
+	return &Quarantine{
+		stor:      stor,
+		repo:      repo,
+		objects:   map[plumbing.Hash]Object{},
+		spilled:   map[plumbing.Hash]spilledObject{},
+		threshold: config.GetPackSpillThreshold(),
+	}
+}
+
+// Add places the objects in quarantine.
+func (q *Quarantine) Add(objs ...Object) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return errors.New("quarantine has already been closed")
+	}
+
+	for _, obj := range objs {
+		if !q.Spills(int64(len(obj.Content))) {
+			q.objects[obj.Hash] = obj
+			continue
+		}
+		if err := q.spill(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Spills reports whether content of the size is kept on disk rather than in
+// memory.
+func (q *Quarantine) Spills(size int64) bool {
+	return q.threshold > 0 && size > q.threshold
+}
+
+func (q *Quarantine) spill(obj Object) error {
+	if _, ok := q.spilled[obj.Hash]; ok {
+		return nil
+	}
+
+	s, err := q.write(
+		func(w io.Writer) error {
+			_, err := w.Write(obj.Content)
+			return err
+		},
+	)
+	if err != nil {
+		return err
+	}
+	q.spilled[obj.Hash] = spilledObject{typ: obj.Type, offset: s.offset, length: s.length}
+
+	log.Trace().Str("hash", obj.Hash.String()).Int("length", len(obj.Content)).Msg("Spilled object to disk")
+	return nil
+}
+
+// Spill is content that was written to the quarantine file without being
+// kept in memory.
+type Spill struct {
+	offset int64
+	length int64
+}
+
+// Len is the size of the spilled content.
+func (s Spill) Len() int64 {
+	return s.length
+}
+
+// WriteSpill appends everything that write writes to the quarantine file, so
+// that content can be streamed to disk as it is received. The quarantine is
+// locked while write runs.
+func (q *Quarantine) WriteSpill(write func(io.Writer) error) (Spill, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return Spill{}, errors.New("quarantine has already been closed")
+	}
+	return q.write(write)
+}
+
+// ReadSpill reads spilled content back into memory.
+func (q *Quarantine) ReadSpill(s Spill) ([]byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil, errors.New("quarantine has already been closed")
+	}
+	content := make([]byte, s.length)
+	if _, err := q.file.ReadAt(content, s.offset); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// AddSpill places spilled content in quarantine as the object with the hash,
+// which the caller computed while writing it.
+func (q *Quarantine) AddSpill(hash plumbing.Hash, typ plumbing.ObjectType, s Spill) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return errors.New("quarantine has already been closed")
+	}
+	if _, ok := q.spilled[hash]; !ok {
+		q.spilled[hash] = spilledObject{typ: typ, offset: s.offset, length: s.length}
+	}
+	return nil
+}
+
+func (q *Quarantine) write(write func(io.Writer) error) (Spill, error) {
+	if q.file == nil {
+		f, err := ioutil.TempFile("", "grm-quarantine-*")
+		if err != nil {
+			return Spill{}, err
+		}
+		q.file = f
+	}
+
+	w := &fileAppender{f: q.file, offset: q.size}
+	if err := write(w); err != nil {
+		return Spill{}, err
+	}
+	s := Spill{offset: q.size, length: w.offset - q.size}
+	q.size = w.offset
+	return s, nil
+}
+
+// fileAppender writes to the file from offset onwards.
+type fileAppender struct {
+	f      *os.File
+	offset int64
+}
+
+func (a *fileAppender) Write(b []byte) (int, error) {
+	n, err := a.f.WriteAt(b, a.offset)
+	a.offset += int64(n)
+	return n, err
+}
+
+func (q *Quarantine) load(hash plumbing.Hash) (Object, bool, error) {
+	if obj, ok := q.objects[hash]; ok {
+		return obj, true, nil
+	}
+
+	s, ok := q.spilled[hash]
+	if !ok {
+		return Object{}, false, nil
+	}
+	content := make([]byte, s.length)
+	if _, err := q.file.ReadAt(content, s.offset); err != nil {
+		return Object{}, true, err
+	}
+	return Object{Hash: hash, Type: s.typ, Content: content}, true, nil
+}
+
+// Has reports whether the object was received in this push.
+func (q *Quarantine) Has(hash plumbing.Hash) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, inMemory := q.objects[hash]
+	_, onDisk := q.spilled[hash]
+	return inMemory || onDisk
+}
+
+// Len is the number of quarantined objects.
+func (q *Quarantine) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.objects) + len(q.spilled)
+}
+
+// GetObject looks for the object in quarantine first and then in the
+// repository, so that validation sees the repository as it would be after the
+// push.
+func (q *Quarantine) GetObject(hash plumbing.Hash) (Object, error) {
+	q.mu.Lock()
+	obj, ok, err := q.load(hash)
+	q.mu.Unlock()
+
+	if ok {
+		return obj, err
+	}
+	return q.stor.GetObject(q.repo, hash)
+}
+
+// View returns a Storage that reads the repository as it would be after the
+// push, so that code written against Storage can inspect pushed trees before
+// they are accepted. Everything other than reading objects of the repository
+// goes straight to the underlying storage.
+func (q *Quarantine) View() Storage {
+	return quarantineView{Storage: q.stor, q: q}
+}
+
+type quarantineView struct {
+	Storage
+	q *Quarantine
+}
+
+func (v quarantineView) GetObject(repo string, hash plumbing.Hash) (Object, error) {
+	if repo != v.q.repo {
+		return v.Storage.GetObject(repo, hash)
+	}
+	return v.q.GetObject(hash)
+}
+
+// Promote moves the quarantined objects into the repository and then creates
+// the references. References are only written once every object has been
+// stored, so they never point at missing objects. A push without any accepted
+// references discards the quarantine instead.
+//
+// References are created with CreateReferences, so one that was created by a
+// concurrent push is not overwritten and is returned as a conflict. When
+// atomic is set either every reference is created or all are returned.
+func (q *Quarantine) Promote(refs []Reference, atomic bool) ([]Reference, error) {
+	if len(refs) == 0 {
+		q.Discard()
+		return nil, nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil, errors.New("quarantine has already been closed")
+	}
+	defer q.close()
+
+	objs := make([]Object, 0, len(q.objects))
+	for _, obj := range q.objects {
+		objs = append(objs, obj)
+	}
+	if err := q.stor.StoreObjects(q.repo, objs); err != nil {
+		return nil, err
+	}
+
+	// Spilled objects are loaded one at a time to keep memory bounded
+	for hash := range q.spilled {
+		obj, _, err := q.load(hash)
+		if err != nil {
+			return nil, err
+		}
+		if err := q.stor.StoreObject(q.repo, obj, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	if atomic {
+		err := q.stor.CreateReferences(q.repo, refs)
+		if errors.Is(err, ErrReferenceExists) {
+			return refs, nil
+		}
+		return nil, err
+	}
+
+	conflicts := []Reference{}
+	for _, ref := range refs {
+		err := q.stor.CreateReferences(q.repo, []Reference{ref})
+		if errors.Is(err, ErrReferenceExists) {
+			conflicts = append(conflicts, ref)
+			continue
+		}
+		if err != nil {
+			return conflicts, err
+		}
+	}
+	return conflicts, nil
+}
+
+// Discard drops every quarantined object, it is safe to call more than once.
+func (q *Quarantine) Discard() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.close()
+}
+
+func (q *Quarantine) close() {
+	q.closed = true
+	q.objects = map[plumbing.Hash]Object{}
+	q.spilled = map[plumbing.Hash]spilledObject{}
+
+	if q.file != nil {
+		q.file.Close()
+		os.Remove(q.file.Name())
+		q.file = nil
+	}
+}
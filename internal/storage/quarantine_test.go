@@ -0,0 +1,195 @@
+package storage_test
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/Jameslikestea/grm/internal/config"
+	"github.com/Jameslikestea/grm/internal/storage"
+	"github.com/Jameslikestea/grm/internal/storage/memory"
+)
+
+func TestQuarantine(t *testing.T) {
+	blob := storage.Object{
+		Hash:    plumbing.ComputeHash(plumbing.BlobObject, []byte("hello world\n")),
+		Type:    plumbing.BlobObject,
+		Content: []byte("hello world\n"),
+	}
+	ref := storage.Reference{Name: "refs/tags/v1.0.0", Hash: blob.Hash}
+
+	tests := []struct {
+		name        string
+		refs        []storage.Reference
+		wantObjects int
+		wantRefs    int
+	}{
+		{
+			name:        "Accepted",
+			refs:        []storage.Reference{ref},
+			wantObjects: 1,
+			wantRefs:    1,
+		},
+		{
+			name: "Rejected",
+			refs: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				stor := memory.NewMemoryStorage()
+				q := storage.NewQuarantine(stor, "ns/repo.git")
+				q.Add(blob)
+
+				if _, err := stor.GetObject("ns/repo.git", blob.Hash); err == nil {
+					t.Errorf("Add() stored the object before promotion")
+				}
+				if _, err := q.GetObject(blob.Hash); err != nil {
+					t.Errorf("GetObject() error = %v", err)
+				}
+
+				if _, err := q.Promote(tt.refs, false); err != nil {
+					t.Fatalf("Promote() error = %v", err)
+				}
+
+				objs, _ := stor.ListObjects("ns/repo.git")
+				if len(objs) != tt.wantObjects {
+					t.Errorf("Promote() stored %d objects, want %d", len(objs), tt.wantObjects)
+				}
+				refs, _ := stor.ListReferences("ns/repo.git")
+				if len(refs) != tt.wantRefs {
+					t.Errorf("Promote() stored %d references, want %d", len(refs), tt.wantRefs)
+				}
+			},
+		)
+	}
+}
+
+func TestQuarantine_Spill(t *testing.T) {
+	config.SetPackSpillThreshold(4)
+	defer config.SetPackSpillThreshold(0)
+
+	blob := storage.Object{
+		Hash:    plumbing.ComputeHash(plumbing.BlobObject, []byte("hello world\n")),
+		Type:    plumbing.BlobObject,
+		Content: []byte("hello world\n"),
+	}
+
+	stor := memory.NewMemoryStorage()
+	q := storage.NewQuarantine(stor, "ns/repo.git")
+	if err := q.Add(blob); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	got, err := q.GetObject(blob.Hash)
+	if err != nil {
+		t.Fatalf("GetObject() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, blob) {
+		t.Errorf("GetObject() got = %v, want %v", got, blob)
+	}
+
+	if _, err := q.Promote([]storage.Reference{{Name: "refs/tags/v1.0.0", Hash: blob.Hash}}, false); err != nil {
+		t.Fatalf("Promote() error = %v", err)
+	}
+	if _, err := stor.GetObject("ns/repo.git", blob.Hash); err != nil {
+		t.Errorf("Promote() did not store the spilled object")
+	}
+}
+
+func TestQuarantine_WriteSpill(t *testing.T) {
+	blob := storage.Object{
+		Hash:    plumbing.ComputeHash(plumbing.BlobObject, []byte("hello world\n")),
+		Type:    plumbing.BlobObject,
+		Content: []byte("hello world\n"),
+	}
+
+	stor := memory.NewMemoryStorage()
+	q := storage.NewQuarantine(stor, "ns/repo.git")
+	s, err := q.WriteSpill(
+		func(w io.Writer) error {
+			w.Write([]byte("hello "))
+			_, err := w.Write([]byte("world\n"))
+			return err
+		},
+	)
+	if err != nil {
+		t.Fatalf("WriteSpill() error = %v", err)
+	}
+	if content, err := q.ReadSpill(s); err != nil || !bytes.Equal(content, blob.Content) {
+		t.Errorf("ReadSpill() = %q, %v, want %q", content, err, blob.Content)
+	}
+
+	if err := q.AddSpill(blob.Hash, blob.Type, s); err != nil {
+		t.Fatalf("AddSpill() error = %v", err)
+	}
+	if _, err := q.Promote([]storage.Reference{{Name: "refs/tags/v1.0.0", Hash: blob.Hash}}, false); err != nil {
+		t.Fatalf("Promote() error = %v", err)
+	}
+	if got, err := stor.GetObject("ns/repo.git", blob.Hash); err != nil || !reflect.DeepEqual(got, blob) {
+		t.Errorf("Promote() stored %v, %v, want %v", got, err, blob)
+	}
+}
+
+func TestQuarantine_Conflict(t *testing.T) {
+	blob := storage.Object{
+		Hash:    plumbing.ComputeHash(plumbing.BlobObject, []byte("hello world\n")),
+		Type:    plumbing.BlobObject,
+		Content: []byte("hello world\n"),
+	}
+	existing := storage.Reference{Name: "refs/tags/v1.0.0", Hash: plumbing.ZeroHash}
+	fresh := storage.Reference{Name: "refs/tags/v1.1.0", Hash: blob.Hash}
+
+	tests := []struct {
+		name          string
+		atomic        bool
+		wantConflicts int
+		wantRefs      int
+	}{
+		{
+			name:          "Non Atomic",
+			atomic:        false,
+			wantConflicts: 1,
+			wantRefs:      2,
+		},
+		{
+			name:          "Atomic",
+			atomic:        true,
+			wantConflicts: 2,
+			wantRefs:      1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				stor := memory.NewMemoryStorage()
+				stor.StoreObject("ns/repo.git", blob, 0)
+				stor.StoreReferences("ns/repo.git", []storage.Reference{{Name: existing.Name, Hash: blob.Hash}})
+
+				q := storage.NewQuarantine(stor, "ns/repo.git")
+				q.Add(blob)
+
+				conflicts, err := q.Promote([]storage.Reference{existing, fresh}, tt.atomic)
+				if err != nil {
+					t.Fatalf("Promote() error = %v", err)
+				}
+				if len(conflicts) != tt.wantConflicts {
+					t.Errorf("Promote() returned %d conflicts, want %d", len(conflicts), tt.wantConflicts)
+				}
+				refs, _ := stor.ListReferences("ns/repo.git")
+				if len(refs) != tt.wantRefs {
+					t.Errorf("Promote() stored %d references, want %d", len(refs), tt.wantRefs)
+				}
+				for _, ref := range refs {
+					if ref.Name == existing.Name && ref.Hash != blob.Hash {
+						t.Errorf("Promote() overwrote an existing reference")
+					}
+				}
+			},
+		)
+	}
+}
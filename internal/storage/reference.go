@@ -2,6 +2,7 @@ package storage
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/go-git/go-git/v5/plumbing"
@@ -41,10 +42,53 @@ func (a AuthenticationSession) UnhashedString() string {
 	return fmt.Sprintf("%s:%s:%s", a.TID, a.Type, a.User)
 }
 
+// ErrReferenceExists is returned by CreateReferences when one of the
+// references already exists, in which case none of them are created.
+var ErrReferenceExists = errors.New("reference already exists")
+
+// ErrObjectExists is returned by CreateObject when the repository already holds
+// an object with the same hash.
+var ErrObjectExists = errors.New("object already exists")
+
+// ErrObjectChanged is returned by ReplaceObject when the stored object no
+// longer has the content that the caller read.
+var ErrObjectChanged = errors.New("object changed concurrently")
+
+// ErrObjectNotFound is returned by GetObject when the repository does not hold
+// the object, as opposed to the storage failing to read it.
+var ErrObjectNotFound = errors.New("no such object")
+
+// atomicReferences is implemented by storage that can tell whether
+// CreateReferences applies several references in a single write.
+type atomicReferences interface {
+	AtomicReferences() bool
+}
+
+// SupportsAtomic reports whether CreateReferences creates several references
+// atomically, so that readers never see part of them. Atomic pushes are only
+// offered on storage that does, which is assumed unless it says otherwise.
+func SupportsAtomic(stor Storage) bool {
+	a, ok := stor.(atomicReferences)
+	return !ok || a.AtomicReferences()
+}
+
 type Storage interface {
 	StoreReferences(string, []Reference) error
+	// CreateReferences creates every reference only if none of them exist yet.
+	// It is atomic with respect to concurrent pushes, so of two pushes that
+	// create the same reference exactly one succeeds.
+	CreateReferences(string, []Reference) error
 	StoreObjects(string, []Object) error
 	StoreObject(string, Object, int) error
+	// CreateObject stores the object only if the repository does not hold an
+	// object with the same hash yet. Of two concurrent calls for the same hash
+	// exactly one succeeds, the other returns ErrObjectExists.
+	CreateObject(string, Object) error
+	// ReplaceObject stores the object in place of old, which has the same
+	// hash, only if the stored object still has the content of old. Of two
+	// concurrent replacements of the same content exactly one succeeds, the
+	// other returns ErrObjectChanged.
+	ReplaceObject(repo string, old Object, object Object) error
 	ListReferences(string) ([]Reference, error)
 	ListObjects(string) ([]Object, error)
 	GetObject(string, plumbing.Hash) (Object, error)
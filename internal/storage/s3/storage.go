@@ -3,11 +3,13 @@ package s3
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"strconv"
 	"strings"
 	"syscall"
@@ -35,8 +37,9 @@ func NewS3Storage() *S3Storage {
 
 	c, err := minio.New(
 		config.GetStorageS3Endpoint(), &minio.Options{
-			Creds:  credentials.NewStaticV4(config.GetStorageS3AccessKey(), config.GetStorageS3SecretKey(), ""),
-			Secure: config.GetStorageS3SSL(),
+			Creds:     credentials.NewStaticV4(config.GetStorageS3AccessKey(), config.GetStorageS3SecretKey(), ""),
+			Secure:    config.GetStorageS3SSL(),
+			Transport: conditionalTransport{http.DefaultTransport.(*http.Transport).Clone()},
 		},
 	)
 	if err != nil {
@@ -47,6 +50,32 @@ func NewS3Storage() *S3Storage {
 	}
 }
 
+// ifNoneMatchKey marks a request context whose PUT must only succeed if the
+// object does not exist yet.
+type ifNoneMatchKey struct{}
+
+// ifMatchKey holds the ETag that the object replaced by the PUT of a request
+// context must still have.
+type ifMatchKey struct{}
+
+// conditionalTransport adds the If-None-Match and If-Match headers to marked
+// requests, minio-go does not expose conditional writes in its put options.
+type conditionalTransport struct {
+	http.RoundTripper
+}
+
+func (t conditionalTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodPut && req.Context().Value(ifNoneMatchKey{}) != nil {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", "*")
+	}
+	if etag, ok := req.Context().Value(ifMatchKey{}).(string); ok && req.Method == http.MethodPut {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-Match", etag)
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
+
 func checkLimit() {
 	var nofiles syscall.Rlimit
 	err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &nofiles)
@@ -60,6 +89,36 @@ func checkLimit() {
 }
 
 func (s2 S3Storage) StoreObject(s string, object storage.Object, i int) error {
+	return s2.putObject(context.Background(), s, object, i)
+}
+
+// CreateObject uploads the object with If-None-Match, so that the bucket
+// refuses it when the key already exists.
+func (s2 S3Storage) CreateObject(s string, object storage.Object) error {
+	ctx := context.WithValue(context.Background(), ifNoneMatchKey{}, true)
+	err := s2.putObject(ctx, s, object, 0)
+	if err != nil && minio.ToErrorResponse(err).StatusCode == http.StatusPreconditionFailed {
+		return storage.ErrObjectExists
+	}
+	return err
+}
+
+// ReplaceObject puts the object only if the stored one still has the ETag of
+// old, which S3 computes as the MD5 of the content of a single part upload.
+func (s2 S3Storage) ReplaceObject(s string, old storage.Object, object storage.Object) error {
+	ctx := context.WithValue(context.Background(), ifMatchKey{}, fmt.Sprintf("\"%x\"", md5.Sum(old.Content)))
+	err := s2.putObject(ctx, s, object, 0)
+	if err != nil {
+		// A conditional put of a missing key fails as not found
+		switch minio.ToErrorResponse(err).StatusCode {
+		case http.StatusPreconditionFailed, http.StatusNotFound:
+			return storage.ErrObjectChanged
+		}
+	}
+	return err
+}
+
+func (s2 S3Storage) putObject(ctx context.Context, s string, object storage.Object, i int) error {
 	bucket := config.GetStorageS3Bucket()
 
 	options := minio.PutObjectOptions{
@@ -73,7 +132,7 @@ func (s2 S3Storage) StoreObject(s string, object storage.Object, i int) error {
 	}
 
 	info, err := s2.mc.PutObject(
-		context.Background(),
+		ctx,
 		bucket,
 		fmt.Sprintf("%s/objects/%s/%s", strings.Trim(s, `'"`), object.Hash.String()[0:2], object.Hash.String()),
 		bytes.NewReader(object.Content),
@@ -141,6 +200,50 @@ func (s2 S3Storage) StoreReferences(s string, references []storage.Reference) er
 	return nil
 }
 
+// CreateReferences writes each reference only if it does not exist yet. Every
+// reference is its own object, so if one of them already exists the ones that
+// were written by this call are removed again. Readers can see some of the
+// references before that happens, so it is not atomic for more than one
+// reference.
+func (s2 S3Storage) CreateReferences(s string, references []storage.Reference) error {
+	bucket := config.GetStorageS3Bucket()
+	ctx := context.WithValue(context.Background(), ifNoneMatchKey{}, true)
+
+	created := []string{}
+	for _, ref := range references {
+		r := fmt.Sprintf("%s/references/%X", strings.Trim(s, `'"`), ref.Name.String())
+		_, err := s2.mc.PutObject(
+			ctx,
+			bucket,
+			r,
+			strings.NewReader(ref.Hash.String()),
+			int64(len(ref.Hash.String())),
+			minio.PutObjectOptions{},
+		)
+		if err == nil {
+			created = append(created, r)
+			continue
+		}
+
+		for _, key := range created {
+			if err := s2.mc.RemoveObject(context.Background(), bucket, key, minio.RemoveObjectOptions{}); err != nil {
+				log.Error().Err(err).Str("key", key).Msg("Cannot roll back reference")
+			}
+		}
+		if minio.ToErrorResponse(err).StatusCode == http.StatusPreconditionFailed {
+			return storage.ErrReferenceExists
+		}
+		return err
+	}
+	return nil
+}
+
+// AtomicReferences is false as references are written one object at a time,
+// receive-pack does not offer atomic pushes on this storage.
+func (s2 S3Storage) AtomicReferences() bool {
+	return false
+}
+
 func (s2 S3Storage) StoreObjects(s string, objects []storage.Object) error {
 	concurrency := config.GetStorageS3Concurrency()
 	c := make(chan storage.Object, concurrency)
@@ -255,6 +358,9 @@ func (s2 S3Storage) GetObject(s string, hash plumbing.Hash) (storage.Object, err
 
 	tags, err := s2.mc.GetObjectTagging(context.Background(), bucket, key, minio.GetObjectTaggingOptions{})
 	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return storage.Object{}, storage.ErrObjectNotFound
+		}
 		return storage.Object{}, err
 	}
 
@@ -6,6 +6,7 @@ import (
 	"encoding/gob"
 	"errors"
 	"fmt"
+	"net/http"
 	"syscall"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -24,6 +25,11 @@ import (
 
 var _ storage.Storage = &S3Storage{}
 
+// createAttempts is how often CreateReferences, CreateObject, ReplaceObject
+// and StoreObjects retry when another push changes the gob between reading and
+// writing it.
+const createAttempts = 5
+
 type S3Storage struct {
 	sess *session.Session
 	sc   *s3.S3
@@ -70,6 +76,13 @@ func checkLimit() {
 }
 
 func (s2 S3Storage) getReferenceGob(s string) (map[plumbing.ReferenceName]plumbing.Hash, error) {
+	m, _, err := s2.getReferenceGobETag(s)
+	return m, err
+}
+
+// getReferenceGobETag also returns the ETag of the reference gob so that it can
+// be replaced conditionally, the ETag is empty if the gob does not exist.
+func (s2 S3Storage) getReferenceGobETag(s string) (map[plumbing.ReferenceName]plumbing.Hash, string, error) {
 	bucket := config.GetStorageS3Bucket()
 	key := fmt.Sprintf("%s/references.gob", s)
 	m := map[plumbing.ReferenceName]plumbing.Hash{}
@@ -81,15 +94,16 @@ func (s2 S3Storage) getReferenceGob(s string) (map[plumbing.ReferenceName]plumbi
 
 	o, err := s2.sc.GetObject(input)
 	if err != nil {
-		return m, err
+		return m, "", err
 	}
+	defer o.Body.Close()
 
 	err = gob.NewDecoder(o.Body).Decode(&m)
 	if err != nil {
-		return m, err
+		return m, "", err
 	}
 
-	return m, nil
+	return m, aws.StringValue(o.ETag), nil
 }
 
 func (s2 S3Storage) storeReferenceGob(s string, refs map[plumbing.ReferenceName]plumbing.Hash) error {
@@ -115,6 +129,13 @@ func (s2 S3Storage) storeReferenceGob(s string, refs map[plumbing.ReferenceName]
 }
 
 func (s2 S3Storage) getObjectsGob(s string) (map[plumbing.Hash]storage.Object, error) {
+	m, _, err := s2.getObjectsGobETag(s)
+	return m, err
+}
+
+// getObjectsGobETag also returns the ETag of the object gob so that it can be
+// replaced conditionally, the ETag is empty if the gob does not exist.
+func (s2 S3Storage) getObjectsGobETag(s string) (map[plumbing.Hash]storage.Object, string, error) {
 	bucket := config.GetStorageS3Bucket()
 	key := fmt.Sprintf("%s/objects.gob", s)
 	m := map[plumbing.Hash]storage.Object{}
@@ -126,60 +147,51 @@ func (s2 S3Storage) getObjectsGob(s string) (map[plumbing.Hash]storage.Object, e
 
 	o, err := s2.sc.GetObject(input)
 	if err != nil {
-		return m, err
+		return m, "", err
 	}
+	defer o.Body.Close()
 
 	err = gob.NewDecoder(o.Body).Decode(&m)
 	if err != nil {
-		return m, err
+		return m, "", err
 	}
 
-	return m, nil
+	return m, aws.StringValue(o.ETag), nil
 }
 
-func (s2 S3Storage) storeObjectGob(s string, objs map[plumbing.Hash]storage.Object) error {
+// putGobIfUnchanged replaces the gob only if its ETag still matches, or only if
+// it does not exist yet when etag is empty. It reports false when S3 rejected
+// the write because the gob changed in the meantime.
+func (s2 S3Storage) putGobIfUnchanged(key string, v interface{}, etag string) (bool, error) {
 	bucket := config.GetStorageS3Bucket()
-	key := fmt.Sprintf("%s/objects.gob", s)
 
 	b := bytes.NewBuffer([]byte{})
-
-	err := gob.NewEncoder(b).Encode(objs)
-	if err != nil {
-		return err
+	if err := gob.NewEncoder(b).Encode(v); err != nil {
+		return false, err
 	}
 
-	input := &s3.PutObjectInput{
-		Bucket: &bucket,
-		Key:    &key,
-		Body:   bytes.NewReader(b.Bytes()),
+	req, _ := s2.sc.PutObjectRequest(
+		&s3.PutObjectInput{
+			Bucket: &bucket,
+			Key:    &key,
+			Body:   bytes.NewReader(b.Bytes()),
+		},
+	)
+	if etag == "" {
+		req.HTTPRequest.Header.Set("If-None-Match", "*")
+	} else {
+		req.HTTPRequest.Header.Set("If-Match", etag)
 	}
 
-	_, err = s2.sc.PutObject(input)
-
-	return err
+	err := req.Send()
+	if awsErr, ok := err.(awserr.RequestFailure); ok && awsErr.StatusCode() == http.StatusPreconditionFailed {
+		return false, nil
+	}
+	return err == nil, err
 }
 
 func (s2 S3Storage) StoreObject(s string, object storage.Object, i int) error {
-	objs, err := s2.getObjectsGob(s)
-	log.Debug().Err(err).Interface("objects", objs).Msg("get object gob")
-	if err != nil {
-		if awsErr, ok := err.(awserr.Error); ok {
-			switch awsErr.Code() {
-			case s3.ErrCodeNoSuchKey:
-				// Do nothing
-			default:
-				return err
-			}
-		} else {
-			return err
-		}
-	}
-
-	objs[object.Hash] = object
-	log.Debug().Interface("objects", objs).Msg("updated hashmap")
-
-	err = s2.storeObjectGob(s, objs)
-	return err
+	return s2.StoreObjects(s, []storage.Object{object})
 }
 
 func (s2 S3Storage) GenerateHashKey() error {
@@ -243,28 +255,132 @@ func (s2 S3Storage) StoreReferences(s string, references []storage.Reference) er
 	return err
 }
 
-func (s2 S3Storage) StoreObjects(s string, objects []storage.Object) error {
-	objs, err := s2.getObjectsGob(s)
-	if err != nil {
-		if awsErr, ok := err.(awserr.Error); ok {
-			switch awsErr.Code() {
-			case s3.ErrCodeNoSuchKey:
-				// Do nothing
-			default:
+// CreateReferences replaces the reference gob only if it has not changed since
+// it was read, S3 rejects the write with a failed precondition otherwise and
+// the references are checked again against the new gob.
+func (s2 S3Storage) CreateReferences(s string, references []storage.Reference) error {
+	key := fmt.Sprintf("%s/references.gob", s)
+
+	for attempt := 0; attempt < createAttempts; attempt++ {
+		refs, etag, err := s2.getReferenceGobETag(s)
+		if err != nil {
+			if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != s3.ErrCodeNoSuchKey {
 				return err
 			}
-		} else {
+		}
+
+		for _, ref := range references {
+			if _, ok := refs[ref.Name]; ok {
+				return storage.ErrReferenceExists
+			}
+			refs[ref.Name] = ref.Hash
+		}
+
+		ok, err := s2.putGobIfUnchanged(key, refs, etag)
+		if err != nil {
 			return err
 		}
+		if ok {
+			return nil
+		}
+		log.Debug().Str("repo", s).Int("attempt", attempt).Msg("References changed concurrently, retrying")
 	}
 
-	for _, obj := range objects {
-		objs[obj.Hash] = obj
+	return errors.New("cannot create references: too many concurrent updates")
+}
+
+// CreateObject replaces the object gob only if it has not changed since it was
+// read, in the same way as CreateReferences.
+func (s2 S3Storage) CreateObject(s string, object storage.Object) error {
+	key := fmt.Sprintf("%s/objects.gob", s)
+
+	for attempt := 0; attempt < createAttempts; attempt++ {
+		objs, etag, err := s2.getObjectsGobETag(s)
+		if err != nil {
+			if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != s3.ErrCodeNoSuchKey {
+				return err
+			}
+		}
+
+		if _, ok := objs[object.Hash]; ok {
+			return storage.ErrObjectExists
+		}
+		objs[object.Hash] = object
+
+		ok, err := s2.putGobIfUnchanged(key, objs, etag)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		log.Debug().Str("repo", s).Int("attempt", attempt).Msg("Objects changed concurrently, retrying")
 	}
 
-	err = s2.storeObjectGob(s, objs)
+	return errors.New("cannot create object: too many concurrent updates")
+}
 
-	return err
+// ReplaceObject replaces the object gob only if it has not changed since it
+// was read, in the same way as CreateObject, and only while the object still
+// has the content of old.
+func (s2 S3Storage) ReplaceObject(s string, old storage.Object, object storage.Object) error {
+	key := fmt.Sprintf("%s/objects.gob", s)
+
+	for attempt := 0; attempt < createAttempts; attempt++ {
+		objs, etag, err := s2.getObjectsGobETag(s)
+		if err != nil {
+			if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != s3.ErrCodeNoSuchKey {
+				return err
+			}
+		}
+
+		if cur, ok := objs[old.Hash]; !ok || !bytes.Equal(cur.Content, old.Content) {
+			return storage.ErrObjectChanged
+		}
+		objs[object.Hash] = object
+
+		ok, err := s2.putGobIfUnchanged(key, objs, etag)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		log.Debug().Str("repo", s).Int("attempt", attempt).Msg("Objects changed concurrently, retrying")
+	}
+
+	return errors.New("cannot replace object: too many concurrent updates")
+}
+
+// StoreObjects replaces the object gob only if it has not changed since it was
+// read, so that objects stored by a concurrent push are not dropped while its
+// references are still created.
+func (s2 S3Storage) StoreObjects(s string, objects []storage.Object) error {
+	key := fmt.Sprintf("%s/objects.gob", s)
+
+	for attempt := 0; attempt < createAttempts; attempt++ {
+		objs, etag, err := s2.getObjectsGobETag(s)
+		if err != nil {
+			if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != s3.ErrCodeNoSuchKey {
+				return err
+			}
+		}
+
+		for _, obj := range objects {
+			objs[obj.Hash] = obj
+		}
+
+		ok, err := s2.putGobIfUnchanged(key, objs, etag)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		log.Debug().Str("repo", s).Int("attempt", attempt).Msg("Objects changed concurrently, retrying")
+	}
+
+	return errors.New("cannot store objects: too many concurrent updates")
 }
 
 func (s2 S3Storage) ListReferences(s string) ([]storage.Reference, error) {
@@ -332,7 +448,7 @@ func (s2 S3Storage) GetObject(s string, hash plumbing.Hash) (storage.Object, err
 
 	o, ok := objs[hash]
 	if !ok {
-		return storage.Object{}, errors.New("cannot find object")
+		return storage.Object{}, storage.ErrObjectNotFound
 	}
 
 	return o, nil
@@ -0,0 +1,33 @@
+package sumdb
+
+import (
+	"errors"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb/tlog"
+)
+
+// ErrNotFound is returned for modules, records and tiles that are not in the
+// log yet.
+var ErrNotFound = errors.New("not found")
+
+// Manager keeps the transparency log of module checksums that GRM serves as a
+// checksum database. Records are only ever appended, so once a version is in
+// the log its hashes can never change.
+type Manager interface {
+	// Name is the name of the database as it is used in GOSUMDB.
+	Name() string
+	// VerifierKey is the public key that clients verify the tree heads with.
+	VerifierKey() (string, error)
+
+	// Lookup returns the id of the record of the module version.
+	Lookup(m module.Version) (int64, error)
+	// Add appends a record of the hashes unless the version is already in the
+	// log, in which case the existing record is returned.
+	Add(m module.Version, zipHash, modHash string) (int64, error)
+
+	ReadRecords(id, n int64) ([][]byte, error)
+	ReadTileData(t tlog.Tile) ([]byte, error)
+	// Signed returns the signed note of the latest tree head.
+	Signed() ([]byte, error)
+}
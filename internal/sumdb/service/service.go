@@ -0,0 +1,349 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb/note"
+	"golang.org/x/mod/sumdb/tlog"
+
+	"github.com/Jameslikestea/grm/internal/config"
+	"github.com/Jameslikestea/grm/internal/storage"
+	"github.com/Jameslikestea/grm/internal/sumdb"
+)
+
+var _ sumdb.Manager = (*Service)(nil)
+
+const hashKeySalt = "sumdb:key:"
+const hashRecordSalt = "sumdb:record:"
+const hashTreeSalt = "sumdb:hash:"
+const hashLookupSalt = "sumdb:lookup:"
+const hashHeadSalt = "sumdb:head"
+const repo = "_internal._sumdb"
+
+// Service stores the log in storage. Record ids are claimed by creating the
+// record with CreateObject, so that two servers sharing storage can never
+// append different records with the same id. Everything else is derived from
+// the records, a record whose server failed before storing it is finished by
+// the next Add.
+type Service struct {
+	stor storage.Storage
+
+	mu       sync.Mutex
+	signer   note.Signer
+	verifier string
+}
+
+func New(stor storage.Storage) *Service {
+	return &Service{
+		stor: stor,
+	}
+}
+
+func (s *Service) Name() string {
+	return config.GetSumDBName()
+}
+
+// loadSigner uses the configured key, or the key that was generated for the
+// database the first time it was used.
+func (s *Service) loadSigner() (note.Signer, error) {
+	if s.signer != nil {
+		return s.signer, nil
+	}
+
+	skey := config.GetSumDBKey()
+	if skey == "" {
+		h := plumbing.ComputeHash(0, []byte(hashKeySalt+s.Name()))
+		obj, err := s.stor.GetObject(repo, h)
+		if err == nil {
+			skey = string(obj.Content)
+		} else {
+			skey, _, err = note.GenerateKey(rand.Reader, s.Name())
+			if err != nil {
+				return nil, err
+			}
+			// Servers sharing the storage must sign with the same key, so the
+			// key that was claimed first is used by all of them
+			err = s.stor.CreateObject(repo, storage.Object{Hash: h, Type: 0, Content: []byte(skey)})
+			switch {
+			case errors.Is(err, storage.ErrObjectExists):
+				obj, err := s.stor.GetObject(repo, h)
+				if err != nil {
+					return nil, err
+				}
+				skey = string(obj.Content)
+			case err != nil:
+				return nil, err
+			default:
+				log.Info().Str("name", s.Name()).Msg("Generated checksum database key")
+			}
+		}
+	}
+
+	signer, err := note.NewSigner(skey)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := verifierKey(skey)
+	if err != nil {
+		return nil, err
+	}
+	s.signer, s.verifier = signer, verifier
+	return signer, nil
+}
+
+// verifierKey derives the public verifier key from an ed25519 signer key.
+func verifierKey(skey string) (string, error) {
+	parts := strings.SplitN(skey, "+", 5)
+	if len(parts) != 5 || parts[0] != "PRIVATE" || parts[1] != "KEY" {
+		return "", errors.New("malformed signer key")
+	}
+	key, err := base64.StdEncoding.DecodeString(parts[4])
+	if err != nil || len(key) != 1+ed25519.SeedSize || key[0] != 1 {
+		return "", errors.New("malformed signer key")
+	}
+	pub := ed25519.NewKeyFromSeed(key[1:]).Public().(ed25519.PublicKey)
+	return fmt.Sprintf("%s+%s+%s", parts[2], parts[3], base64.StdEncoding.EncodeToString(append([]byte{1}, pub...))), nil
+}
+
+func (s *Service) VerifierKey() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.loadSigner(); err != nil {
+		return "", err
+	}
+	return s.verifier, nil
+}
+
+func (s *Service) Lookup(m module.Version) (int64, error) {
+	h := plumbing.ComputeHash(0, []byte(hashLookupSalt+m.Path+"@"+m.Version))
+	obj, err := s.stor.GetObject(repo, h)
+	if err != nil {
+		return 0, sumdb.ErrNotFound
+	}
+	return strconv.ParseInt(string(obj.Content), 10, 64)
+}
+
+func (s *Service) Add(m module.Version, zipHash, modHash string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id, err := s.Lookup(m); err == nil {
+		return id, nil
+	}
+
+	head, err := s.storedTree()
+	if err != nil {
+		return 0, err
+	}
+	id := head.N
+	record := []byte(
+		fmt.Sprintf(
+			"%s %s %s\n%s %s/go.mod %s\n", m.Path, m.Version, zipHash, m.Path, m.Version, modHash,
+		),
+	)
+
+	for {
+		// The stored head lags behind records that were claimed by another
+		// server, or whose server failed before finishing them
+		for {
+			claimed, err := s.stor.GetObject(repo, recordHash(id))
+			if errors.Is(err, storage.ErrObjectNotFound) {
+				break
+			}
+			if err != nil {
+				return 0, err
+			}
+			if err := s.finish(id, claimed.Content); err != nil {
+				return 0, fmt.Errorf("cannot finish record %d: %w", id, err)
+			}
+			id++
+		}
+		if existing, err := s.Lookup(m); err == nil {
+			return existing, nil
+		}
+
+		err := s.stor.CreateObject(repo, storage.Object{Hash: recordHash(id), Content: record})
+		if errors.Is(err, storage.ErrObjectExists) {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("cannot claim record %d: %w", id, err)
+		}
+		if err := s.finish(id, record); err != nil {
+			return 0, fmt.Errorf("cannot finish record %d: %w", id, err)
+		}
+
+		log.Info().Str("module", m.String()).Int64("id", id).Msg("Added module to checksum database")
+		return id, nil
+	}
+}
+
+// finish stores everything that is derived from a claimed record. Every
+// record before it must be finished already. Writing the same record twice
+// stores the same objects, so any server may finish a record that another one
+// claimed.
+func (s *Service) finish(id int64, record []byte) error {
+	fields := strings.Fields(string(record))
+	if len(fields) < 2 {
+		return errors.New("malformed record")
+	}
+
+	hashes, err := tlog.StoredHashes(id, record, s.hashReader())
+	if err != nil {
+		return err
+	}
+	// The last hash of a record is stored last, it marks the record as part
+	// of the tree
+	start := tlog.StoredHashIndex(0, id)
+	for i := range hashes {
+		obj := storage.Object{
+			Hash:    plumbing.ComputeHash(0, []byte(hashTreeSalt+strconv.FormatInt(start+int64(i), 10))),
+			Content: hashes[i][:],
+		}
+		if err := s.stor.StoreObject(repo, obj, 0); err != nil {
+			return err
+		}
+	}
+
+	lookup := storage.Object{
+		Hash:    plumbing.ComputeHash(0, []byte(hashLookupSalt+fields[0]+"@"+fields[1])),
+		Content: []byte(strconv.FormatInt(id, 10)),
+	}
+	if err := s.stor.StoreObject(repo, lookup, 0); err != nil {
+		return err
+	}
+
+	th, err := tlog.TreeHash(id+1, s.hashReader())
+	if err != nil {
+		return err
+	}
+	head := storage.Object{
+		Hash:    plumbing.ComputeHash(0, []byte(hashHeadSalt)),
+		Content: tlog.FormatTree(tlog.Tree{N: id + 1, Hash: th}),
+	}
+	return s.stor.StoreObject(repo, head, 0)
+}
+
+// tree returns the latest tree head. The stored head is only a hint, a server
+// that failed after storing the hashes of a record did not move it, and two
+// servers may write it out of order, so the records after it are included as
+// long as their hashes are stored.
+func (s *Service) tree() (tlog.Tree, error) {
+	head, err := s.storedTree()
+	if err != nil {
+		return tlog.Tree{}, err
+	}
+
+	n := head.N
+	for {
+		last := plumbing.ComputeHash(0, []byte(hashTreeSalt+strconv.FormatInt(tlog.StoredHashIndex(0, n+1)-1, 10)))
+		_, err := s.stor.GetObject(repo, last)
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			break
+		}
+		if err != nil {
+			return tlog.Tree{}, err
+		}
+		n++
+	}
+	if n == head.N {
+		return head, nil
+	}
+
+	th, err := tlog.TreeHash(n, s.hashReader())
+	if err != nil {
+		return tlog.Tree{}, err
+	}
+	return tlog.Tree{N: n, Hash: th}, nil
+}
+
+// storedTree returns the stored tree head, the log starts out empty.
+func (s *Service) storedTree() (tlog.Tree, error) {
+	obj, err := s.stor.GetObject(repo, plumbing.ComputeHash(0, []byte(hashHeadSalt)))
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		return tlog.Tree{}, nil
+	}
+	if err != nil {
+		return tlog.Tree{}, err
+	}
+	return tlog.ParseTree(obj.Content)
+}
+
+func recordHash(id int64) plumbing.Hash {
+	return plumbing.ComputeHash(0, []byte(hashRecordSalt+strconv.FormatInt(id, 10)))
+}
+
+func (s *Service) hashReader() tlog.HashReader {
+	return tlog.HashReaderFunc(
+		func(indexes []int64) ([]tlog.Hash, error) {
+			hashes := make([]tlog.Hash, len(indexes))
+			for i, index := range indexes {
+				h := plumbing.ComputeHash(0, []byte(hashTreeSalt+strconv.FormatInt(index, 10)))
+				obj, err := s.stor.GetObject(repo, h)
+				if err != nil || len(obj.Content) != tlog.HashSize {
+					return nil, fmt.Errorf("missing hash %d", index)
+				}
+				copy(hashes[i][:], obj.Content)
+			}
+			return hashes, nil
+		},
+	)
+}
+
+func (s *Service) ReadRecords(id, n int64) ([][]byte, error) {
+	tree, err := s.tree()
+	if err != nil {
+		return nil, err
+	}
+	if id < 0 || n < 0 || id+n > tree.N {
+		return nil, sumdb.ErrNotFound
+	}
+
+	records := [][]byte{}
+	for i := id; i < id+n; i++ {
+		obj, err := s.stor.GetObject(repo, recordHash(i))
+		if err != nil {
+			return nil, fmt.Errorf("missing record %d", i)
+		}
+		records = append(records, obj.Content)
+	}
+	return records, nil
+}
+
+func (s *Service) ReadTileData(t tlog.Tile) ([]byte, error) {
+	tree, err := s.tree()
+	if err != nil {
+		return nil, err
+	}
+	// The last hash in the tile must be covered by the tree
+	if (t.N<<uint(t.H)+int64(t.W))<<uint(t.L*t.H) > tree.N {
+		return nil, sumdb.ErrNotFound
+	}
+	return tlog.ReadTileData(t, s.hashReader())
+}
+
+func (s *Service) Signed() ([]byte, error) {
+	s.mu.Lock()
+	signer, err := s.loadSigner()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := s.tree()
+	if err != nil {
+		return nil, err
+	}
+	return note.Sign(&note.Note{Text: string(tlog.FormatTree(tree))}, signer)
+}
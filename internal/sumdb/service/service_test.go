@@ -0,0 +1,208 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb/note"
+	"golang.org/x/mod/sumdb/tlog"
+
+	"github.com/Jameslikestea/grm/internal/config"
+	"github.com/Jameslikestea/grm/internal/storage"
+	"github.com/Jameslikestea/grm/internal/storage/memory"
+	"github.com/Jameslikestea/grm/internal/sumdb"
+)
+
+func TestService(t *testing.T) {
+	config.SetSumDBName("grmpkg.com")
+	config.SetSumDBKey("")
+	s := New(memory.NewMemoryStorage())
+
+	versions := []module.Version{
+		{Path: "grmpkg.com/acme/widgets", Version: "v1.0.0"},
+		{Path: "grmpkg.com/acme/widgets", Version: "v1.1.0"},
+		{Path: "grmpkg.com/acme/gadgets", Version: "v0.1.0"},
+	}
+
+	// Build the same log in memory to check the stored hashes against
+	var hashes []tlog.Hash
+	reader := tlog.HashReaderFunc(
+		func(indexes []int64) ([]tlog.Hash, error) {
+			out := []tlog.Hash{}
+			for _, i := range indexes {
+				out = append(out, hashes[i])
+			}
+			return out, nil
+		},
+	)
+	for i, v := range versions {
+		id, err := s.Add(v, "h1:zip"+v.Version+"=", "h1:mod"+v.Version+"=")
+		if err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+		if id != int64(i) {
+			t.Errorf("Add() id = %d, want %d", id, i)
+		}
+
+		records, _ := s.ReadRecords(id, 1)
+		stored, _ := tlog.StoredHashes(id, records[0], reader)
+		hashes = append(hashes, stored...)
+	}
+
+	if id, err := s.Add(versions[0], "h1:other=", "h1:other="); err != nil || id != 0 {
+		t.Errorf("Add() of an existing version = %d, %v, want 0", id, err)
+	}
+	if id, err := s.Lookup(versions[1]); err != nil || id != 1 {
+		t.Errorf("Lookup() = %d, %v, want 1", id, err)
+	}
+	if _, err := s.Lookup(module.Version{Path: "grmpkg.com/acme/widgets", Version: "v9.0.0"}); err != sumdb.ErrNotFound {
+		t.Errorf("Lookup() of an unknown version error = %v", err)
+	}
+
+	records, err := s.ReadRecords(0, 1)
+	want := "grmpkg.com/acme/widgets v1.0.0 h1:zipv1.0.0=\ngrmpkg.com/acme/widgets v1.0.0/go.mod h1:modv1.0.0=\n"
+	if err != nil || string(records[0]) != want {
+		t.Errorf("ReadRecords() = %q, %v, want %q", records, err, want)
+	}
+	if _, err := s.ReadRecords(2, 2); err != sumdb.ErrNotFound {
+		t.Errorf("ReadRecords() past the tree error = %v", err)
+	}
+
+	key, err := s.VerifierKey()
+	if err != nil {
+		t.Fatalf("VerifierKey() error = %v", err)
+	}
+	verifier, err := note.NewVerifier(key)
+	if err != nil {
+		t.Fatalf("VerifierKey() is not a verifier key: %v", err)
+	}
+	signed, err := s.Signed()
+	if err != nil {
+		t.Fatalf("Signed() error = %v", err)
+	}
+	n, err := note.Open(signed, note.VerifierList(verifier))
+	if err != nil {
+		t.Fatalf("Signed() cannot be verified: %v", err)
+	}
+	tree, err := tlog.ParseTree([]byte(n.Text))
+	if err != nil {
+		t.Fatalf("Signed() is not a tree: %v", err)
+	}
+	wantHash, _ := tlog.TreeHash(3, reader)
+	if tree.N != 3 || tree.Hash != wantHash {
+		t.Errorf("Signed() tree = %v, want %d %v", tree, 3, wantHash)
+	}
+
+	tile, err := s.ReadTileData(tlog.Tile{H: 8, L: 0, N: 0, W: 3})
+	if err != nil {
+		t.Fatalf("ReadTileData() error = %v", err)
+	}
+	wantTile, _ := tlog.ReadTileData(tlog.Tile{H: 8, L: 0, N: 0, W: 3}, reader)
+	if !bytes.Equal(tile, wantTile) {
+		t.Errorf("ReadTileData() did not match the log")
+	}
+	if _, err := s.ReadTileData(tlog.Tile{H: 8, L: 0, N: 0, W: 4}); err != sumdb.ErrNotFound {
+		t.Errorf("ReadTileData() past the tree error = %v", err)
+	}
+}
+
+// failing is storage that cannot store one object.
+type failing struct {
+	*memory.MemoryStorage
+	hash plumbing.Hash
+}
+
+func (f *failing) StoreObject(repo string, obj storage.Object, ttl int) error {
+	if obj.Hash == f.hash {
+		return errors.New("storage unavailable")
+	}
+	return f.MemoryStorage.StoreObject(repo, obj, ttl)
+}
+
+func TestService_Recover(t *testing.T) {
+	config.SetSumDBName("grmpkg.com")
+	config.SetSumDBKey("")
+	stor := &failing{MemoryStorage: memory.NewMemoryStorage()}
+	s := New(stor)
+
+	v := func(version string) module.Version {
+		return module.Version{Path: "grmpkg.com/acme/widgets", Version: version}
+	}
+	if _, err := s.Add(v("v1.0.0"), "h1:zip=", "h1:mod="); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	// A server that fails after claiming record 1 leaves the head at 1
+	claimed := "grmpkg.com/acme/widgets v1.1.0 h1:zip=\ngrmpkg.com/acme/widgets v1.1.0/go.mod h1:mod=\n"
+	if err := stor.CreateObject(repo, storage.Object{Hash: recordHash(1), Content: []byte(claimed)}); err != nil {
+		t.Fatalf("CreateObject() error = %v", err)
+	}
+	if id, err := New(stor).Add(v("v1.2.0"), "h1:zip=", "h1:mod="); err != nil || id != 2 {
+		t.Errorf("Add() after a failed claim = %d, %v, want 2", id, err)
+	}
+	if id, err := s.Lookup(v("v1.1.0")); err != nil || id != 1 {
+		t.Errorf("Lookup() of the finished record = %d, %v, want 1", id, err)
+	}
+
+	// A server that fails before storing the head still adds the record
+	stor.hash = plumbing.ComputeHash(0, []byte(hashHeadSalt))
+	if _, err := s.Add(v("v1.3.0"), "h1:zip=", "h1:mod="); err == nil {
+		t.Errorf("Add() without storing the head error = nil")
+	}
+	if tree, err := s.tree(); err != nil || tree.N != 4 {
+		t.Errorf("tree() with a stale head = %v, %v, want 4 records", tree, err)
+	}
+	stor.hash = plumbing.ZeroHash
+	if id, err := s.Add(v("v1.3.0"), "h1:zip=", "h1:mod="); err != nil || id != 3 {
+		t.Errorf("Add() of a record past the head = %d, %v, want 3", id, err)
+	}
+	if id, err := s.Add(v("v1.4.0"), "h1:zip=", "h1:mod="); err != nil || id != 4 {
+		t.Errorf("Add() after a stale head = %d, %v, want 4", id, err)
+	}
+
+	records, err := s.ReadRecords(1, 1)
+	if err != nil || string(records[0]) != claimed {
+		t.Errorf("ReadRecords() = %q, %v, want %q", records, err, claimed)
+	}
+	if refs, _ := stor.ListReferences(repo); len(refs) != 0 {
+		t.Errorf("Add() created references %v", refs)
+	}
+}
+
+// hidden is storage that does not find one object, as if another server
+// stored it after it was looked up.
+type hidden struct {
+	*memory.MemoryStorage
+	hash plumbing.Hash
+}
+
+func (h *hidden) GetObject(repo string, hash plumbing.Hash) (storage.Object, error) {
+	if hash == h.hash {
+		h.hash = plumbing.ZeroHash
+		return storage.Object{}, storage.ErrObjectNotFound
+	}
+	return h.MemoryStorage.GetObject(repo, hash)
+}
+
+func TestService_ConcurrentKey(t *testing.T) {
+	config.SetSumDBName("grmpkg.com")
+	config.SetSumDBKey("")
+	stor := &hidden{MemoryStorage: memory.NewMemoryStorage()}
+
+	first, err := New(stor).VerifierKey()
+	if err != nil {
+		t.Fatalf("VerifierKey() error = %v", err)
+	}
+
+	stor.hash = plumbing.ComputeHash(0, []byte(hashKeySalt+"grmpkg.com"))
+	second, err := New(stor).VerifierKey()
+	if err != nil {
+		t.Fatalf("VerifierKey() of the second server error = %v", err)
+	}
+	if second != first {
+		t.Errorf("VerifierKey() of the second server = %q, want %q", second, first)
+	}
+}